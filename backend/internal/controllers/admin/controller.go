@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const bearerPrefix = "Bearer "
+
+// Controller serves the admin API, which is meant to be served on its own listener, not reachable by hosts
+// and clients
+type Controller struct {
+	HostService host.HostService
+	// Token is the bearer token every request has to carry
+	Token string
+}
+
+func (c *Controller) SetUpRoutes(group *gin.RouterGroup) {
+	group.Use(c.authenticate)
+
+	group.GET("hosts", c.ListHosts)
+	group.DELETE("hosts/:hostUuid", c.DisconnectHost)
+	group.GET("transfers", c.ListTransfers)
+	group.DELETE("transfers/:transferId", c.AbortTransfer)
+}
+
+// authenticate rejects requests without the admin token in the Authorization header
+func (c *Controller) authenticate(ctx *gin.Context) {
+	header := ctx.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, bearerPrefix)
+	if !ok || c.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) != 1 {
+		ctx.Header("WWW-Authenticate", "Bearer")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx.Next()
+}
+
+// ListHosts lists the connected hosts with their remote address and how long they have been connected
+//
+// Method: GET
+// Path: /api/v1/admin/hosts
+func (c *Controller) ListHosts(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"hosts": c.HostService.ListHosts()})
+}
+
+// DisconnectHost closes the connection of a host, its running transfers fail
+//
+// Method: DELETE
+// Path: /api/v1/admin/hosts/{hostUuid}
+func (c *Controller) DisconnectHost(ctx *gin.Context) {
+	hostID, err := uuid.Parse(ctx.Param("hostUuid"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ws_errors.InvalidUrlParamsErr.Error()})
+		return
+	}
+
+	err = c.HostService.DisconnectHost(hostID)
+	if errors.Is(err, ws_errors.HostNotFoundErr) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListTransfers lists the running downloads and uploads with the number of bytes relayed so far
+//
+// Method: GET
+// Path: /api/v1/admin/transfers
+func (c *Controller) ListTransfers(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"transfers": c.HostService.ListTransfers()})
+}
+
+// AbortTransfer makes a running download or upload fail
+//
+// Method: DELETE
+// Path: /api/v1/admin/transfers/{transferId}
+func (c *Controller) AbortTransfer(ctx *gin.Context) {
+	transferID, err := uuid.Parse(ctx.Param("transferId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ws_errors.InvalidUrlParamsErr.Error()})
+		return
+	}
+
+	err = c.HostService.AbortTransfer(transferID)
+	if errors.Is(err, host.ErrTransferNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
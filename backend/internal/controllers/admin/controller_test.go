@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubHostService answers ListHosts, other calls panic through the nil interface
+type stubHostService struct {
+	host.HostService
+}
+
+func (s *stubHostService) ListHosts() []host.HostInfo {
+	return []host.HostInfo{}
+}
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	controller := Controller{HostService: &stubHostService{}, Token: "0123456789abcdef"}
+	controller.SetUpRoutes(router.Group("api/v1/admin"))
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"success - valid token", "Bearer 0123456789abcdef", http.StatusOK},
+		{"error - missing token", "", http.StatusUnauthorized},
+		{"error - wrong token", "Bearer 0123456789abcdeg", http.StatusUnauthorized},
+		{"error - not a bearer token", "Basic 0123456789abcdef", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/hosts", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
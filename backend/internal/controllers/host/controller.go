@@ -2,7 +2,6 @@ package host
 
 import (
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -11,6 +10,8 @@ import (
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/app/config"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -19,24 +20,90 @@ import (
 const (
 	hostKeyQueryParam        = "hostKey"
 	uploadFileSizeQueryParam = "uploadFileSize"
+	resumableQueryParam      = "resumable"
+	multipartFileField       = "file"
+	// maxMultipartFraming is how much of a multipart upload body may be taken by the part headers, the boundaries
+	// and the fields other than the file
+	maxMultipartFraming = 64 << 10
+
+	destinationQueryParam         = "destination"
+	destinationResourceQueryParam = "destinationResourceUuid"
+	destinationHostQueryParam     = "destinationHostUuid"
+	conflictPolicyQueryParam      = "onConflict"
+
+	listCursorQueryParam   = "cursor"
+	listPageSizeQueryParam = "pageSize"
+	listSortQueryParam     = "sort"
+	listOrderQueryParam    = "order"
+	listFilterQueryParam   = "filter"
+
+	searchQueryParam      = "q"
+	searchLimitQueryParam = "limit"
 )
 
+var listSortKeys = map[string]host.ListSortKey{
+	"name":     host.ListSortByName,
+	"size":     host.ListSortBySize,
+	"modified": host.ListSortByModified,
+}
+
+var conflictPolicies = map[string]host.ConflictPolicy{
+	"fail":      host.ConflictFail,
+	"overwrite": host.ConflictOverwrite,
+	"rename":    host.ConflictRename,
+}
+
 type Controller struct {
-	HostService       host.HostService
-	WebsocketCfg      config.WebsocketCfg
-	ClientConnFactory clientconn.ClientConnFactory
+	HostService          host.HostService
+	WebsocketCfg         config.WebsocketCfg
+	ClientConnFactory    clientconn.ClientConnFactory
+	ClientSessionFactory clientsession.ClientSessionFactory
+	RateLimits           RateLimits
+}
+
+// RateLimits are the middlewares limiting requests per client IP address, nil ones are skipped
+type RateLimits struct {
+	// HostRegistration limits new hosts connecting
+	HostRegistration gin.HandlerFunc
+	// FailedReconnect limits hosts failing to reconnect, e.g. with a wrong host key
+	FailedReconnect gin.HandlerFunc
+	// ClientRequest limits all other requests
+	ClientRequest gin.HandlerFunc
 }
 
 func (c *Controller) SetUpRoutes(group *gin.RouterGroup) {
-	group.GET("connect", c.HostConnect)
-	group.GET("reconnect/:hostUuid", c.HostReconnect)
-	group.GET("metadata/:hostUuid/:resourceUuid/*pathToResource", c.GetResourceMetadata)
-	group.GET("metadata/:hostUuid/:resourceUuid", c.GetResourceMetadata)
-	group.GET("download/:hostUuid/:resourceUuid/*pathToResource", c.DownloadResource)
-	group.GET("download/:hostUuid/:resourceUuid", c.DownloadResource)
-	group.GET("directory/create/:hostUuid/:resourceUuid/*pathToDirectory", c.CreateDirectory)
-	group.GET("file/create/:hostUuid/:resourceUuid/*pathToFile", c.CreateFile)
-	group.GET("resource/delete/:hostUuid/:resourceUuid/*pathToResource", c.DeleteResource)
+	hostGroup := group.Group("", withoutNil(c.RateLimits.HostRegistration)...)
+	reconnectGroup := group.Group("", withoutNil(c.RateLimits.FailedReconnect)...)
+	clientGroup := group.Group("", withoutNil(c.RateLimits.ClientRequest)...)
+
+	hostGroup.GET("connect", c.HostConnect)
+	reconnectGroup.GET("reconnect/:hostUuid", c.HostReconnect)
+	clientGroup.GET("metadata/:hostUuid/:resourceUuid/*pathToResource", c.GetResourceMetadata)
+	clientGroup.GET("metadata/:hostUuid/:resourceUuid", c.GetResourceMetadata)
+	clientGroup.GET("list/:hostUuid/:resourceUuid/*pathToDirectory", c.ListDirectory)
+	clientGroup.GET("list/:hostUuid/:resourceUuid", c.ListDirectory)
+	clientGroup.GET("download/:hostUuid/:resourceUuid/*pathToResource", c.DownloadResource)
+	clientGroup.GET("download/:hostUuid/:resourceUuid", c.DownloadResource)
+	clientGroup.GET("resume/download/:token", c.ResumeDownload)
+	clientGroup.GET("resume/upload/:token", c.ResumeUpload)
+	clientGroup.GET("directory/create/:hostUuid/:resourceUuid/*pathToDirectory", c.CreateDirectory)
+	clientGroup.GET("file/create/:hostUuid/:resourceUuid/*pathToFile", c.CreateFile)
+	clientGroup.GET("file/download/:hostUuid/:resourceUuid/*pathToFile", c.HttpDownloadFile)
+	clientGroup.HEAD("file/download/:hostUuid/:resourceUuid/*pathToFile", c.HttpDownloadFile)
+	clientGroup.GET("archive/zip/:hostUuid/:resourceUuid/*pathToResource", c.HttpDownloadZipArchive)
+	clientGroup.GET("archive/zip/:hostUuid/:resourceUuid", c.HttpDownloadZipArchive)
+	clientGroup.GET("archive/tar/:hostUuid/:resourceUuid/*pathToResource", c.HttpDownloadTarArchive)
+	clientGroup.GET("archive/tar/:hostUuid/:resourceUuid", c.HttpDownloadTarArchive)
+	clientGroup.GET("archive/tar.gz/:hostUuid/:resourceUuid/*pathToResource", c.HttpDownloadTarGzArchive)
+	clientGroup.GET("archive/tar.gz/:hostUuid/:resourceUuid", c.HttpDownloadTarGzArchive)
+	clientGroup.PUT("file/upload/:hostUuid/:resourceUuid/*pathToFile", c.HttpUploadFile)
+	clientGroup.POST("file/upload/:hostUuid/:resourceUuid/*pathToFile", c.HttpUploadMultipartFile)
+	clientGroup.GET("resource/delete/:hostUuid/:resourceUuid/*pathToResource", c.DeleteResource)
+	clientGroup.GET("resource/move/:hostUuid/:resourceUuid/*pathToResource", c.MoveResource)
+	clientGroup.GET("resource/copy/:hostUuid/:resourceUuid/*pathToResource", c.CopyResource)
+	clientGroup.GET("search/:hostUuid/:resourceUuid", c.HttpSearchIndex)
+	clientGroup.GET("status/:hostUuid", c.HttpHostStatus)
+	clientGroup.GET("session/:hostUuid", c.ClientSession)
 }
 
 // HostConnect
@@ -48,13 +115,13 @@ func (c *Controller) HostConnect(ctx *gin.Context) {
 
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("Failed to upgrade to websocket:", err)
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
 		return
 	}
 
-	err = c.HostService.InitNewHostConnection(ctx.Request.Context(), ws)
+	err = c.HostService.InitNewHostConnection(ctx.Request.Context(), ws, ctx.ClientIP())
 	if err != nil {
-		c.handleConnectionInitError(err, ws)
+		c.handleConnectionInitError(ctx, err, ws)
 	}
 }
 
@@ -67,23 +134,25 @@ func (c *Controller) HostReconnect(ctx *gin.Context) {
 
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("Failed to upgrade to websocket:", err)
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
 		return
 	}
 
 	hostKey, ok := ctx.GetQuery(hostKeyQueryParam)
 	if !ok || len(hostKey) == 0 {
-		c.handleConnectionInitError(ws_errors.MissingOrInvalidRequiredParamsErr, ws)
+		c.handleFailedReconnect(ctx, ws_errors.MissingOrInvalidRequiredParamsErr, ws)
+		return
 	}
 
 	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
 	if hostErr != nil {
-		c.handleConnectionInitError(ws_errors.InvalidUrlParamsErr, ws)
+		c.handleFailedReconnect(ctx, ws_errors.InvalidUrlParamsErr, ws)
+		return
 	}
 
-	err = c.HostService.InitExistingHostConnection(ctx.Request.Context(), ws, hostID, hostKey)
+	err = c.HostService.InitExistingHostConnection(ctx.Request.Context(), ws, ctx.ClientIP(), hostID, hostKey)
 	if err != nil {
-		c.handleConnectionInitError(err, ws)
+		c.handleFailedReconnect(ctx, err, ws)
 	}
 }
 
@@ -96,11 +165,11 @@ func (c *Controller) GetResourceMetadata(ctx *gin.Context) {
 
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("Failed to upgrade to websocket:", err)
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
 		return
 	}
 
-	clientConn := c.ClientConnFactory.NewClientConn(ws, clientconn.DefaultClientConnTimeout)
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
 	defer clientConn.Close()
 
 	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
@@ -111,7 +180,7 @@ func (c *Controller) GetResourceMetadata(ctx *gin.Context) {
 		return
 	}
 
-	resp, err := c.HostService.GetResourceMetadata(hostID, resourceID, pathToResource)
+	resp, err := c.HostService.GetResourceMetadata(ctx.Request.Context(), hostID, resourceID, pathToResource)
 	if err != nil {
 		if errors.Is(err, &ws_errors.WebsocketError{}) {
 			clientConn.SendAndLogError(message_types.Error.Binary(), err.(ws_errors.WebsocketError).Code().Binary())
@@ -125,20 +194,99 @@ func (c *Controller) GetResourceMetadata(ctx *gin.Context) {
 	clientConn.SendAndLogError(resp)
 }
 
+// ListDirectory sends a page of the directory contents. Page size defaults to and is capped at
+// host.MaxListPageSize, sort to name and order to asc. The next page is requested with the next cursor from
+// the response.
+//
+// Method: GET
+// Path: /api/v1/host/list/{hostUuid}/{resourceUuid}/path/to/directory?cursor=0&pageSize=100&sort=name|size|modified&order=asc|desc&filter=xxx
+func (c *Controller) ListDirectory(ctx *gin.Context) {
+	upgrader := c.upgrader()
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
+		return
+	}
+
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
+	defer clientConn.Close()
+
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	pathToDirectory := ctx.Param("pathToDirectory")
+	if hostErr != nil || resourceErr != nil {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.InvalidUrlParams.Binary())
+		return
+	}
+
+	options, err := parseListOptions(ctx)
+	if err != nil {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.MissingOrInvalidRequiredParams.Binary())
+		return
+	}
+
+	resp, err := c.HostService.ListDirectory(ctx.Request.Context(), hostID, resourceID, pathToDirectory, options)
+	if err != nil {
+		var wsErr ws_errors.WebsocketError
+		if errors.As(err, &wsErr) {
+			clientConn.SendAndLogError(message_types.Error.Binary(), wsErr.Code().Binary())
+			return
+		}
+
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.UnknownError.Binary())
+		return
+	}
+
+	clientConn.SendAndLogError(resp)
+}
+
+func parseListOptions(ctx *gin.Context) (host.ListOptions, error) {
+	cursor, err := strconv.ParseUint(ctx.DefaultQuery(listCursorQueryParam, "0"), 10, 32)
+	if err != nil {
+		return host.ListOptions{}, err
+	}
+
+	pageSize, err := strconv.ParseUint(ctx.DefaultQuery(listPageSizeQueryParam, "0"), 10, 16)
+	if err != nil {
+		return host.ListOptions{}, err
+	}
+
+	sortKey, ok := listSortKeys[ctx.DefaultQuery(listSortQueryParam, "name")]
+	if !ok {
+		return host.ListOptions{}, ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	order := ctx.DefaultQuery(listOrderQueryParam, "asc")
+	if order != "asc" && order != "desc" {
+		return host.ListOptions{}, ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	return host.ListOptions{
+		Cursor:     uint32(cursor),
+		PageSize:   uint16(pageSize),
+		SortKey:    sortKey,
+		Descending: order == "desc",
+		NameFilter: ctx.Query(listFilterQueryParam),
+	}, nil
+}
+
 // DownloadResource
 //
 // Method: GET
-// Path: /api/v1/host/download/{hostUuid}/{resourceUuid}/path/to/resource.exe
+// Path: /api/v1/host/download/{hostUuid}/{resourceUuid}/path/to/resource.exe?resumable=true
+//
+// With resumable set to true the client receives a resume token for ResumeDownload
 func (c *Controller) DownloadResource(ctx *gin.Context) {
 	upgrader := c.upgrader()
 
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("Failed to upgrade to websocket:", err)
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
 		return
 	}
 
-	clientConn := c.ClientConnFactory.NewClientConn(ws, clientconn.DefaultClientConnTimeout)
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
 	defer clientConn.Close()
 
 	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
@@ -149,7 +297,11 @@ func (c *Controller) DownloadResource(ctx *gin.Context) {
 		return
 	}
 
-	err = c.HostService.DownloadResource(clientConn, hostID, resourceID, pathToResource)
+	if resumable, _ := strconv.ParseBool(ctx.Query(resumableQueryParam)); resumable {
+		err = c.HostService.DownloadResourceResumable(ctx.Request.Context(), clientConn, hostID, resourceID, pathToResource)
+	} else {
+		err = c.HostService.DownloadResource(ctx.Request.Context(), clientConn, hostID, resourceID, pathToResource)
+	}
 	if err != nil {
 		if errors.Is(err, &ws_errors.WebsocketError{}) {
 			clientConn.SendAndLogError(message_types.Error.Binary(), err.(ws_errors.WebsocketError).Code().Binary())
@@ -161,6 +313,41 @@ func (c *Controller) DownloadResource(ctx *gin.Context) {
 	}
 }
 
+// ResumeDownload
+//
+// Method: GET
+// Path: /api/v1/host/resume/download/{token}
+func (c *Controller) ResumeDownload(ctx *gin.Context) {
+	upgrader := c.upgrader()
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
+		return
+	}
+
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
+	defer clientConn.Close()
+
+	token, tokenErr := uuid.Parse(ctx.Param("token"))
+	if tokenErr != nil {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.InvalidUrlParams.Binary())
+		return
+	}
+
+	err = c.HostService.ResumeDownload(ctx.Request.Context(), clientConn, token)
+	if err != nil {
+		var wsErr ws_errors.WebsocketError
+		if errors.As(err, &wsErr) {
+			clientConn.SendAndLogError(message_types.Error.Binary(), wsErr.Code().Binary())
+			return
+		}
+
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.UnknownError.Binary())
+		return
+	}
+}
+
 // CreateDirectory
 //
 // Method: GET
@@ -170,11 +357,11 @@ func (c *Controller) CreateDirectory(ctx *gin.Context) {
 
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("Failed to upgrade to websocket:", err)
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
 		return
 	}
 
-	clientConn := c.ClientConnFactory.NewClientConn(ws, clientconn.DefaultClientConnTimeout)
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
 	defer clientConn.Close()
 
 	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
@@ -185,7 +372,7 @@ func (c *Controller) CreateDirectory(ctx *gin.Context) {
 		return
 	}
 
-	resp, err := c.HostService.CreateDirectory(hostID, resourceID, pathToDirectory)
+	resp, err := c.HostService.CreateDirectory(ctx.Request.Context(), hostID, resourceID, pathToDirectory)
 	if err != nil {
 		if errors.Is(err, &ws_errors.WebsocketError{}) {
 			clientConn.SendAndLogError(message_types.Error.Binary(), err.(ws_errors.WebsocketError).Code().Binary())
@@ -208,11 +395,11 @@ func (c *Controller) DeleteResource(ctx *gin.Context) {
 
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("Failed to upgrade to websocket:", err)
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
 		return
 	}
 
-	clientConn := c.ClientConnFactory.NewClientConn(ws, clientconn.DefaultClientConnTimeout)
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
 	defer clientConn.Close()
 
 	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
@@ -223,7 +410,7 @@ func (c *Controller) DeleteResource(ctx *gin.Context) {
 		return
 	}
 
-	resp, err := c.HostService.DeleteResource(hostID, resourceID, pathToResource)
+	resp, err := c.HostService.DeleteResource(ctx.Request.Context(), hostID, resourceID, pathToResource)
 	if err != nil {
 		if errors.Is(err, &ws_errors.WebsocketError{}) {
 			clientConn.SendAndLogError(message_types.Error.Binary(), err.(ws_errors.WebsocketError).Code().Binary())
@@ -237,20 +424,160 @@ func (c *Controller) DeleteResource(ctx *gin.Context) {
 	clientConn.SendAndLogError(resp)
 }
 
+// MoveResource moves or renames a resource inside the shared resource. The destination resource defaults to the
+// source one, onConflict to fail.
+//
+// Method: GET
+// Path: /api/v1/host/resource/move/{hostUuid}/{resourceUuid}/path/to/resource?destination=/new/path&onConflict=fail|overwrite|rename&destinationResourceUuid=xxx
+func (c *Controller) MoveResource(ctx *gin.Context) {
+	upgrader := c.upgrader()
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
+		return
+	}
+
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
+	defer clientConn.Close()
+
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	pathToResource := ctx.Param("pathToResource")
+	if hostErr != nil || resourceErr != nil {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.InvalidUrlParams.Binary())
+		return
+	}
+
+	destinationResourceID := resourceID
+	if destinationResourceStr, ok := ctx.GetQuery(destinationResourceQueryParam); ok {
+		destinationResourceID, err = uuid.Parse(destinationResourceStr)
+		if err != nil {
+			clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.MissingOrInvalidRequiredParams.Binary())
+			return
+		}
+	}
+
+	policy, ok := conflictPolicies[ctx.DefaultQuery(conflictPolicyQueryParam, "fail")]
+	if !ok {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.MissingOrInvalidRequiredParams.Binary())
+		return
+	}
+
+	resp, err := c.HostService.MoveResource(
+		ctx.Request.Context(),
+		hostID,
+		resourceID,
+		pathToResource,
+		destinationResourceID,
+		ctx.Query(destinationQueryParam),
+		policy,
+	)
+	if err != nil {
+		var wsErr ws_errors.WebsocketError
+		if errors.As(err, &wsErr) {
+			clientConn.SendAndLogError(message_types.Error.Binary(), wsErr.Code().Binary())
+			return
+		}
+
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.UnknownError.Binary())
+		return
+	}
+
+	clientConn.SendAndLogError(resp)
+}
+
+// CopyResource copies a resource to the destination path. The destination host and resource default to the source
+// ones, onConflict to fail. Copies to another host or resource are streamed through the relay, which may take a while;
+// the client receives ACK once the copy is done.
+//
+// Method: GET
+// Path: /api/v1/host/resource/copy/{hostUuid}/{resourceUuid}/path/to/resource?destination=/new/path&onConflict=fail|overwrite|rename&destinationHostUuid=xxx&destinationResourceUuid=xxx
+func (c *Controller) CopyResource(ctx *gin.Context) {
+	upgrader := c.upgrader()
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
+		return
+	}
+
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
+	defer clientConn.Close()
+
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	pathToResource := ctx.Param("pathToResource")
+	if hostErr != nil || resourceErr != nil {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.InvalidUrlParams.Binary())
+		return
+	}
+
+	destinationHostID := hostID
+	if destinationHostStr, ok := ctx.GetQuery(destinationHostQueryParam); ok {
+		destinationHostID, err = uuid.Parse(destinationHostStr)
+		if err != nil {
+			clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.MissingOrInvalidRequiredParams.Binary())
+			return
+		}
+	}
+
+	destinationResourceID := resourceID
+	if destinationResourceStr, ok := ctx.GetQuery(destinationResourceQueryParam); ok {
+		destinationResourceID, err = uuid.Parse(destinationResourceStr)
+		if err != nil {
+			clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.MissingOrInvalidRequiredParams.Binary())
+			return
+		}
+	}
+
+	policy, ok := conflictPolicies[ctx.DefaultQuery(conflictPolicyQueryParam, "fail")]
+	if !ok {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.MissingOrInvalidRequiredParams.Binary())
+		return
+	}
+
+	err = c.HostService.CopyResource(
+		ctx.Request.Context(),
+		hostID,
+		resourceID,
+		pathToResource,
+		destinationHostID,
+		destinationResourceID,
+		ctx.Query(destinationQueryParam),
+		policy,
+		c.WebsocketCfg.BatchSize,
+	)
+	if err != nil {
+		var wsErr ws_errors.WebsocketError
+		if errors.As(err, &wsErr) {
+			clientConn.SendAndLogError(message_types.Error.Binary(), wsErr.Code().Binary())
+			return
+		}
+
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.UnknownError.Binary())
+		return
+	}
+
+	clientConn.SendAndLogError(message_types.ACK.Binary())
+}
+
 // CreateFile
 //
 // Method: GET
-// Path: /api/v1/host/file/create/{hostUuid}/{resourceUuid}/path/to/file.exe?uploadFileSize=xxx
+// Path: /api/v1/host/file/create/{hostUuid}/{resourceUuid}/path/to/file.exe?uploadFileSize=xxx&resumable=true
+//
+// With resumable set to true the client receives a resume token for ResumeUpload
 func (c *Controller) CreateFile(ctx *gin.Context) {
 	upgrader := c.upgrader()
 
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("Failed to upgrade to websocket:", err)
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
 		return
 	}
 
-	clientConn := c.ClientConnFactory.NewClientConn(ws, clientconn.DefaultClientConnTimeout)
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
 	defer clientConn.Close()
 
 	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
@@ -263,15 +590,21 @@ func (c *Controller) CreateFile(ctx *gin.Context) {
 
 	fileSizeStr, ok := ctx.GetQuery(uploadFileSizeQueryParam)
 	if !ok || len(fileSizeStr) == 0 {
-		c.handleConnectionInitError(ws_errors.MissingOrInvalidRequiredParamsErr, ws)
+		c.handleConnectionInitError(ctx, ws_errors.MissingOrInvalidRequiredParamsErr, ws)
+		return
 	}
 
-	fileSize, err := strconv.ParseUint(fileSizeStr, 10, 32)
+	fileSize, err := strconv.ParseUint(fileSizeStr, 10, 64)
 	if err != nil {
-		c.handleConnectionInitError(ws_errors.MissingOrInvalidRequiredParamsErr, ws)
+		c.handleConnectionInitError(ctx, ws_errors.MissingOrInvalidRequiredParamsErr, ws)
+		return
 	}
 
-	err = c.HostService.CreateFile(clientConn, hostID, resourceID, pathToFile, uint32(fileSize))
+	if resumable, _ := strconv.ParseBool(ctx.Query(resumableQueryParam)); resumable {
+		err = c.HostService.CreateFileResumable(ctx.Request.Context(), clientConn, hostID, resourceID, pathToFile, fileSize)
+	} else {
+		err = c.HostService.CreateFile(ctx.Request.Context(), clientConn, hostID, resourceID, pathToFile, fileSize)
+	}
 	if err != nil {
 		var wsErr ws_errors.WebsocketError
 		if errors.As(err, &wsErr) {
@@ -284,6 +617,69 @@ func (c *Controller) CreateFile(ctx *gin.Context) {
 	}
 }
 
+// ResumeUpload
+//
+// Method: GET
+// Path: /api/v1/host/resume/upload/{token}
+func (c *Controller) ResumeUpload(ctx *gin.Context) {
+	upgrader := c.upgrader()
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
+		return
+	}
+
+	clientConn := c.ClientConnFactory.NewClientConn(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
+	defer clientConn.Close()
+
+	token, tokenErr := uuid.Parse(ctx.Param("token"))
+	if tokenErr != nil {
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.InvalidUrlParams.Binary())
+		return
+	}
+
+	err = c.HostService.ResumeUpload(ctx.Request.Context(), clientConn, token)
+	if err != nil {
+		var wsErr ws_errors.WebsocketError
+		if errors.As(err, &wsErr) {
+			clientConn.SendAndLogError(message_types.Error.Binary(), wsErr.Code().Binary())
+			return
+		}
+
+		clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.UnknownError.Binary())
+		return
+	}
+}
+
+// ClientSession
+//
+// Method: GET
+// Path: /api/v1/host/session/{hostUuid}
+func (c *Controller) ClientSession(ctx *gin.Context) {
+	upgrader := c.upgrader()
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to upgrade to websocket", "error", err)
+		return
+	}
+
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	if hostErr != nil {
+		c.handleConnectionInitError(ctx, ws_errors.InvalidUrlParamsErr, ws)
+		return
+	}
+
+	session := c.ClientSessionFactory.NewClientSession(ctx.Request.Context(), ws, ctx.ClientIP(), clientconn.DefaultClientConnTimeout)
+	defer session.Close()
+
+	err = c.HostService.ServeClientSession(ctx.Request.Context(), session, hostID)
+	if err != nil && !errors.Is(err, ws_errors.ConnectionClosedErr) {
+		logging.FromContext(ctx.Request.Context()).Warn("client session ended with an error", logging.HostIdKey, hostID, "error", err)
+	}
+}
+
 func (c *Controller) upgrader() websocket.Upgrader {
 	return websocket.Upgrader{
 		ReadBufferSize:  c.WebsocketCfg.BatchSize,
@@ -295,17 +691,37 @@ func (c *Controller) upgrader() websocket.Upgrader {
 	}
 }
 
-func (c *Controller) handleConnectionInitError(err error, ws *websocket.Conn) {
+// handleFailedReconnect reports the error to the host and records it on the gin context, so that the reconnect is
+// counted as failed by the rate limit
+func (c *Controller) handleFailedReconnect(ctx *gin.Context, err error, ws *websocket.Conn) {
+	_ = ctx.Error(err)
+	c.handleConnectionInitError(ctx, err, ws)
+}
+
+func (c *Controller) handleConnectionInitError(ctx *gin.Context, err error, ws *websocket.Conn) {
+	logger := logging.FromContext(ctx.Request.Context())
+
 	if errors.Is(err, &ws_errors.WebsocketError{}) {
 		errorMsg := message_types.Error.Binary()
 		errorMsg = append(errorMsg, err.(ws_errors.WebsocketError).Code().Binary()...)
 		err = ws.WriteMessage(websocket.BinaryMessage, errorMsg)
 		if err != nil {
-			log.Printf("Failed to write %s message: %v\n", err.(ws_errors.WebsocketError).Error(), err)
+			logger.Warn("failed to write the error message", "error", err)
 		}
 
 	}
 
 	_ = ws.Close()
-	log.Printf("Error during initialisation of a connection: %v\n", err)
+	logger.Warn("connection initialisation failed", "error", err)
+}
+
+func withoutNil(handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	var result []gin.HandlerFunc
+	for _, handler := range handlers {
+		if handler != nil {
+			result = append(result, handler)
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,376 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// HttpDownloadFile serves a file over plain HTTP, including Range and If-Range requests
+//
+// Method: GET, HEAD
+// Path: /api/v1/host/file/download/{hostUuid}/{resourceUuid}/path/to/file.exe
+func (c *Controller) HttpDownloadFile(ctx *gin.Context) {
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	pathToFile := ctx.Param("pathToFile")
+	if hostErr != nil || resourceErr != nil {
+		respondWithError(ctx, ws_errors.InvalidUrlParamsErr)
+		return
+	}
+
+	// An aborted download cancels the request
+	requestCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+
+	reader, err := c.HostService.OpenResourceReader(requestCtx, hostID, resourceID, pathToFile, ctx.ClientIP(), cancel)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			logging.FromContext(ctx.Request.Context()).Warn("failed to end the download stream", logging.HostIdKey, hostID, "path", pathToFile, "error", err)
+		}
+	}()
+
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": reader.Name()}))
+	http.ServeContent(ctx.Writer, ctx.Request, reader.Name(), reader.ModTime(), reader)
+}
+
+// HttpDownloadZipArchive streams a directory, or a single file, as a ZIP archive. The archive is written while
+// the files are downloaded from the host, so its size is not known upfront and ranges are not supported.
+//
+// Method: GET
+// Path: /api/v1/host/archive/zip/{hostUuid}/{resourceUuid}/path/to/directory
+func (c *Controller) HttpDownloadZipArchive(ctx *gin.Context) {
+	c.downloadArchive(ctx, "application/zip", ".zip", func(archive *host.ResourceArchive, w io.Writer) error {
+		return archive.WriteZip(w)
+	})
+}
+
+// HttpDownloadTarArchive streams a directory, or a single file, as a tar archive, keeping the modification times
+// reported by the host
+//
+// Method: GET
+// Path: /api/v1/host/archive/tar/{hostUuid}/{resourceUuid}/path/to/directory
+func (c *Controller) HttpDownloadTarArchive(ctx *gin.Context) {
+	c.downloadArchive(ctx, "application/x-tar", ".tar", func(archive *host.ResourceArchive, w io.Writer) error {
+		return archive.WriteTar(w, false)
+	})
+}
+
+// HttpDownloadTarGzArchive streams a directory, or a single file, as a gzip compressed tar archive
+//
+// Method: GET
+// Path: /api/v1/host/archive/tar.gz/{hostUuid}/{resourceUuid}/path/to/directory
+func (c *Controller) HttpDownloadTarGzArchive(ctx *gin.Context) {
+	c.downloadArchive(ctx, "application/gzip", ".tar.gz", func(archive *host.ResourceArchive, w io.Writer) error {
+		return archive.WriteTar(w, true)
+	})
+}
+
+func (c *Controller) downloadArchive(
+	ctx *gin.Context,
+	contentType string,
+	extension string,
+	write func(archive *host.ResourceArchive, w io.Writer) error,
+) {
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	pathToResource := ctx.Param("pathToResource")
+	if hostErr != nil || resourceErr != nil {
+		respondWithError(ctx, ws_errors.InvalidUrlParamsErr)
+		return
+	}
+
+	requestCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+
+	archive, err := c.HostService.OpenResourceArchive(requestCtx, hostID, resourceID, pathToResource, ctx.ClientIP(), cancel)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archive.Name() + extension}))
+	ctx.Status(http.StatusOK)
+
+	err = write(archive, ctx.Writer)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to stream the archive", logging.HostIdKey, hostID, "path", pathToResource, "error", err)
+		abortResponse(ctx)
+	}
+}
+
+// HttpUploadFile creates a file on the host from the request body. The body size has to be known upfront,
+// so chunked requests without Content-Length are refused.
+//
+// Method: PUT
+// Path: /api/v1/host/file/upload/{hostUuid}/{resourceUuid}/path/to/file.exe
+func (c *Controller) HttpUploadFile(ctx *gin.Context) {
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	pathToFile := ctx.Param("pathToFile")
+	if hostErr != nil || resourceErr != nil {
+		respondWithError(ctx, ws_errors.InvalidUrlParamsErr)
+		return
+	}
+
+	if ctx.Request.ContentLength < 0 {
+		ctx.JSON(http.StatusLengthRequired, gin.H{"error": "Content-Length is required"})
+		return
+	}
+
+	c.uploadFile(ctx, hostID, resourceID, pathToFile, ctx.Request.ContentLength, ctx.Request.Body)
+}
+
+// HttpUploadMultipartFile creates a file on the host from the "file" part of a multipart form. The part is streamed
+// to the host as it is received, so its size has to be given upfront in the query. The body may not be longer than
+// the file and the multipart framing around it.
+//
+// Method: POST
+// Path: /api/v1/host/file/upload/{hostUuid}/{resourceUuid}/path/to/file.exe?uploadFileSize=xxx
+func (c *Controller) HttpUploadMultipartFile(ctx *gin.Context) {
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	pathToFile := ctx.Param("pathToFile")
+	if hostErr != nil || resourceErr != nil {
+		respondWithError(ctx, ws_errors.InvalidUrlParamsErr)
+		return
+	}
+
+	fileSize, err := strconv.ParseInt(ctx.Query(uploadFileSizeQueryParam), 10, 64)
+	if err != nil || fileSize < 0 || fileSize > math.MaxInt64-maxMultipartFraming {
+		respondWithError(ctx, ws_errors.MissingOrInvalidRequiredParamsErr)
+		return
+	}
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, fileSize+maxMultipartFraming)
+
+	reader, err := ctx.Request.MultipartReader()
+	if err != nil {
+		respondWithError(ctx, ws_errors.MissingOrInvalidRequiredParamsErr)
+		return
+	}
+
+	file, err := nextFilePart(reader)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+	defer file.Close()
+
+	c.uploadFile(ctx, hostID, resourceID, pathToFile, fileSize, &sizedPart{part: file, remaining: fileSize})
+}
+
+var errUploadBodyTooLong = errors.New("upload body is longer than the declared file size")
+
+// sizedPart reads a file part which has to be as long as its declared size. Reading its last byte fails with
+// errUploadBodyTooLong if more follow, so the end of the file is not sent to the host.
+type sizedPart struct {
+	part      io.Reader
+	remaining int64
+}
+
+func (p *sizedPart) Read(b []byte) (int, error) {
+	if p.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	n, err := p.part.Read(b[:min(int64(len(b)), p.remaining)])
+	p.remaining -= int64(n)
+	if p.remaining > 0 || err != nil {
+		return n, err
+	}
+
+	var next [1]byte
+	for {
+		extra, err := p.part.Read(next[:])
+		if extra > 0 {
+			return n, errUploadBodyTooLong
+		}
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// nextFilePart skips the parts of the form up to the file part
+func nextFilePart(reader *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, ws_errors.MissingOrInvalidRequiredParamsErr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == multipartFileField {
+			return part, nil
+		}
+		_ = part.Close()
+	}
+}
+
+func (c *Controller) uploadFile(
+	ctx *gin.Context,
+	hostID uuid.UUID,
+	resourceID uuid.UUID,
+	pathToFile string,
+	fileSize int64,
+	body io.Reader,
+) {
+	// An aborted upload cancels the request
+	requestCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+
+	err := c.HostService.UploadFile(requestCtx, hostID, resourceID, pathToFile, uint64(fileSize), c.WebsocketCfg.BatchSize, body, ctx.ClientIP(), cancel)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusCreated)
+}
+
+// HttpSearchIndex searches the names in the file index published by the host. The index outlives the host
+// connection, so it also answers while the host is offline.
+//
+// Method: GET
+// Path: /api/v1/host/search/{hostUuid}/{resourceUuid}?q={query}&limit={limit}
+func (c *Controller) HttpSearchIndex(ctx *gin.Context) {
+	hostID, hostErr := uuid.Parse(ctx.Param("hostUuid"))
+	resourceID, resourceErr := uuid.Parse(ctx.Param("resourceUuid"))
+	if hostErr != nil || resourceErr != nil {
+		respondWithError(ctx, ws_errors.InvalidUrlParamsErr)
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery(searchLimitQueryParam, "0"))
+	if err != nil {
+		respondWithError(ctx, ws_errors.MissingOrInvalidRequiredParamsErr)
+		return
+	}
+
+	results, err := c.HostService.SearchIndex(ctx.Request.Context(), hostID, resourceID, ctx.Query(searchQueryParam), limit)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	if results == nil {
+		results = []file_index_repository.IndexEntry{}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// HttpHostStatus tells whether the host is online, since when or when it has last been seen otherwise, how many
+// transfers it has running and which protocol features it has announced. Offline hosts which have never been seen
+// disconnecting are not found.
+//
+// Method: GET
+// Path: /api/v1/host/status/{hostUuid}
+func (c *Controller) HttpHostStatus(ctx *gin.Context) {
+	hostID, err := uuid.Parse(ctx.Param("hostUuid"))
+	if err != nil {
+		respondWithError(ctx, ws_errors.InvalidUrlParamsErr)
+		return
+	}
+
+	status, err := c.HostService.GetHostStatus(ctx.Request.Context(), hostID)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+// abortResponse closes the connection of a response whose status has already been sent, so that the client sees
+// the body as cut short instead of complete
+func abortResponse(ctx *gin.Context) {
+	conn, _, err := ctx.Writer.Hijack()
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("failed to abort the response", "error", err)
+		return
+	}
+
+	_ = conn.Close()
+}
+
+func respondWithError(ctx *gin.Context, err error) {
+	countClientError(err)
+	ctx.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+}
+
+// countClientError counts the error in the client errors metric under its error code, like the errors sent over
+// WebSockets
+func countClientError(err error) {
+	code := metrics.NoCodeLabel
+	var wsErr ws_errors.WebsocketError
+	if errors.As(err, &wsErr) {
+		code = metrics.CodeLabel(uint16(wsErr.Code()))
+	}
+
+	metrics.ClientErrors.WithLabelValues(code).Inc()
+}
+
+// httpStatusFromError maps errors returned by the host service to the HTTP status codes of the plain HTTP endpoints
+func httpStatusFromError(err error) int {
+	if errors.Is(err, host.ErrUploadBodyTooShort) {
+		return http.StatusBadRequest
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, errUploadBodyTooLong) || errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	var wsErr ws_errors.WebsocketError
+	if !errors.As(err, &wsErr) {
+		return http.StatusInternalServerError
+	}
+
+	switch wsErr.Code() {
+	case ws_errors.HostNotFound, ws_errors.ResourceNotFound:
+		return http.StatusNotFound
+	case ws_errors.InvalidUrlParams, ws_errors.MissingOrInvalidRequiredParams, ws_errors.InvalidPath:
+		return http.StatusBadRequest
+	case ws_errors.OperationNotAllowed, ws_errors.OperationForbidden, ws_errors.CrossShareMoveNotAllowed:
+		return http.StatusForbidden
+	case ws_errors.DestinationExists:
+		return http.StatusConflict
+	case ws_errors.ResourceNotDownloadable:
+		return http.StatusUnprocessableEntity
+	case ws_errors.FileTooLargeForHost:
+		return http.StatusRequestEntityTooLarge
+	case ws_errors.HostSaturated, ws_errors.ServerShuttingDown:
+		return http.StatusServiceUnavailable
+	case ws_errors.RateLimited:
+		return http.StatusTooManyRequests
+	case ws_errors.Timeout:
+		return http.StatusGatewayTimeout
+	case ws_errors.ConnectionClosed, ws_errors.InvalidMessageBody, ws_errors.UnexpectedMessageType:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
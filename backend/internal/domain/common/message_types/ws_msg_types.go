@@ -32,6 +32,23 @@ const (
 	CreateFileInitResponse     WebsocketMessageType = 15
 	CreateFileStreamEnd        WebsocketMessageType = 16
 	CreateFileHostChunkRequest WebsocketMessageType = 17
+	CreateFileChunkRequest     WebsocketMessageType = 18
+	CreateFileChunk            WebsocketMessageType = 19
+	DownloadWindowRequest      WebsocketMessageType = 20
+	DownloadWindowResponse     WebsocketMessageType = 21
+	CreateFileCreditGrant      WebsocketMessageType = 22
+	DownloadResumeToken        WebsocketMessageType = 23
+	CreateFileStatusQuery      WebsocketMessageType = 24
+	CreateFileStatusResponse   WebsocketMessageType = 25
+	UploadResumeToken          WebsocketMessageType = 26
+	CreateFileInitRequest64    WebsocketMessageType = 27
+	MoveResource               WebsocketMessageType = 28
+	CopyResource               WebsocketMessageType = 29
+	ListDirectoryQuery         WebsocketMessageType = 30
+	ListDirectoryResponse      WebsocketMessageType = 31
+	IndexUpdate                WebsocketMessageType = 32
+	BandwidthLimit             WebsocketMessageType = 33
+	ServerGoingAway            WebsocketMessageType = 34
 )
 
 func GetMsgType(msg []byte) (WebsocketMessageType, error) {
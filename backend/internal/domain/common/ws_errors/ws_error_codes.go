@@ -19,4 +19,23 @@ const (
 	MissingOrInvalidRequiredParams WebsocketErrorCode = 7
 	HostAlreadyConnected           WebsocketErrorCode = 8
 	InvalidHostKey                 WebsocketErrorCode = 9
+
+	// Codes 10 - 14 are reported by hosts
+	ResourceNotFound    WebsocketErrorCode = 10
+	OperationNotAllowed WebsocketErrorCode = 11
+	InvalidPath         WebsocketErrorCode = 13
+	OperationForbidden  WebsocketErrorCode = 14
+
+	InvalidResumeToken      WebsocketErrorCode = 15
+	ResourceNotDownloadable WebsocketErrorCode = 16
+	FileTooLargeForHost     WebsocketErrorCode = 17
+	// DestinationExists is reported by hosts when a move would replace an existing resource
+	DestinationExists        WebsocketErrorCode = 18
+	CrossShareMoveNotAllowed WebsocketErrorCode = 19
+	HostSaturated            WebsocketErrorCode = 20
+	RateLimited              WebsocketErrorCode = 21
+	TooManyStreams           WebsocketErrorCode = 22
+	StreamBufferOverflow     WebsocketErrorCode = 23
+	// ServerShuttingDown refuses transfers started while the server is draining before it shuts down
+	ServerShuttingDown WebsocketErrorCode = 24
 )
@@ -1,5 +1,7 @@
 package ws_errors
 
+import "fmt"
+
 type WebsocketError struct {
 	code WebsocketErrorCode
 	msg  string
@@ -57,3 +59,61 @@ var InvalidHostKeyErr = WebsocketError{
 	code: InvalidHostKey,
 	msg:  "invalid host key error",
 }
+
+var InvalidResumeTokenErr = WebsocketError{
+	code: InvalidResumeToken,
+	msg:  "invalid resume token error",
+}
+
+var ResourceNotDownloadableErr = WebsocketError{
+	code: ResourceNotDownloadable,
+	msg:  "resource not downloadable error",
+}
+
+var FileTooLargeForHostErr = WebsocketError{
+	code: FileTooLargeForHost,
+	msg:  "file too large for host error",
+}
+
+var DestinationExistsErr = WebsocketError{
+	code: DestinationExists,
+	msg:  "destination exists error",
+}
+
+var CrossShareMoveNotAllowedErr = WebsocketError{
+	code: CrossShareMoveNotAllowed,
+	msg:  "cross share move not allowed error",
+}
+
+var HostSaturatedErr = WebsocketError{
+	code: HostSaturated,
+	msg:  "host saturated error",
+}
+
+var RateLimitedErr = WebsocketError{
+	code: RateLimited,
+	msg:  "rate limited error",
+}
+
+var TooManyStreamsErr = WebsocketError{
+	code: TooManyStreams,
+	msg:  "too many streams error",
+}
+
+var StreamBufferOverflowErr = WebsocketError{
+	code: StreamBufferOverflow,
+	msg:  "stream buffer overflow error",
+}
+
+var ServerShuttingDownErr = WebsocketError{
+	code: ServerShuttingDown,
+	msg:  "server shutting down error",
+}
+
+// NewHostError returns the error for a code the host has reported in an Error message
+func NewHostError(code WebsocketErrorCode) WebsocketError {
+	return WebsocketError{
+		code: code,
+		msg:  fmt.Sprintf("host error %d", code),
+	}
+}
@@ -0,0 +1,56 @@
+package host
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/google/uuid"
+)
+
+// HostInfo describes a connected host for the admin API
+type HostInfo struct {
+	Id          uuid.UUID `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// ConnectionAgeSeconds is how long the host has been connected
+	ConnectionAgeSeconds int64 `json:"connection_age_seconds"`
+	// RttMillis is the last measured round trip time, 0 until the host has answered a ping
+	RttMillis       int64    `json:"rtt_ms"`
+	ActiveTransfers int      `json:"active_transfers"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+// ListHosts returns the connected hosts, longest connected first
+func (s *defaultConnectionService) ListHosts() []HostInfo {
+	now := time.Now()
+	hosts := []HostInfo{}
+	for hostId, hostConn := range s.hostMap.All() {
+		connectedAt := hostConn.ConnectedAt()
+		hosts = append(hosts, HostInfo{
+			Id:                   hostId,
+			RemoteAddr:           hostConn.RemoteAddr(),
+			ConnectedAt:          connectedAt.UTC(),
+			ConnectionAgeSeconds: int64(now.Sub(connectedAt).Seconds()),
+			RttMillis:            hostConn.RTT().Milliseconds(),
+			ActiveTransfers:      s.transfers.count(hostId),
+			Capabilities:         capabilityList(hostConn.Capabilities()),
+		})
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].ConnectedAt.Before(hosts[j].ConnectedAt)
+	})
+	return hosts
+}
+
+// DisconnectHost closes the connection of the host, failing its running transfers. The host may reconnect.
+func (s *defaultConnectionService) DisconnectHost(hostUuid uuid.UUID) error {
+	hostConn, ok := s.hostMap.Get(hostUuid)
+	if !ok {
+		return ws_errors.HostNotFoundErr
+	}
+
+	hostConn.Close()
+	return nil
+}
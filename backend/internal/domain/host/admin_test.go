@@ -0,0 +1,72 @@
+package host
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListHosts(t *testing.T) {
+	t.Run("success - hosts are listed longest connected first", func(t *testing.T) {
+		oldHostId := uuid.New()
+		newHostId := uuid.New()
+		oldConnectedAt := time.Now().Add(-time.Hour)
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostMap.On("All").Return(map[uuid.UUID]hostconn.HostConn{
+			newHostId: &hostconn.MockConn{StartedAt: time.Now(), Addr: "10.0.0.2"},
+			oldHostId: &hostconn.MockConn{
+				StartedAt:        oldConnectedAt,
+				Addr:             "10.0.0.1",
+				RoundTripTime:    40 * time.Millisecond,
+				HostCapabilities: hostconn.CapabilityLargeFiles,
+			},
+		})
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		hosts := svc.ListHosts()
+
+		require.Len(t, hosts, 2)
+		assert.Equal(t, HostInfo{
+			Id:                   oldHostId,
+			RemoteAddr:           "10.0.0.1",
+			ConnectedAt:          oldConnectedAt.UTC(),
+			ConnectionAgeSeconds: 3600,
+			RttMillis:            40,
+			Capabilities:         []string{"large_files"},
+		}, hosts[0])
+		assert.Equal(t, newHostId, hosts[1].Id)
+		assert.Equal(t, "10.0.0.2", hosts[1].RemoteAddr)
+	})
+}
+
+func TestDisconnectHost(t *testing.T) {
+	t.Run("success - host connection is closed", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockConn := &hostconn.MockConn{}
+		defer mockConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockConn, true)
+		mockConn.On("Close").Return().Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		assert.NoError(t, svc.DisconnectHost(hostId))
+	})
+
+	t.Run("error - host not connected", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostMap.On("Get", hostId).Return(nil, false)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		assert.ErrorIs(t, svc.DisconnectHost(hostId), ws_errors.HostNotFoundErr)
+	})
+}
@@ -0,0 +1,237 @@
+package host
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/google/uuid"
+)
+
+const (
+	tarDirectoryMode = 0o755
+	tarFileMode      = 0o644
+)
+
+// ResourceArchive packs a resource shared by a host, a directory with all of its descendants or a single file,
+// into an archive. The archive is written while the files are pulled from the host one by one through their own
+// download streams, so nothing is buffered by the relay.
+type ResourceArchive struct {
+	// ctx is the context of the request the archive is made for, its files are read with it
+	ctx          context.Context
+	service      *defaultConnectionService
+	hostUuid     uuid.UUID
+	resourceUuid uuid.UUID
+	path         string
+	root         resourceMetadataDto
+	// clientIp and cancel are passed on to the readers of the files, see OpenResourceReader
+	clientIp string
+	cancel   func()
+}
+
+// archiveEntryFunc is called for every resource in the archive, parents before their children. Name is the path
+// of the resource inside the archive.
+type archiveEntryFunc func(name string, metadata resourceMetadataDto, pathToResource string) error
+
+// OpenResourceArchive reads the metadata of the archived resource, so that a missing or encrypted resource is
+// reported before anything is written. Errors reported by the host are returned as host errors carrying its error code.
+// The files are read for clientIp, like with OpenResourceReader, and archives for a client are refused the same way
+// while the server is draining.
+func (s *defaultConnectionService) OpenResourceArchive(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+	clientIp string,
+	cancel func(),
+) (*ResourceArchive, error) {
+	if clientIp != "" {
+		if err := s.refuseWhileDraining(); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata, err := s.queryResourceMetadata(ctx, hostUuid, resourceUuid, pathToResource)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Kind != fileKind && metadata.Kind != directoryKind {
+		return nil, ws_errors.ResourceNotDownloadableErr
+	}
+
+	return &ResourceArchive{
+		ctx:          ctx,
+		service:      s,
+		hostUuid:     hostUuid,
+		resourceUuid: resourceUuid,
+		path:         pathToResource,
+		root:         metadata,
+		clientIp:     clientIp,
+		cancel:       cancel,
+	}, nil
+}
+
+// Name returns the name of the archived resource, which is also the top level entry of the archive
+func (a *ResourceArchive) Name() string {
+	if !isValidArchiveName(a.root.Name) {
+		return a.resourceUuid.String()
+	}
+
+	return a.root.Name
+}
+
+// WriteZip writes the resource to w as a ZIP archive. Files are stored without compression, as shared files are
+// mostly media that is compressed already. Zip64 records are written once a file or the whole archive outgrows
+// the classic format. An error returned after the first write leaves a truncated archive in w.
+func (a *ResourceArchive) WriteZip(w io.Writer) error {
+	zipWriter := zip.NewWriter(w)
+
+	err := a.walk(func(name string, metadata resourceMetadataDto, pathToResource string) error {
+		if metadata.Kind == directoryKind {
+			_, err := zipWriter.CreateHeader(&zip.FileHeader{
+				Name:     name + "/",
+				Method:   zip.Store,
+				Modified: metadata.modTime(),
+			})
+			return err
+		}
+
+		return a.copyFile(pathToResource, func(file *ResourceReader) (io.Writer, error) {
+			return zipWriter.CreateHeader(&zip.FileHeader{
+				Name:               name,
+				Method:             zip.Store,
+				Modified:           file.ModTime(),
+				UncompressedSize64: uint64(file.Size()),
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// WriteTar writes the resource to w as a tar archive, compressed with gzip if compress is set. Modification times
+// reported by the host are kept, resources without one get the Unix epoch. An error returned after the first write
+// leaves a truncated archive in w.
+func (a *ResourceArchive) WriteTar(w io.Writer, compress bool) error {
+	var gzipWriter *gzip.Writer
+	if compress {
+		gzipWriter = gzip.NewWriter(w)
+		w = gzipWriter
+	}
+
+	tarWriter := tar.NewWriter(w)
+
+	err := a.walk(func(name string, metadata resourceMetadataDto, pathToResource string) error {
+		if metadata.Kind == directoryKind {
+			return tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     tarDirectoryMode,
+				ModTime:  metadata.modTime(),
+			})
+		}
+
+		return a.copyFile(pathToResource, func(file *ResourceReader) (io.Writer, error) {
+			err := tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     tarFileMode,
+				Size:     file.Size(),
+				ModTime:  file.ModTime(),
+			})
+			return tarWriter, err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tarWriter.Close()
+	if err != nil || gzipWriter == nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+// walk calls fn for the archived resource and all of its descendants. Metadata of directories is queried
+// just before they are visited, files are left to fn.
+func (a *ResourceArchive) walk(fn archiveEntryFunc) error {
+	return a.walkResource(a.Name(), a.path, a.root, fn)
+}
+
+func (a *ResourceArchive) walkResource(
+	name string,
+	pathToResource string,
+	metadata resourceMetadataDto,
+	fn archiveEntryFunc,
+) error {
+	err := fn(name, metadata, pathToResource)
+	if err != nil {
+		return err
+	}
+
+	if metadata.Kind != directoryKind {
+		return nil
+	}
+
+	for _, child := range metadata.Contents {
+		// Names come from the host and must not be able to escape the archived directory
+		if !isValidArchiveName(child.Name) {
+			return ws_errors.InvalidMessageBodyErr
+		}
+
+		childPath := path.Join(pathToResource, child.Name)
+		childMetadata := child
+		switch child.Kind {
+		case fileKind:
+		case directoryKind:
+			childMetadata, err = a.service.queryResourceMetadata(a.ctx, a.hostUuid, a.resourceUuid, childPath)
+			if err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+
+		err = a.walkResource(name+"/"+child.Name, childPath, childMetadata, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyFile copies a file from the host through a download stream to the writer returned by createEntry. The entry
+// is created only once the stream is open, so that it can be described with the metadata of the file.
+func (a *ResourceArchive) copyFile(pathToFile string, createEntry func(file *ResourceReader) (io.Writer, error)) error {
+	reader, err := a.service.openResourceReader(a.ctx, a.hostUuid, a.resourceUuid, pathToFile, a.clientIp, a.cancel)
+	if err != nil {
+		return err
+	}
+
+	entryWriter, err := createEntry(reader)
+	if err == nil {
+		_, err = io.Copy(entryWriter, reader)
+	}
+	if err != nil {
+		_ = reader.Close()
+		return err
+	}
+
+	return reader.Close()
+}
+
+func isValidArchiveName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\000")
+}
@@ -0,0 +1,231 @@
+package host
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resourceQuery(msgType message_types.WebsocketMessageType, resourceId uuid.UUID, path string) [][]byte {
+	return [][]byte{
+		msgType.Binary(),
+		helpers.UUIDToBinary(resourceId),
+		[]byte(path + "\000"),
+	}
+}
+
+func itemMetadataResponse(t *testing.T, item resourceMetadataDto) []byte {
+	itemJson, err := json.Marshal(item)
+	require.NoError(t, err)
+
+	resp := append(message_types.MetadataResponse.Binary(), 0)
+	return append(resp, itemJson...)
+}
+
+func directoryMetadataResponse(t *testing.T, name string, contents ...resourceMetadataDto) []byte {
+	return itemMetadataResponse(t, resourceMetadataDto{Name: name, Kind: directoryKind, Contents: contents})
+}
+
+// expectFileDownload prepares mocks for reading a file of at most one chunk through a download stream
+func expectFileDownload(
+	t *testing.T,
+	mockHostConn *hostconn.MockConn,
+	resourceId uuid.UUID,
+	path string,
+	streamId uint32,
+	modified int64,
+	content ...byte,
+) {
+	metadataResp := itemMetadataResponse(t, resourceMetadataDto{Kind: fileKind, Size: int64(len(content)), Modified: modified})
+	mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, path)).Return(metadataResp, nil).Once()
+	initResp := append(downloadInitResponse(streamId, 1), 0)
+	mockHostConn.On("Query", resourceQuery(message_types.DownloadInitRequest, resourceId, path)).Return(initResp, nil).Once()
+	if len(content) > 0 {
+		onBulkQuery(mockHostConn, chunkQuery(streamId, 0)).Return(chunkResponse(content...), nil).Once()
+	}
+	mockHostConn.On("Query", completionQuery(streamId)).Return(message_types.ACK.Binary(), nil).Once()
+}
+
+func readZipArchive(t *testing.T, archive []byte) map[string][]byte {
+	zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+
+	entries := map[string][]byte{}
+	for _, file := range zipReader.File {
+		entryReader, err := file.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(entryReader)
+		require.NoError(t, err)
+		entries[file.Name] = content
+	}
+
+	return entries
+}
+
+func TestResourceArchive(t *testing.T) {
+	t.Run("success - zip of a directory tree", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		rootResp := directoryMetadataResponse(t, "album",
+			resourceMetadataDto{Name: "a.txt", Kind: fileKind},
+			resourceMetadataDto{Name: "sub", Kind: directoryKind},
+		)
+		mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, "/album")).Return(rootResp, nil).Once()
+		expectFileDownload(t, mockHostConn, resourceId, "/album/a.txt", 1, 0, 1, 2, 3)
+		subResp := directoryMetadataResponse(t, "sub",
+			resourceMetadataDto{Name: "b.txt", Kind: fileKind},
+			resourceMetadataDto{Name: "empty", Kind: directoryKind},
+		)
+		mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, "/album/sub")).Return(subResp, nil).Once()
+		expectFileDownload(t, mockHostConn, resourceId, "/album/sub/b.txt", 2, 0, 4, 5)
+		emptyResp := directoryMetadataResponse(t, "empty")
+		mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, "/album/sub/empty")).Return(emptyResp, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		archive, err := svc.OpenResourceArchive(context.Background(), hostId, resourceId, "/album", "", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "album", archive.Name())
+
+		var buf bytes.Buffer
+		require.NoError(t, archive.WriteZip(&buf))
+
+		assert.Equal(t, map[string][]byte{
+			"album/":           {},
+			"album/a.txt":      {1, 2, 3},
+			"album/sub/":       {},
+			"album/sub/b.txt":  {4, 5},
+			"album/sub/empty/": {},
+		}, readZipArchive(t, buf.Bytes()))
+	})
+
+	t.Run("success - zip of a single file", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, 0, fileKind, 2), nil).Once()
+		expectFileDownload(t, mockHostConn, resourceId, "aaa", 1, 0, 7, 8)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		archive, err := svc.OpenResourceArchive(context.Background(), hostId, resourceId, "aaa", "", nil)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, archive.WriteZip(&buf))
+		assert.Equal(t, map[string][]byte{"aaa": {7, 8}}, readZipArchive(t, buf.Bytes()))
+	})
+
+	t.Run("success - tar keeps modification times", func(t *testing.T) {
+		for _, compress := range []bool{false, true} {
+			hostId := uuid.New()
+			resourceId := uuid.New()
+			mockHostMap := &hostmap.MockHostMap{}
+			mockHostConn := &hostconn.MockConn{}
+
+			mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+			rootResp := itemMetadataResponse(t, resourceMetadataDto{
+				Name:     "album",
+				Kind:     directoryKind,
+				Modified: 1700000000000,
+				Contents: []resourceMetadataDto{{Name: "a.txt", Kind: fileKind}},
+			})
+			mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, "/album")).Return(rootResp, nil).Once()
+			expectFileDownload(t, mockHostConn, resourceId, "/album/a.txt", 1, 1700000001000, 1, 2, 3)
+
+			svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+			archive, err := svc.OpenResourceArchive(context.Background(), hostId, resourceId, "/album", "", nil)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, archive.WriteTar(&buf, compress))
+
+			var archiveReader io.Reader = &buf
+			if compress {
+				archiveReader, err = gzip.NewReader(&buf)
+				require.NoError(t, err)
+			}
+			tarReader := tar.NewReader(archiveReader)
+
+			header, err := tarReader.Next()
+			require.NoError(t, err)
+			assert.Equal(t, "album/", header.Name)
+			assert.Equal(t, byte(tar.TypeDir), header.Typeflag)
+			assert.Equal(t, time.UnixMilli(1700000000000).Unix(), header.ModTime.Unix())
+
+			header, err = tarReader.Next()
+			require.NoError(t, err)
+			assert.Equal(t, "album/a.txt", header.Name)
+			assert.Equal(t, time.UnixMilli(1700000001000).Unix(), header.ModTime.Unix())
+			content, err := io.ReadAll(tarReader)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{1, 2, 3}, content)
+
+			_, err = tarReader.Next()
+			assert.ErrorIs(t, err, io.EOF)
+			mockHostConn.AssertExpectations(t)
+		}
+	})
+
+	t.Run("error - child name escaping the directory", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		rootResp := directoryMetadataResponse(t, "album", resourceMetadataDto{Name: "..", Kind: directoryKind})
+		mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, "/album")).Return(rootResp, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		archive, err := svc.OpenResourceArchive(context.Background(), hostId, resourceId, "/album", "", nil)
+		require.NoError(t, err)
+
+		err = archive.WriteZip(io.Discard)
+		assert.ErrorIs(t, err, ws_errors.InvalidMessageBodyErr)
+	})
+
+	t.Run("error - host error is returned with its code", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		hostErrorResp := append(message_types.Error.Binary(), ws_errors.ResourceNotFound.Binary()...)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(hostErrorResp, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.OpenResourceArchive(context.Background(), hostId, resourceId, "aaa", "", nil)
+
+		var wsErr ws_errors.WebsocketError
+		require.ErrorAs(t, err, &wsErr)
+		assert.Equal(t, ws_errors.ResourceNotFound, wsErr.Code())
+	})
+}
@@ -0,0 +1,243 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/bandwidth"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/google/uuid"
+)
+
+const bandwidthLimitSize = 8
+
+// BandwidthLimits are the limits of the rate at which the relay passes file data, in bytes per second.
+// 0 means no limit.
+type BandwidthLimits struct {
+	// Global is shared by all downloads and uploads
+	Global int64
+	// PerClient is shared by all downloads and uploads of a client IP address
+	PerClient int64
+	// Clock is what the limits go by, the system clock when nil
+	Clock bandwidth.Clock
+}
+
+type transferDirection int
+
+const (
+	downloadDirection transferDirection = iota
+	uploadDirection
+)
+
+func (d transferDirection) String() string {
+	if d == uploadDirection {
+		return metrics.DirectionUpload
+	}
+	return metrics.DirectionDownload
+}
+
+// hostBandwidthLimiters hold the limits the host has set for what visitors download from it and upload to it
+type hostBandwidthLimiters struct {
+	download *bandwidth.Limiter
+	upload   *bandwidth.Limiter
+}
+
+// setHostBandwidthLimit applies a BandwidthLimit pushed by the host: download and upload limits (uint64 each)
+// in bytes per second, 0 meaning no limit. The limits apply to running transfers too and last until the host
+// changes them or disconnects.
+func (s *defaultConnectionService) setHostBandwidthLimit(hostUuid uuid.UUID, payload []byte) error {
+	if len(payload) < 2*bandwidthLimitSize {
+		return ws_errors.InvalidMessageBodyErr
+	}
+
+	download := clampRate(helpers.BinaryToUint64(payload[:bandwidthLimitSize]))
+	upload := clampRate(helpers.BinaryToUint64(payload[bandwidthLimitSize : 2*bandwidthLimitSize]))
+
+	s.hostLimitersMu.Lock()
+	defer s.hostLimitersMu.Unlock()
+
+	limiters, ok := s.hostLimiters[hostUuid]
+	if !ok {
+		if download == 0 && upload == 0 {
+			return nil
+		}
+
+		s.hostLimiters[hostUuid] = &hostBandwidthLimiters{
+			download: bandwidth.NewLimiter(download, s.bandwidthClock),
+			upload:   bandwidth.NewLimiter(upload, s.bandwidthClock),
+		}
+		return nil
+	}
+
+	limiters.download.SetRate(download)
+	limiters.upload.SetRate(upload)
+	return nil
+}
+
+// removeHostBandwidthLimit removes the limits of the host once it has disconnected, hosts set them again after
+// reconnecting. Transfers look their host limiters up every time they pass data, so they stop using them at once.
+func (s *defaultConnectionService) removeHostBandwidthLimit(hostUuid uuid.UUID) {
+	s.hostLimitersMu.Lock()
+	defer s.hostLimitersMu.Unlock()
+
+	delete(s.hostLimiters, hostUuid)
+}
+
+// hostLimiter returns the host limiter of the direction, nil if the host has never set one
+func (s *defaultConnectionService) hostLimiter(hostUuid uuid.UUID, direction transferDirection) *bandwidth.Limiter {
+	s.hostLimitersMu.Lock()
+	defer s.hostLimitersMu.Unlock()
+
+	limiters, ok := s.hostLimiters[hostUuid]
+	if !ok {
+		return nil
+	}
+
+	if direction == uploadDirection {
+		return limiters.upload
+	}
+	return limiters.download
+}
+
+// waitForBandwidth blocks until n bytes of file data may pass the global and host limits and the given client limit.
+// It stops waiting once ctx is done and returns the cause, ErrTransferAborted for transfers which have been aborted.
+func (s *defaultConnectionService) waitForBandwidth(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	direction transferDirection,
+	clientLimiter *bandwidth.Limiter,
+	n int,
+) error {
+	err := bandwidth.Wait(ctx, n, s.globalLimiter, clientLimiter, s.hostLimiter(hostUuid, direction))
+	if err != nil {
+		return context.Cause(ctx)
+	}
+
+	return nil
+}
+
+// startTransfer registers a transfer of the host made for ctx and wraps its client connection so its file data is
+// counted and passes the global, client and host limits. The returned function has to be called once the transfer
+// has ended. While the server is draining no transfer is started and ServerShuttingDownErr is returned.
+func (s *defaultConnectionService) startTransfer(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	direction transferDirection,
+) (*limitedClientConn, func(), error) {
+	if err := s.refuseWhileDraining(); err != nil {
+		return nil, nil, err
+	}
+
+	clientLimiter, releaseLimiter := s.clientLimiters.Acquire(clientConn.ClientIP())
+	t := s.transfers.start(ctx, hostUuid, direction, clientConn.ClientIP(), clientConn.Close)
+
+	limitedConn := &limitedClientConn{
+		ClientConn:    clientConn,
+		service:       s,
+		hostUuid:      hostUuid,
+		direction:     direction,
+		clientLimiter: clientLimiter,
+		transfer:      t,
+	}
+
+	return limitedConn, func() {
+		releaseLimiter()
+		t.end()
+	}, nil
+}
+
+// startClientlessTransfer registers a transfer of the host made for ctx without a client connection, like the plain
+// HTTP ones. The client limiter of clientIp is acquired and cancel is called when the transfer is aborted. Transfers
+// made by the relay itself have an empty clientIp and a nil cancel, they pass no client limit. The returned function
+// has to be called once the transfer has ended.
+func (s *defaultConnectionService) startClientlessTransfer(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	direction transferDirection,
+	clientIp string,
+	cancel func(),
+) (*transfer, *bandwidth.Limiter, func()) {
+	var clientLimiter *bandwidth.Limiter
+	releaseLimiter := func() {}
+	if clientIp != "" {
+		clientLimiter, releaseLimiter = s.clientLimiters.Acquire(clientIp)
+	}
+	t := s.transfers.start(ctx, hostUuid, direction, clientIp, cancel)
+
+	return t, clientLimiter, func() {
+		releaseLimiter()
+		t.end()
+	}
+}
+
+// limitedClientConn delays downloads before they are sent to the client and uploads after they have been
+// received from it, and counts their bytes
+type limitedClientConn struct {
+	clientconn.ClientConn
+
+	service       *defaultConnectionService
+	hostUuid      uuid.UUID
+	direction     transferDirection
+	clientLimiter *bandwidth.Limiter
+	transfer      *transfer
+
+	// lost is set once sending to or listening on the client connection has failed
+	lost atomic.Bool
+}
+
+// clientLost reports whether the transfer has failed because its client connection was lost, and not because it
+// has been aborted
+func (c *limitedClientConn) clientLost() bool {
+	return c.lost.Load() && !c.transfer.aborted.Load()
+}
+
+func (c *limitedClientConn) Send(payload ...[]byte) error {
+	if c.direction == downloadDirection {
+		n := 0
+		for _, part := range payload {
+			n += len(part)
+		}
+		if err := c.transfer.add(n); err != nil {
+			return err
+		}
+		if err := c.service.waitForBandwidth(c.transfer.ctx, c.hostUuid, c.direction, c.clientLimiter, n); err != nil {
+			c.lost.Store(!errors.Is(err, ErrTransferAborted))
+			return err
+		}
+	}
+
+	err := c.ClientConn.Send(payload...)
+	if err != nil {
+		c.lost.Store(true)
+	}
+
+	return err
+}
+
+func (c *limitedClientConn) Listen() ([]byte, error) {
+	msg, err := c.ClientConn.Listen()
+	if err != nil {
+		c.lost.Store(true)
+	}
+	if err == nil && c.direction == uploadDirection {
+		if err = c.transfer.add(len(msg)); err != nil {
+			return nil, err
+		}
+		if err = c.service.waitForBandwidth(c.transfer.ctx, c.hostUuid, c.direction, c.clientLimiter, len(msg)); err != nil {
+			c.lost.Store(!errors.Is(err, ErrTransferAborted))
+			return nil, err
+		}
+	}
+
+	return msg, err
+}
+
+func clampRate(bytesPerSecond uint64) int64 {
+	return int64(min(bytesPerSecond, math.MaxInt64))
+}
@@ -0,0 +1,148 @@
+package host
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/bandwidth"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newBandwidthTestService(limits BandwidthLimits) *defaultConnectionService {
+	return NewHostService(
+		&hostmap.MockHostMap{},
+		&saved_connections_repository.MockSavedConnectionsRepository{},
+		&file_index_repository.MockFileIndexRepository{},
+		limits,
+		testChunkSize,
+	).(*defaultConnectionService)
+}
+
+// startTestTransfer starts a transfer which the test expects to be accepted
+func startTestTransfer(
+	t *testing.T,
+	svc *defaultConnectionService,
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	direction transferDirection,
+) (*limitedClientConn, func()) {
+	t.Helper()
+
+	limitedConn, release, err := svc.startTransfer(ctx, clientConn, hostUuid, direction)
+	require.NoError(t, err)
+
+	return limitedConn, release
+}
+
+func newBandwidthLimitPush(download, upload uint64) []byte {
+	push := append(message_types.BandwidthLimit.Binary(), helpers.Uint64ToBinary(download)...)
+	return append(push, helpers.Uint64ToBinary(upload)...)
+}
+
+func TestHostBandwidthLimit(t *testing.T) {
+	t.Run("success - pushed limits are applied and dropped once the host disconnects", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSavedConnectionsRepo := &saved_connections_repository.MockSavedConnectionsRepository{}
+		mockSavedConnectionsRepo.On("UpdateLastSeen", mock.Anything, hostId, mock.Anything).Return(nil)
+		svc := NewHostService(mockHostMap, mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize).(*defaultConnectionService)
+
+		svc.handleHostPush(hostId, newBandwidthLimitPush(1_000, 2_000))
+		assert.Equal(t, int64(1_000), svc.hostLimiter(hostId, downloadDirection).Rate())
+		assert.Equal(t, int64(2_000), svc.hostLimiter(hostId, uploadDirection).Rate())
+
+		svc.handleHostPush(hostId, newBandwidthLimitPush(0, 3_000))
+		assert.Equal(t, int64(0), svc.hostLimiter(hostId, downloadDirection).Rate())
+		assert.Equal(t, int64(3_000), svc.hostLimiter(hostId, uploadDirection).Rate())
+
+		mockHostMap.DisconnectHandler(hostId)
+		assert.Nil(t, svc.hostLimiter(hostId, downloadDirection))
+		assert.Nil(t, svc.hostLimiter(hostId, uploadDirection))
+		assert.Empty(t, svc.hostLimiters)
+	})
+
+	t.Run("success - no limiters without limits", func(t *testing.T) {
+		hostId := uuid.New()
+		svc := newBandwidthTestService(BandwidthLimits{})
+
+		svc.handleHostPush(hostId, newBandwidthLimitPush(0, 0))
+		assert.Nil(t, svc.hostLimiter(hostId, downloadDirection))
+		assert.Nil(t, svc.hostLimiter(hostId, uploadDirection))
+	})
+
+	t.Run("error - payload too short", func(t *testing.T) {
+		svc := newBandwidthTestService(BandwidthLimits{})
+
+		err := svc.setHostBandwidthLimit(uuid.New(), helpers.Uint64ToBinary(1_000))
+		assert.ErrorIs(t, err, ws_errors.InvalidMessageBodyErr)
+	})
+}
+
+func TestLimitedClientConn(t *testing.T) {
+	t.Run("success - downloads over the host limit are delayed", func(t *testing.T) {
+		hostId := uuid.New()
+		clock := bandwidth.NewManualClock()
+		svc := newBandwidthTestService(BandwidthLimits{Clock: clock})
+		require.NoError(t, svc.setHostBandwidthLimit(hostId, append(helpers.Uint64ToBinary(10_000), helpers.Uint64ToBinary(0)...)))
+
+		mockClientConn := &clientconn.MockClientConn{IP: "127.0.0.1"}
+		mockClientConn.On("Send", mock.Anything).Return(nil)
+		limitedConn, release := startTestTransfer(t, svc, context.Background(), mockClientConn, hostId, downloadDirection)
+		defer release()
+
+		require.NoError(t, limitedConn.Send(make([]byte, 10_000)))
+		assert.Equal(t, time.Duration(0), clock.Slept())
+
+		require.NoError(t, limitedConn.Send(make([]byte, 1_000)))
+		assert.Equal(t, 100*time.Millisecond, clock.Slept())
+	})
+
+	t.Run("success - uploads over the client limit are delayed", func(t *testing.T) {
+		clock := bandwidth.NewManualClock()
+		svc := newBandwidthTestService(BandwidthLimits{PerClient: 10_000, Clock: clock})
+
+		mockClientConn := &clientconn.MockClientConn{IP: "127.0.0.1"}
+		mockClientConn.On("Listen").Return(make([]byte, 10_000), nil).Once()
+		mockClientConn.On("Listen").Return(make([]byte, 1_000), nil).Once()
+		limitedConn, release := startTestTransfer(t, svc, context.Background(), mockClientConn, uuid.New(), uploadDirection)
+		defer release()
+
+		// A second transfer of the same client shares the limit
+		otherConn, releaseOther := startTestTransfer(t, svc, context.Background(), &clientconn.MockClientConn{IP: "127.0.0.1"}, uuid.New(), uploadDirection)
+		defer releaseOther()
+		assert.Same(t, limitedConn.clientLimiter, otherConn.clientLimiter)
+
+		_, err := limitedConn.Listen()
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(0), clock.Slept())
+
+		_, err = limitedConn.Listen()
+		require.NoError(t, err)
+		assert.Equal(t, 100*time.Millisecond, clock.Slept())
+	})
+
+	t.Run("success - unlimited transfers are not delayed", func(t *testing.T) {
+		clock := bandwidth.NewManualClock()
+		svc := newBandwidthTestService(BandwidthLimits{Clock: clock})
+
+		mockClientConn := &clientconn.MockClientConn{IP: "127.0.0.1"}
+		mockClientConn.On("Send", mock.Anything).Return(nil)
+		limitedConn, release := startTestTransfer(t, svc, context.Background(), mockClientConn, uuid.New(), downloadDirection)
+		defer release()
+
+		require.NoError(t, limitedConn.Send(make([]byte, 100_000)))
+		assert.Equal(t, time.Duration(0), clock.Slept())
+	})
+}
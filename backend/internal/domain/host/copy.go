@@ -0,0 +1,165 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/google/uuid"
+)
+
+// CopyResource copies a file or a directory with all of its descendants to destinationPath. Copies within one
+// shared resource are made by the host itself. Copies to another shared resource, on the same or on another host,
+// are streamed by the relay: every file is downloaded from the source host and uploaded in chunks of chunkSize
+// bytes to the destination host, with no client involved.
+//
+// Streamed copies can not pick a free name, so ConflictRename fails with MissingOrInvalidRequiredParamsErr,
+// and encrypted files can not be read by the relay. Under ConflictOverwrite an existing destination is deleted
+// before the copy is streamed. A streamed copy failing midway leaves the resources copied so far in place.
+// Errors reported by either host are returned as host errors carrying its error code.
+func (s *defaultConnectionService) CopyResource(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	sourcePath string,
+	destinationHostUuid uuid.UUID,
+	destinationResourceUuid uuid.UUID,
+	destinationPath string,
+	policy ConflictPolicy,
+	chunkSize int,
+) error {
+	if destinationPath == "" || !policy.valid() {
+		return ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	if destinationHostUuid == hostUuid && destinationResourceUuid == resourceUuid {
+		return s.copyResourceOnHost(ctx, hostUuid, resourceUuid, sourcePath, destinationPath, policy)
+	}
+
+	if policy == ConflictRename {
+		return ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	exists, err := s.resourceExists(ctx, destinationHostUuid, destinationResourceUuid, destinationPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if policy == ConflictFail {
+			return ws_errors.DestinationExistsErr
+		}
+
+		resp, err := s.DeleteResource(ctx, destinationHostUuid, destinationResourceUuid, destinationPath)
+		if err != nil {
+			return err
+		}
+		if err = checkHostAck(resp); err != nil {
+			return err
+		}
+	}
+
+	source, err := s.OpenResourceArchive(ctx, hostUuid, resourceUuid, sourcePath, "", nil)
+	if err != nil {
+		return err
+	}
+
+	return source.walk(func(name string, metadata resourceMetadataDto, pathToResource string) error {
+		pathToCopy := destinationPath + strings.TrimPrefix(name, source.Name())
+
+		if metadata.Kind == directoryKind {
+			resp, err := s.CreateDirectory(ctx, destinationHostUuid, destinationResourceUuid, pathToCopy)
+			if err != nil {
+				return err
+			}
+
+			return checkHostAck(resp)
+		}
+
+		reader, err := s.OpenResourceReader(ctx, hostUuid, resourceUuid, pathToResource, "", nil)
+		if err != nil {
+			return err
+		}
+
+		err = s.UploadFile(ctx, destinationHostUuid, destinationResourceUuid, pathToCopy, uint64(reader.Size()), chunkSize, reader, "", nil)
+		if err != nil {
+			_ = reader.Close()
+			return err
+		}
+
+		return reader.Close()
+	})
+}
+
+func (s *defaultConnectionService) copyResourceOnHost(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	sourcePath string,
+	destinationPath string,
+	policy ConflictPolicy,
+) error {
+	hostConn, ok := s.getHostConn(ctx, hostUuid)
+	if !ok {
+		return ws_errors.HostNotFoundErr
+	}
+
+	resp, err := hostConn.Query(
+		message_types.CopyResource.Binary(),
+		helpers.UUIDToBinary(resourceUuid),
+		[]byte{byte(policy)},
+		[]byte(helpers.AddNullCharToString(sourcePath)),
+		[]byte(helpers.AddNullCharToString(destinationPath)),
+	)
+	if err != nil {
+		return err
+	}
+
+	return checkHostAck(resp)
+}
+
+// resourceExists tells whether the host has a resource at the path
+func (s *defaultConnectionService) resourceExists(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) (bool, error) {
+	resp, err := s.queryHostResource(ctx, hostUuid, resourceUuid, pathToResource, message_types.MetadataQuery)
+	if err != nil {
+		return false, err
+	}
+
+	msgType, err := message_types.GetMsgType(resp)
+	if err != nil {
+		return false, err
+	}
+
+	switch msgType {
+	case message_types.MetadataResponse:
+		return true, nil
+	case message_types.Error:
+		hostErr := newHostError(resp)
+		var wsErr ws_errors.WebsocketError
+		if errors.As(hostErr, &wsErr) && wsErr.Code() == ws_errors.ResourceNotFound {
+			return false, nil
+		}
+		return false, hostErr
+	default:
+		return false, ws_errors.UnexpectedMessageTypeErr
+	}
+}
+
+// checkHostAck turns a host response which should be an ACK into an error
+func checkHostAck(resp []byte) error {
+	msgType, err := message_types.GetMsgType(resp)
+	if err != nil {
+		return err
+	}
+
+	switch msgType {
+	case message_types.ACK:
+		return nil
+	case message_types.Error:
+		return newHostError(resp)
+	default:
+		return ws_errors.UnexpectedMessageTypeErr
+	}
+}
@@ -0,0 +1,151 @@
+package host
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCopyResource(t *testing.T) {
+	t.Run("success - copy within a share is made by the host", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		copyQuery := moveQuery(resourceId, ConflictOverwrite, "/a", "/b")
+		copyQuery[0] = message_types.CopyResource.Binary()
+		mockHostConn.On("Query", copyQuery).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CopyResource(context.Background(), hostId, resourceId, "/a", hostId, resourceId, "/b", ConflictOverwrite, 3)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("success - copy between hosts is streamed by the relay", func(t *testing.T) {
+		sourceHostId := uuid.New()
+		sourceResourceId := uuid.New()
+		destinationHostId := uuid.New()
+		destinationResourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		sourceHostConn := &hostconn.MockConn{}
+		destinationHostConn := &hostconn.MockConn{}
+		defer func() {
+			sourceHostConn.AssertExpectations(t)
+			destinationHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", sourceHostId).Return(sourceHostConn, true)
+		mockHostMap.On("Get", destinationHostId).Return(destinationHostConn, true)
+
+		notFoundResp := append(message_types.Error.Binary(), ws_errors.ResourceNotFound.Binary()...)
+		destinationHostConn.On("Query", resourceQuery(message_types.MetadataQuery, destinationResourceId, "/backup")).
+			Return(notFoundResp, nil).Once()
+
+		rootResp := directoryMetadataResponse(t, "build", resourceMetadataDto{Name: "a.bin", Kind: fileKind})
+		sourceHostConn.On("Query", resourceQuery(message_types.MetadataQuery, sourceResourceId, "/build")).Return(rootResp, nil).Once()
+		destinationHostConn.On("Query", resourceQuery(message_types.CreateDirectory, destinationResourceId, "/backup")).
+			Return(message_types.ACK.Binary(), nil).Once()
+
+		expectFileDownload(t, sourceHostConn, sourceResourceId, "/build/a.bin", 1, 0, 1, 2, 3)
+		createFileInitResponse := append(message_types.CreateFileInitResponse.Binary(), helpers.Uint32ToBinary(777)...)
+		destinationHostConn.On("Query", [][]byte{
+			message_types.CreateFileInitRequest.Binary(),
+			helpers.UUIDToBinary(destinationResourceId),
+			helpers.Uint32ToBinary(3),
+			[]byte("/backup/a.bin\000"),
+		}).Return(createFileInitResponse, nil).Once()
+		destinationHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		onBulkQuery(destinationHostConn, uploadChunk(777, 1, 2, 3)).Return(message_types.ACK.Binary(), nil).Once()
+		destinationHostConn.On("Query", hostChunkPrompt(777)).Return(message_types.CreateFileStreamEnd.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CopyResource(context.Background(), sourceHostId, sourceResourceId, "/build", destinationHostId, destinationResourceId, "/backup", ConflictFail, 3)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("success - existing destination is deleted before a streamed overwrite", func(t *testing.T) {
+		sourceHostId := uuid.New()
+		sourceResourceId := uuid.New()
+		destinationHostId := uuid.New()
+		destinationResourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		sourceHostConn := &hostconn.MockConn{}
+		destinationHostConn := &hostconn.MockConn{}
+		defer func() {
+			sourceHostConn.AssertExpectations(t)
+			destinationHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", sourceHostId).Return(sourceHostConn, true)
+		mockHostMap.On("Get", destinationHostId).Return(destinationHostConn, true)
+
+		deleted := false
+		destinationHostConn.On("Query", resourceQuery(message_types.MetadataQuery, destinationResourceId, "/backup.bin")).
+			Return(metadataResponse(t, 0, fileKind, 5), nil).Once()
+		destinationHostConn.On("Query", resourceQuery(message_types.DeleteResource, destinationResourceId, "/backup.bin")).
+			Run(func(args mock.Arguments) { deleted = true }).
+			Return(message_types.ACK.Binary(), nil).Once()
+
+		sourceHostConn.On("Query", resourceQuery(message_types.MetadataQuery, sourceResourceId, "/a.bin")).
+			Return(metadataResponse(t, 0, fileKind, 3), nil).Once()
+		expectFileDownload(t, sourceHostConn, sourceResourceId, "/a.bin", 1, 0, 1, 2, 3)
+		createFileInitResponse := append(message_types.CreateFileInitResponse.Binary(), helpers.Uint32ToBinary(777)...)
+		destinationHostConn.On("Query", [][]byte{
+			message_types.CreateFileInitRequest.Binary(),
+			helpers.UUIDToBinary(destinationResourceId),
+			helpers.Uint32ToBinary(3),
+			[]byte("/backup.bin\000"),
+		}).Run(func(args mock.Arguments) {
+			assert.True(t, deleted, "destination was not deleted before the upload")
+		}).Return(createFileInitResponse, nil).Once()
+		destinationHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		onBulkQuery(destinationHostConn, uploadChunk(777, 1, 2, 3)).Return(message_types.ACK.Binary(), nil).Once()
+		destinationHostConn.On("Query", hostChunkPrompt(777)).Return(message_types.CreateFileStreamEnd.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CopyResource(context.Background(), sourceHostId, sourceResourceId, "/a.bin", destinationHostId, destinationResourceId, "/backup.bin", ConflictOverwrite, 3)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("error - destination exists", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		destinationResourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, destinationResourceId, "/b")).
+			Return(metadataResponse(t, 0, fileKind, 1), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CopyResource(context.Background(), hostId, resourceId, "/a", hostId, destinationResourceId, "/b", ConflictFail, 3)
+
+		assert.ErrorIs(t, err, ws_errors.DestinationExistsErr)
+	})
+
+	t.Run("error - rename policy between shares", func(t *testing.T) {
+		hostId := uuid.New()
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CopyResource(context.Background(), hostId, uuid.New(), "/a", hostId, uuid.New(), "/b", ConflictRename, 3)
+
+		assert.ErrorIs(t, err, ws_errors.MissingOrInvalidRequiredParamsErr)
+	})
+}
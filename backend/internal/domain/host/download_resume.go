@@ -0,0 +1,173 @@
+package host
+
+import (
+	"context"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/google/uuid"
+)
+
+// downloadResumeGracePeriod is how long the host stream of a resumable download is kept open after its client
+// has disconnected
+const downloadResumeGracePeriod = 2 * time.Minute
+
+type resumableDownload struct {
+	hostUuid       uuid.UUID
+	resourceUuid   uuid.UUID
+	pathToResource string
+
+	// flow is the flow the queries of the download are queued in, kept across resumes
+	flow hostconn.FlowId
+
+	// hostConn and initResp describe the host stream currently serving the download. They are replaced when
+	// the download is resumed after the host has reconnected.
+	hostConn hostconn.HostConn
+	initResp hostStreamInitResponseDto
+}
+
+// DownloadResourceResumable works like DownloadResource, but right after DownloadInitResponse the client receives
+// a DownloadResumeToken. If the client connection is lost, the host stream is kept open for the grace period
+// and the download can be continued with ResumeDownload. Downloads which fail for any other reason are completed
+// on the host and can not be resumed.
+func (s *defaultConnectionService) DownloadResourceResumable(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) error {
+	hostConn, ok := s.hostMap.Get(hostUuid)
+	if !ok {
+		return ws_errors.HostNotFoundErr
+	}
+
+	limitedConn, release, err := s.startTransfer(ctx, clientConn, hostUuid, downloadDirection)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	flow := hostconn.NewFlowId()
+	downloadInitRespDto, started, err := s.startDownloadStream(flowView(ctx, hostConn, hostUuid, flow), limitedConn, resourceUuid, pathToResource)
+	if err != nil || !started {
+		return err
+	}
+
+	download := &resumableDownload{
+		hostUuid:       hostUuid,
+		resourceUuid:   resourceUuid,
+		pathToResource: pathToResource,
+		flow:           flow,
+		hostConn:       hostConn,
+		initResp:       downloadInitRespDto,
+	}
+	token := s.resumableDownloads.add(clientConn.ClientIP(), download)
+
+	err = limitedConn.Send(message_types.DownloadResumeToken.Binary(), helpers.UUIDToBinary(token))
+	if err != nil {
+		return s.finishResumableDownload(limitedConn, token, download, err)
+	}
+
+	return s.handleResumableDownloadLoop(ctx, limitedConn, token, download)
+}
+
+// ResumeDownload attaches the client to a download started with DownloadResourceResumable. The client receives
+// DownloadInitResponse again and continues with chunk requests from the offset it has stopped at.
+func (s *defaultConnectionService) ResumeDownload(ctx context.Context, clientConn clientconn.ClientConn, token uuid.UUID) error {
+	download, err := s.resumableDownloads.attach(token)
+	if err != nil {
+		return err
+	}
+
+	limitedConn, release, err := s.startTransfer(ctx, clientConn, download.hostUuid, downloadDirection)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	resumed, err := s.reopenDownloadStream(ctx, limitedConn, download)
+	if err != nil {
+		return s.finishResumableDownload(limitedConn, token, download, err)
+	}
+	if !resumed {
+		s.resumableDownloads.remove(token)
+		return nil
+	}
+
+	return s.handleResumableDownloadLoop(ctx, limitedConn, token, download)
+}
+
+// reopenDownloadStream sends DownloadInitResponse of the download to the new client. If the host has reconnected
+// since the download was started, its old stream is gone and a new one is opened. Chunk requests carry their offset,
+// so the new stream serves the rest of the download the same way.
+// Returns false if the host has refused to open the new stream.
+func (s *defaultConnectionService) reopenDownloadStream(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	download *resumableDownload,
+) (bool, error) {
+	hostConn, ok := s.hostMap.Get(download.hostUuid)
+	if !ok {
+		return false, ws_errors.HostNotFoundErr
+	}
+
+	if hostConn == download.hostConn {
+		err := clientConn.Send(message_types.DownloadInitResponse.Binary(), download.initResp.payload)
+		return err == nil, err
+	}
+
+	downloadInitRespDto, started, err := s.startDownloadStream(
+		flowView(ctx, hostConn, download.hostUuid, download.flow),
+		clientConn,
+		download.resourceUuid,
+		download.pathToResource,
+	)
+	if err != nil || !started {
+		return false, err
+	}
+
+	download.hostConn = hostConn
+	download.initResp = downloadInitRespDto
+	return true, nil
+}
+
+func (s *defaultConnectionService) handleResumableDownloadLoop(
+	ctx context.Context,
+	clientConn *limitedClientConn,
+	token uuid.UUID,
+	download *resumableDownload,
+) error {
+	err := s.serveDownloadRequests(flowView(ctx, download.hostConn, download.hostUuid, download.flow), clientConn, download.initResp)
+	return s.finishResumableDownload(clientConn, token, download, err)
+}
+
+// finishResumableDownload keeps the download resumable if it has failed because its client connection was lost.
+// Downloads which have been aborted or failed on a host or protocol error are removed and their host stream
+// is completed.
+func (s *defaultConnectionService) finishResumableDownload(
+	clientConn *limitedClientConn,
+	token uuid.UUID,
+	download *resumableDownload,
+	err error,
+) error {
+	if err != nil && clientConn.clientLost() {
+		s.resumableDownloads.detach(token)
+		return err
+	}
+
+	s.resumableDownloads.remove(token)
+	if err != nil {
+		s.expireResumableDownload(download)
+	}
+
+	return err
+}
+
+func (s *defaultConnectionService) expireResumableDownload(download *resumableDownload) {
+	_ = s.sendDownloadCompletionQueryToHost(download.hostConn, download.initResp.streamId)
+}
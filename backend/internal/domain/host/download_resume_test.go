@@ -0,0 +1,386 @@
+package host
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func downloadInitQuery(resourceId uuid.UUID) [][]byte {
+	return [][]byte{
+		message_types.DownloadInitRequest.Binary(),
+		helpers.UUIDToBinary(resourceId),
+		[]byte("aaa\000"),
+	}
+}
+
+func downloadInitResponse(downloadId uint32, sizeInChunks uint32) []byte {
+	resp := message_types.DownloadInitResponse.Binary()
+	resp = append(resp, helpers.Uint32ToBinary(downloadId)...)
+	return append(resp, helpers.Uint32ToBinary(sizeInChunks)...)
+}
+
+func completionQuery(downloadId uint32) [][]byte {
+	return [][]byte{
+		message_types.DownloadCompletionRequest.Binary(),
+		helpers.Uint32ToBinary(downloadId),
+	}
+}
+
+// startDroppedResumableDownload starts a resumable download whose client disconnects straight after receiving
+// the resume token, and returns the token
+func startDroppedResumableDownload(
+	t *testing.T,
+	svc HostService,
+	hostId uuid.UUID,
+	resourceId uuid.UUID,
+	downloadId uint32,
+	mockHostConn *hostconn.MockConn,
+) uuid.UUID {
+	t.Helper()
+
+	mockClientConn := &clientconn.MockClientConn{}
+	defer mockClientConn.AssertExpectations(t)
+
+	mockHostConn.On("Query", downloadInitQuery(resourceId)).Return(downloadInitResponse(downloadId, 10), nil).Once()
+	mockClientConn.On("Send", [][]byte{
+		message_types.DownloadInitResponse.Binary(),
+		helpers.Uint32ToBinary(10),
+	}).Return(nil).Once()
+
+	var token uuid.UUID
+	mockClientConn.On("Send", mock.MatchedBy(func(msg [][]byte) bool {
+		return len(msg) == 2 && string(msg[0]) == string(message_types.DownloadResumeToken.Binary())
+	})).Run(func(args mock.Arguments) {
+		token = uuid.UUID(args.Get(0).([][]byte)[1])
+	}).Return(nil).Once()
+	mockClientConn.On("Listen").Return(nil, ws_errors.ConnectionClosedErr).Once()
+
+	err := svc.DownloadResourceResumable(context.Background(), mockClientConn, hostId, resourceId, "aaa")
+	require.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	require.NotEqual(t, uuid.Nil, token)
+
+	return token
+}
+
+func TestResumableDownload(t *testing.T) {
+	t.Run("success - resume on the same host connection", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableDownload(t, svc, hostId, resourceId, 123, mockHostConn)
+
+		// The same stream is served again, starting from the offset the client has stopped at
+		mockClientConn.On("Send", [][]byte{
+			message_types.DownloadInitResponse.Binary(),
+			helpers.Uint32ToBinary(10),
+		}).Return(nil).Once()
+		chunkReq := append(message_types.ChunkRequest.Binary(), helpers.Uint64ToBinary(5)...)
+		mockClientConn.On("Listen").Return(chunkReq, nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 5)).Return(chunkResponse(1, 2, 3), nil).Once()
+		mockClientConn.On("Send", [][]byte{chunkResponse(1, 2, 3)}).Return(nil).Once()
+		mockClientConn.On("Listen").Return(message_types.DownloadCompletionRequest.Binary(), nil).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeDownload(context.Background(), mockClientConn, token)
+		assert.NoError(t, err)
+
+		// A completed download can not be resumed again
+		err = svc.ResumeDownload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("success - resume after the host has reconnected", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		oldHostConn := &hostconn.MockConn{}
+		newHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			oldHostConn.AssertExpectations(t)
+			newHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(oldHostConn, true).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableDownload(t, svc, hostId, resourceId, 123, oldHostConn)
+
+		// A new stream is opened on the new connection
+		mockHostMap.On("Get", hostId).Return(newHostConn, true).Once()
+		newHostConn.On("Query", downloadInitQuery(resourceId)).Return(downloadInitResponse(456, 10), nil).Once()
+		mockClientConn.On("Send", [][]byte{
+			message_types.DownloadInitResponse.Binary(),
+			helpers.Uint32ToBinary(10),
+		}).Return(nil).Once()
+		chunkReq := append(message_types.ChunkRequest.Binary(), helpers.Uint64ToBinary(5)...)
+		mockClientConn.On("Listen").Return(chunkReq, nil).Once()
+		onBulkQuery(newHostConn, chunkQuery(456, 5)).Return(chunkResponse(1), nil).Once()
+		mockClientConn.On("Send", [][]byte{chunkResponse(1)}).Return(nil).Once()
+		mockClientConn.On("Listen").Return(message_types.DownloadCompletionRequest.Binary(), nil).Once()
+		newHostConn.On("Query", completionQuery(456)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeDownload(context.Background(), mockClientConn, token)
+		assert.NoError(t, err)
+	})
+
+	t.Run("host stream is completed after the grace period", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		svcImpl := svc.(*defaultConnectionService)
+		svcImpl.resumableDownloads = newResumeRegistry(10*time.Millisecond, svcImpl.expireResumableDownload)
+
+		completed := make(chan struct{})
+		mockHostConn.On("Query", completionQuery(123)).Run(func(args mock.Arguments) {
+			close(completed)
+		}).Return(message_types.ACK.Binary(), nil).Once()
+
+		token := startDroppedResumableDownload(t, svc, hostId, resourceId, 123, mockHostConn)
+
+		select {
+		case <-completed:
+		case <-time.After(time.Second):
+			t.Fatal("host stream was not completed")
+		}
+
+		err := svc.ResumeDownload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - unknown token", func(t *testing.T) {
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+
+		err := svc.ResumeDownload(context.Background(), &clientconn.MockClientConn{}, uuid.New())
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - host is not connected, download is completed", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableDownload(t, svc, hostId, resourceId, 123, mockHostConn)
+
+		mockHostMap.On("Get", hostId).Return(nil, false).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(nil, ws_errors.ConnectionClosedErr).Once()
+		err := svc.ResumeDownload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.HostNotFoundErr)
+
+		_, err = svc.(*defaultConnectionService).resumableDownloads.attach(token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - host error, download is completed", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableDownload(t, svc, hostId, resourceId, 123, mockHostConn)
+
+		mockClientConn.On("Send", [][]byte{
+			message_types.DownloadInitResponse.Binary(),
+			helpers.Uint32ToBinary(10),
+		}).Return(nil).Once()
+		chunkReq := append(message_types.ChunkRequest.Binary(), helpers.Uint64ToBinary(5)...)
+		mockClientConn.On("Listen").Return(chunkReq, nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 5)).Return(nil, ws_errors.TimeoutErr).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeDownload(context.Background(), mockClientConn, token)
+		assert.ErrorIs(t, err, ws_errors.TimeoutErr)
+
+		err = svc.ResumeDownload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - unexpected message, download is completed", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableDownload(t, svc, hostId, resourceId, 123, mockHostConn)
+
+		mockClientConn.On("Send", [][]byte{
+			message_types.DownloadInitResponse.Binary(),
+			helpers.Uint32ToBinary(10),
+		}).Return(nil).Once()
+		mockClientConn.On("Listen").Return(message_types.ACK.Binary(), nil).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeDownload(context.Background(), mockClientConn, token)
+		assert.ErrorIs(t, err, ws_errors.UnexpectedMessageTypeErr)
+
+		err = svc.ResumeDownload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - aborted download is completed", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableDownload(t, svc, hostId, resourceId, 123, mockHostConn)
+
+		mockClientConn.On("Send", [][]byte{
+			message_types.DownloadInitResponse.Binary(),
+			helpers.Uint32ToBinary(10),
+		}).Return(nil).Once()
+		mockClientConn.On("Close").Return().Once()
+		// Aborting closes the client connection, which ends the pending Listen
+		mockClientConn.On("Listen").Run(func(args mock.Arguments) {
+			require.NoError(t, svc.AbortTransfer(svc.ListTransfers()[0].Id))
+		}).Return(nil, ws_errors.ConnectionClosedErr).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeDownload(context.Background(), mockClientConn, token)
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+
+		err = svc.ResumeDownload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+}
+
+func TestResumeRegistry(t *testing.T) {
+	t.Run("attached transfer can not be attached again", func(t *testing.T) {
+		registry := newResumeRegistry(time.Minute, func(int) {})
+		token := registry.add("10.0.0.1", 1)
+
+		_, err := registry.attach(token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+
+		registry.detach(token)
+		value, err := registry.attach(token)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, value)
+	})
+
+	t.Run("resumed transfer does not expire", func(t *testing.T) {
+		expired := make(chan int, 1)
+		registry := newResumeRegistry(20*time.Millisecond, func(value int) { expired <- value })
+		token := registry.add("10.0.0.1", 1)
+
+		registry.detach(token)
+		_, err := registry.attach(token)
+		require.NoError(t, err)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, expired)
+
+		registry.detach(token)
+		select {
+		case value := <-expired:
+			assert.Equal(t, 1, value)
+		case <-time.After(time.Second):
+			t.Fatal("transfer did not expire")
+		}
+	})
+	t.Run("detached transfers over the limit of a client expire at once", func(t *testing.T) {
+		var expired []int
+		registry := newResumeRegistry(time.Minute, func(value int) { expired = append(expired, value) })
+
+		for i := range maxDetachedPerClient {
+			registry.detach(registry.add("10.0.0.1", i))
+		}
+		assert.Empty(t, expired)
+
+		registry.detach(registry.add("10.0.0.1", maxDetachedPerClient))
+		assert.Equal(t, []int{maxDetachedPerClient}, expired)
+
+		// Other clients have limits of their own
+		registry.detach(registry.add("10.0.0.2", -1))
+		assert.Equal(t, []int{maxDetachedPerClient}, expired)
+	})
+
+	t.Run("resumed transfer no longer counts towards the limit", func(t *testing.T) {
+		var expired []int
+		registry := newResumeRegistry(time.Minute, func(value int) { expired = append(expired, value) })
+
+		tokens := make([]uuid.UUID, maxDetachedPerClient)
+		for i := range tokens {
+			tokens[i] = registry.add("10.0.0.1", i)
+			registry.detach(tokens[i])
+		}
+		_, err := registry.attach(tokens[0])
+		require.NoError(t, err)
+		registry.remove(tokens[1])
+
+		registry.detach(registry.add("10.0.0.1", maxDetachedPerClient))
+		registry.detach(tokens[0])
+		assert.Empty(t, expired)
+	})
+}
@@ -0,0 +1,132 @@
+package host
+
+import (
+	"context"
+	"math"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+)
+
+// maxDownloadWindowSize is the maximum number of chunk requests kept in flight for a single download
+const maxDownloadWindowSize = 16
+
+type chunkResult struct {
+	resp []byte
+	err  error
+}
+
+// handleDownloadWindowRequest negotiates the window size with the client and streams chunks starting at the
+// requested offset until the host reports EOF or an error, or the end of the download is reached. Hosts answer
+// every chunk request with a chunk of the configured size, so any other chunk size is refused.
+//
+// Chunks are delivered to the client in order. Once the window finishes the client is sent EofResponse
+// (or the host error) and the download loop continues, waiting for the DownloadCompletionRequest.
+func (s *defaultConnectionService) handleDownloadWindowRequest(
+	hostConn hostconn.HostConn,
+	clientConn clientconn.ClientConn,
+	downloadInitRespDto hostStreamInitResponseDto,
+	windowReqDto msgTypeWithPayload,
+) error {
+	windowReq, err := newDownloadWindowRequestDto(windowReqDto.payload)
+	if err != nil {
+		return err
+	}
+
+	if windowReq.windowSize == 0 || windowReq.chunkSize != s.chunkSize {
+		return ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	window := min(windowReq.windowSize, maxDownloadWindowSize)
+	err = clientConn.Send(message_types.DownloadWindowResponse.Binary(), helpers.Uint16ToBinary(window))
+	if err != nil {
+		return err
+	}
+
+	endOffset := uint64(math.MaxUint64)
+	if sizeInChunks, ok := downloadInitRespDto.sizeInChunks(); ok {
+		endOffset = uint64(sizeInChunks) * uint64(windowReq.chunkSize)
+	}
+
+	return s.pumpDownloadWindow(hostConn, clientConn, downloadInitRespDto.streamId, int(window), windowReq.chunkSize, windowReq.startOffset, endOffset)
+}
+
+func (s *defaultConnectionService) pumpDownloadWindow(
+	hostConn hostconn.HostConn,
+	clientConn clientconn.ClientConn,
+	downloadId uint32,
+	window int,
+	chunkSize uint32,
+	startOffset uint64,
+	endOffset uint64,
+) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Every in flight chunk request holds a slot in inFlight until its response is sent to the client,
+	// results keeps them in the order they have been requested in
+	inFlight := make(chan struct{}, window)
+	results := make(chan chan chunkResult, window)
+
+	go func() {
+		defer close(results)
+
+		for offset := startOffset; offset < endOffset; offset += uint64(chunkSize) {
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			resultCh := make(chan chunkResult, 1)
+			select {
+			case results <- resultCh:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(offset uint64) {
+				resp, err := hostConn.QueryWithTimeout(
+					hostconn.DefaultQueryTimeout,
+					hostconn.PriorityBulk,
+					message_types.ChunkRequest.Binary(),
+					helpers.Uint32ToBinary(downloadId),
+					helpers.Uint64ToBinary(offset),
+				)
+				resultCh <- chunkResult{resp: resp, err: err}
+			}(offset)
+
+			if offset > math.MaxUint64-uint64(chunkSize) {
+				return
+			}
+		}
+	}()
+
+	for resultCh := range results {
+		result := <-resultCh
+		<-inFlight
+
+		if result.err != nil {
+			return result.err
+		}
+
+		msgType, err := message_types.GetMsgType(result.resp)
+		if err != nil {
+			return err
+		}
+
+		if err = clientConn.Send(result.resp); err != nil {
+			return err
+		}
+
+		// EOF or an error ends the window, the remaining responses are not needed anymore
+		if msgType != message_types.ChunkResponse {
+			return nil
+		}
+	}
+
+	return clientConn.Send(message_types.EofResponse.Binary())
+}
@@ -0,0 +1,251 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testChunkSize is the chunk size services under test are configured with
+const testChunkSize = 10
+
+func downloadWindowRequest(window uint16, chunkSize uint32, startOffset uint64) []byte {
+	req := message_types.DownloadWindowRequest.Binary()
+	req = append(req, helpers.Uint16ToBinary(window)...)
+	req = append(req, helpers.Uint32ToBinary(chunkSize)...)
+	return append(req, helpers.Uint64ToBinary(startOffset)...)
+}
+
+func chunkQuery(downloadId uint32, offset uint64) [][]byte {
+	return [][]byte{
+		message_types.ChunkRequest.Binary(),
+		helpers.Uint32ToBinary(downloadId),
+		helpers.Uint64ToBinary(offset),
+	}
+}
+
+// onBulkQuery expects the query to be sent with the bulk priority file data is sent with
+func onBulkQuery(hostConn *hostconn.MockConn, query [][]byte) *mock.Call {
+	return hostConn.On("QueryWithTimeout", query, hostconn.DefaultQueryTimeout, hostconn.PriorityBulk)
+}
+
+func chunkResponse(payload ...byte) []byte {
+	return append(message_types.ChunkResponse.Binary(), payload...)
+}
+
+// setUpWindowedDownload prepares mocks for a download of sizeInChunks chunks which is then
+// switched into the windowed mode with the given window request
+func setUpWindowedDownload(
+	hostId uuid.UUID,
+	resourceId uuid.UUID,
+	downloadId uint32,
+	sizeInChunks uint32,
+	windowRequest []byte,
+) (*hostmap.MockHostMap, *hostconn.MockConn, *clientconn.MockClientConn, *[][]byte) {
+	mockHostMap := &hostmap.MockHostMap{}
+	mockHostConn := &hostconn.MockConn{}
+	mockClientConn := &clientconn.MockClientConn{}
+
+	mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+	downloadInitResponse := message_types.DownloadInitResponse.Binary()
+	downloadInitResponse = append(downloadInitResponse, helpers.Uint32ToBinary(downloadId)...)
+	downloadInitResponse = append(downloadInitResponse, helpers.Uint32ToBinary(sizeInChunks)...)
+	mockHostConn.On("Query", [][]byte{
+		message_types.DownloadInitRequest.Binary(),
+		helpers.UUIDToBinary(resourceId),
+		[]byte("aaa\000"),
+	}).Return(downloadInitResponse, nil)
+
+	var sent [][]byte
+	var sentMu sync.Mutex
+	mockClientConn.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		sentMu.Lock()
+		defer sentMu.Unlock()
+
+		var msg []byte
+		for _, part := range args.Get(0).([][]byte) {
+			msg = append(msg, part...)
+		}
+		sent = append(sent, msg)
+	}).Return(nil)
+
+	mockClientConn.On("Listen").Return(windowRequest, nil).Once()
+
+	return mockHostMap, mockHostConn, mockClientConn, &sent
+}
+
+func TestDownloadWindow(t *testing.T) {
+	t.Run("success - chunks are delivered in order and followed by EOF", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap, mockHostConn, mockClientConn, sent := setUpWindowedDownload(hostId, resourceId, 9, 4, downloadWindowRequest(2, testChunkSize, 0))
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		for i := uint64(0); i < 4; i++ {
+			onBulkQuery(mockHostConn, chunkQuery(9, i*10)).Return(chunkResponse(byte(i)), nil).Once()
+		}
+
+		mockClientConn.On("Listen").Return(message_types.DownloadCompletionRequest.Binary(), nil).Once()
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(9),
+		}).Return(message_types.ACK.Binary(), nil)
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
+		require.NoError(t, err)
+
+		require.Len(t, *sent, 7)
+		assert.Equal(t, append(message_types.DownloadWindowResponse.Binary(), helpers.Uint16ToBinary(2)...), (*sent)[1])
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, chunkResponse(byte(i)), (*sent)[2+i])
+		}
+		assert.Equal(t, message_types.EofResponse.Binary(), (*sent)[6])
+	})
+
+	t.Run("success - window is limited and starts at the requested offset", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap, mockHostConn, mockClientConn, sent := setUpWindowedDownload(hostId, resourceId, 9, 3, downloadWindowRequest(1000, testChunkSize, 20))
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		onBulkQuery(mockHostConn, chunkQuery(9, 20)).Return(chunkResponse(2), nil).Once()
+
+		mockClientConn.On("Listen").Return(message_types.DownloadCompletionRequest.Binary(), nil).Once()
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(9),
+		}).Return(message_types.ACK.Binary(), nil)
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
+		require.NoError(t, err)
+
+		require.Len(t, *sent, 4)
+		assert.Equal(t, append(message_types.DownloadWindowResponse.Binary(), helpers.Uint16ToBinary(maxDownloadWindowSize)...), (*sent)[1])
+		assert.Equal(t, chunkResponse(2), (*sent)[2])
+		assert.Equal(t, message_types.EofResponse.Binary(), (*sent)[3])
+	})
+
+	t.Run("success - host EOF ends the window", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap, mockHostConn, mockClientConn, sent := setUpWindowedDownload(hostId, resourceId, 9, 5, downloadWindowRequest(1, testChunkSize, 0))
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		onBulkQuery(mockHostConn, chunkQuery(9, 0)).Return(chunkResponse(0), nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(9, 10)).Return(message_types.EofResponse.Binary(), nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(9, 20)).Return(chunkResponse(2), nil).Maybe()
+
+		mockClientConn.On("Listen").Return(message_types.DownloadCompletionRequest.Binary(), nil).Once()
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(9),
+		}).Return(message_types.ACK.Binary(), nil)
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
+		require.NoError(t, err)
+
+		require.Len(t, *sent, 4)
+		assert.Equal(t, chunkResponse(0), (*sent)[2])
+		assert.Equal(t, message_types.EofResponse.Binary(), (*sent)[3])
+	})
+
+	t.Run("error - host query error", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap, mockHostConn, mockClientConn, _ := setUpWindowedDownload(hostId, resourceId, 9, 1, downloadWindowRequest(4, testChunkSize, 0))
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		onBulkQuery(mockHostConn, chunkQuery(9, 0)).Return(nil, errors.New("hostError")).Once()
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(9),
+		}).Return(message_types.ACK.Binary(), nil)
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
+		require.Error(t, err)
+		assert.Equal(t, "hostError", err.Error())
+	})
+
+	t.Run("error - invalid window request", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap, mockHostConn, mockClientConn, _ := setUpWindowedDownload(hostId, resourceId, 9, 1, downloadWindowRequest(0, testChunkSize, 0))
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(9),
+		}).Return(message_types.ACK.Binary(), nil)
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
+		assert.ErrorIs(t, err, ws_errors.MissingOrInvalidRequiredParamsErr)
+	})
+	t.Run("error - chunk size other than the one of the hosts", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap, mockHostConn, mockClientConn, sent := setUpWindowedDownload(hostId, resourceId, 9, 4, downloadWindowRequest(2, testChunkSize/2, 0))
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(9),
+		}).Return(message_types.ACK.Binary(), nil)
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
+		assert.ErrorIs(t, err, ws_errors.MissingOrInvalidRequiredParamsErr)
+
+		// No chunk has been requested from the host
+		mockHostConn.AssertNotCalled(t, "QueryWithTimeout", mock.Anything, mock.Anything, mock.Anything)
+		assert.Len(t, *sent, 1)
+	})
+}
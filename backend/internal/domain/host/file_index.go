@@ -0,0 +1,188 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxSearchResults is the largest number of results of an index search. Larger and zero limits are lowered to it.
+	MaxSearchResults = 200
+
+	// maxIndexUpdateSize is the largest IndexUpdate payload accepted from a host
+	maxIndexUpdateSize = 4 << 20
+	// maxIndexUpdateEntries is the largest number of entries and removed paths in a single IndexUpdate
+	maxIndexUpdateEntries = 10_000
+
+	indexResetFlag = 1
+)
+
+// handleHostPush handles a message the host has sent on its own. Hosts get no response to pushed messages,
+// so failures are only logged.
+func (s *defaultConnectionService) handleHostPush(hostUuid uuid.UUID, msg []byte) {
+	req, err := newMsgTypeWithPayloadDto(msg)
+	if err != nil {
+		slog.Warn("invalid message pushed by host", logging.HostIdKey, hostUuid, "error", err)
+		return
+	}
+
+	switch req.msgType {
+	case message_types.IndexUpdate:
+		err = s.updateIndex(hostUuid, req.payload)
+	case message_types.BandwidthLimit:
+		err = s.setHostBandwidthLimit(hostUuid, req.payload)
+	default:
+		err = ws_errors.UnexpectedMessageTypeErr
+	}
+
+	if err != nil {
+		slog.Warn("failed to handle message pushed by host",
+			logging.HostIdKey, hostUuid,
+			"message_type", req.msgType,
+			"error", err,
+		)
+	}
+}
+
+// updateIndex checks the pushed update and queues it to be stored in the background
+func (s *defaultConnectionService) updateIndex(hostUuid uuid.UUID, payload []byte) error {
+	if len(payload) > maxIndexUpdateSize {
+		return ws_errors.InvalidMessageBodyErr
+	}
+
+	updateReq, err := newIndexUpdateDto(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(updateReq.update.Entries)+len(updateReq.update.RemovedPaths) > maxIndexUpdateEntries {
+		return ws_errors.InvalidMessageBodyErr
+	}
+
+	return s.indexUpdates.enqueue(hostUuid, updateReq)
+}
+
+func (s *defaultConnectionService) storeIndexUpdate(ctx context.Context, hostUuid uuid.UUID, updateReq indexUpdateDto) error {
+	return s.fileIndexRepository.Update(ctx, hostUuid, updateReq.resourceUuid, updateReq.update)
+}
+
+// SearchIndex searches the names in the index the host has published for the shared resource. The index is kept
+// when the host disconnects, so it answers for offline hosts with what they have published last.
+func (s *defaultConnectionService) SearchIndex(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	query string,
+	limit int,
+) ([]file_index_repository.IndexEntry, error) {
+	if query == "" {
+		return nil, ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	if limit <= 0 || limit > MaxSearchResults {
+		limit = MaxSearchResults
+	}
+
+	return s.fileIndexRepository.Search(ctx, hostUuid, resourceUuid, query, limit)
+}
+
+// listIndexedDirectory builds the listing of a directory of an offline host from its published index. Such
+// listings are marked as offline and carry no permissions. Directories missing from the index are reported
+// as an unknown host, the same as without the index.
+func (s *defaultConnectionService) listIndexedDirectory(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToDirectory string,
+	options ListOptions,
+) ([]byte, error) {
+	directory, err := s.fileIndexRepository.GetByPath(ctx, hostUuid, resourceUuid, pathToDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := s.fileIndexRepository.ListDirectory(ctx, hostUuid, resourceUuid, pathToDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	// The root is not indexed itself, it is known from its children
+	item := indexedDirectoryDto{Kind: directoryKind, Offline: true, Contents: []resourceMetadataDto{}}
+	if directory != nil {
+		if directory.Kind != directoryKind {
+			return nil, ws_errors.HostNotFoundErr
+		}
+		item.Name = directory.Name
+	} else if len(children) == 0 {
+		return nil, ws_errors.HostNotFoundErr
+	}
+
+	for _, child := range children {
+		item.Contents = append(item.Contents, resourceMetadataDto{
+			Path:     child.Path,
+			Name:     child.Name,
+			Kind:     child.Kind,
+			Size:     child.Size,
+			Modified: child.Modified,
+		})
+	}
+
+	itemJson, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageMetadataResponse(itemJson, options)
+}
+
+type indexedDirectoryDto struct {
+	Name     string                `json:"name"`
+	Kind     string                `json:"kind"`
+	Offline  bool                  `json:"offline"`
+	Contents []resourceMetadataDto `json:"contents"`
+}
+
+type indexUpdateDto struct {
+	resourceUuid uuid.UUID
+	update       file_index_repository.IndexUpdate
+}
+
+type indexUpdateJson struct {
+	Entries []file_index_repository.IndexEntry `json:"entries"`
+	Removed []string                           `json:"removed"`
+}
+
+// newIndexUpdateDto reads a payload laid out as IndexUpdate: resource UUID, flags byte and the JSON with
+// the entries to add and the paths to remove
+func newIndexUpdateDto(payload []byte) (indexUpdateDto, error) {
+	if len(payload) < uuidSize+1 {
+		return indexUpdateDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	resourceUuid, err := uuid.FromBytes(payload[:uuidSize])
+	if err != nil {
+		return indexUpdateDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	var updateJson indexUpdateJson
+	err = json.Unmarshal(payload[uuidSize+1:], &updateJson)
+	if err != nil {
+		return indexUpdateDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	return indexUpdateDto{
+		resourceUuid: resourceUuid,
+		update: file_index_repository.IndexUpdate{
+			Reset:        payload[uuidSize]&indexResetFlag != 0,
+			Entries:      updateJson.Entries,
+			RemovedPaths: updateJson.Removed,
+		},
+	}, nil
+}
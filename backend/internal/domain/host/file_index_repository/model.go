@@ -0,0 +1,22 @@
+package file_index_repository
+
+// IndexEntry is a file or a directory of a shared resource as published by its host. Paths are absolute within
+// the shared resource, the root being "/".
+type IndexEntry struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Size int64  `json:"size"`
+	// Modified is the modification time in milliseconds since the Unix epoch, 0 when the host does not report it
+	Modified int64 `json:"modified"`
+}
+
+// IndexUpdate is one batch of changes to the index of a shared resource
+type IndexUpdate struct {
+	// Reset removes the whole index of the shared resource before the entries are added
+	Reset bool
+	// Entries are added, or replace the entries with the same path
+	Entries []IndexEntry
+	// RemovedPaths are removed together with everything below them
+	RemovedPaths []string
+}
@@ -0,0 +1,317 @@
+package file_index_repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/db"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/google/uuid"
+)
+
+const rootPath = "/"
+
+// MaxEntriesPerResource is the largest number of entries kept in the index of a single shared resource
+const MaxEntriesPerResource = 100_000
+
+// ErrIndexTooLarge is returned by Update when the index of the resource would grow over MaxEntriesPerResource.
+// The update is not applied then.
+var ErrIndexTooLarge = errors.New("file index is too large")
+
+type FileIndexRepositoryInterface interface {
+	// Update applies the batch in a single transaction. It fails with ErrIndexTooLarge, changing nothing, if the index
+	// of the resource would have more than MaxEntriesPerResource entries.
+	Update(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, update IndexUpdate) error
+	// GetByPath returns nil if the path is not indexed
+	GetByPath(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, pathToResource string) (*IndexEntry, error)
+	// ListDirectory returns the direct children of the directory sorted by name
+	ListDirectory(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, pathToDirectory string) ([]IndexEntry, error)
+	// Search returns up to limit entries whose names contain words starting with every word of the query,
+	// best matches first. Without the full text search table, made only when SQLite has FTS5, it returns entries
+	// whose names contain every word of the query anywhere, sorted by path.
+	Search(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, query string, limit int) ([]IndexEntry, error)
+}
+
+type FileIndexRepository struct {
+	database db.SqlDatabaseInterface
+}
+
+func NewFileIndexRepository(database db.SqlDatabaseInterface) FileIndexRepositoryInterface {
+	return &FileIndexRepository{
+		database: database,
+	}
+}
+
+func (r *FileIndexRepository) Update(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, update IndexUpdate) error {
+	defer metrics.ObserveDbQuery("file_index.update", time.Now())
+
+	tx, err := r.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if update.Reset {
+		if err = r.removeAll(ctx, tx, hostId, resourceId); err != nil {
+			return err
+		}
+	}
+
+	for _, removedPath := range update.RemovedPaths {
+		if err = r.remove(ctx, tx, hostId, resourceId, cleanPath(removedPath)); err != nil {
+			return err
+		}
+	}
+
+	upsert, err := tx.PrepareContext(ctx, `
+        INSERT INTO file_index (host_id, resource_id, path, parent_path, name, kind, size, modified)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT(host_id, resource_id, path) DO UPDATE SET
+            name = excluded.name,
+            kind = excluded.kind,
+            size = excluded.size,
+            modified = excluded.modified
+    `)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	for _, entry := range update.Entries {
+		entryPath := cleanPath(entry.Path)
+		if entryPath == rootPath {
+			continue
+		}
+
+		name := entry.Name
+		if name == "" {
+			name = path.Base(entryPath)
+		}
+
+		_, err = upsert.ExecContext(ctx,
+			hostId,
+			resourceId,
+			entryPath,
+			path.Dir(entryPath),
+			name,
+			entry.Kind,
+			entry.Size,
+			entry.Modified,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	countQuery := `
+        SELECT count(*) FROM file_index
+        WHERE host_id = $1 AND resource_id = $2
+    `
+
+	var count int
+	err = tx.QueryRowContext(ctx, countQuery, hostId, resourceId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > MaxEntriesPerResource {
+		return ErrIndexTooLarge
+	}
+
+	return tx.Commit()
+}
+
+func (r *FileIndexRepository) removeAll(ctx context.Context, tx *sql.Tx, hostId uuid.UUID, resourceId uuid.UUID) error {
+	query := `
+        DELETE FROM file_index
+        WHERE host_id = $1 AND resource_id = $2
+    `
+
+	_, err := tx.ExecContext(ctx, query, hostId, resourceId)
+	return err
+}
+
+func (r *FileIndexRepository) remove(ctx context.Context, tx *sql.Tx, hostId uuid.UUID, resourceId uuid.UUID, removedPath string) error {
+	if removedPath == rootPath {
+		return r.removeAll(ctx, tx, hostId, resourceId)
+	}
+
+	query := `
+        DELETE FROM file_index
+        WHERE (
+            host_id = $1
+            AND resource_id = $2
+            AND (path = $3 OR substr(path, 1, length($3) + 1) = $3 || '/')
+        )
+    `
+
+	_, err := tx.ExecContext(ctx, query, hostId, resourceId, removedPath)
+	return err
+}
+
+func (r *FileIndexRepository) GetByPath(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, pathToResource string) (*IndexEntry, error) {
+	defer metrics.ObserveDbQuery("file_index.get_by_path", time.Now())
+
+	query := `
+        SELECT path, name, kind, size, modified
+        FROM file_index
+        WHERE host_id = $1 AND resource_id = $2 AND path = $3
+        LIMIT 1;
+    `
+
+	row := r.database.QueryRowContext(ctx, query, hostId, resourceId, cleanPath(pathToResource))
+
+	var entry IndexEntry
+	err := row.Scan(
+		&entry.Path,
+		&entry.Name,
+		&entry.Kind,
+		&entry.Size,
+		&entry.Modified,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (r *FileIndexRepository) ListDirectory(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, pathToDirectory string) ([]IndexEntry, error) {
+	defer metrics.ObserveDbQuery("file_index.list_directory", time.Now())
+
+	query := `
+        SELECT path, name, kind, size, modified
+        FROM file_index
+        WHERE host_id = $1 AND resource_id = $2 AND parent_path = $3
+        ORDER BY name;
+    `
+
+	return r.queryEntries(ctx, query, hostId, resourceId, cleanPath(pathToDirectory))
+}
+
+func (r *FileIndexRepository) Search(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, query string, limit int) ([]IndexEntry, error) {
+	defer metrics.ObserveDbQuery("file_index.search", time.Now())
+
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	hasFts, err := r.hasFullTextSearch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !hasFts {
+		return r.searchWithLike(ctx, hostId, resourceId, words, limit)
+	}
+
+	searchQuery := `
+        SELECT file_index.path, file_index.name, file_index.kind, file_index.size, file_index.modified
+        FROM file_index_fts
+        JOIN file_index ON file_index.id = file_index_fts.rowid
+        WHERE (
+            file_index_fts MATCH $1
+            AND file_index.host_id = $2
+            AND file_index.resource_id = $3
+        )
+        ORDER BY file_index_fts.rank, file_index.path
+        LIMIT $4;
+    `
+
+	return r.queryEntries(ctx, searchQuery, matchExpression(words), hostId, resourceId, limit)
+}
+
+func (r *FileIndexRepository) hasFullTextSearch(ctx context.Context) (bool, error) {
+	var count int
+	err := r.database.QueryRowContext(ctx,
+		`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'file_index_fts'`,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// searchWithLike searches the names with a LIKE pattern for every word, matching ASCII letters of any case
+func (r *FileIndexRepository) searchWithLike(
+	ctx context.Context,
+	hostId uuid.UUID,
+	resourceId uuid.UUID,
+	words []string,
+	limit int,
+) ([]IndexEntry, error) {
+	searchQuery := `
+        SELECT path, name, kind, size, modified
+        FROM file_index
+        WHERE host_id = $1 AND resource_id = $2`
+	args := []any{hostId, resourceId}
+	for _, word := range words {
+		args = append(args, likePattern(word))
+		searchQuery += fmt.Sprintf(` AND name LIKE $%d ESCAPE '\'`, len(args))
+	}
+	args = append(args, limit)
+	searchQuery += fmt.Sprintf(`
+        ORDER BY path
+        LIMIT $%d;
+    `, len(args))
+
+	return r.queryEntries(ctx, searchQuery, args...)
+}
+
+func (r *FileIndexRepository) queryEntries(ctx context.Context, query string, args ...any) ([]IndexEntry, error) {
+	rows, err := r.database.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []IndexEntry
+	for rows.Next() {
+		var entry IndexEntry
+		err = rows.Scan(
+			&entry.Path,
+			&entry.Name,
+			&entry.Kind,
+			&entry.Size,
+			&entry.Modified,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// cleanPath makes paths sent by hosts and clients comparable: "", "photos/" and "/photos/../photos" become
+// "/" and "/photos"
+func cleanPath(p string) string {
+	return path.Clean(rootPath + p)
+}
+
+// matchExpression turns the words of a search query into an FTS5 expression matching names with words starting
+// with all of them. Every word is quoted, so characters the FTS5 syntax uses are searched for as they are.
+func matchExpression(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// likePattern makes a LIKE pattern matching names containing the word, with the wildcards of LIKE in the word
+// searched for as they are
+func likePattern(word string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(word)
+	return "%" + escaped + "%"
+}
@@ -0,0 +1,40 @@
+package file_index_repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockFileIndexRepository struct {
+	mock.Mock
+}
+
+func (m *MockFileIndexRepository) Update(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, update IndexUpdate) error {
+	args := m.Called(ctx, hostId, resourceId, update)
+	return args.Error(0)
+}
+
+func (m *MockFileIndexRepository) GetByPath(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, pathToResource string) (*IndexEntry, error) {
+	args := m.Called(ctx, hostId, resourceId, pathToResource)
+	if entry, ok := args.Get(0).(*IndexEntry); ok {
+		return entry, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockFileIndexRepository) ListDirectory(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, pathToDirectory string) ([]IndexEntry, error) {
+	args := m.Called(ctx, hostId, resourceId, pathToDirectory)
+	if entries, ok := args.Get(0).([]IndexEntry); ok {
+		return entries, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockFileIndexRepository) Search(ctx context.Context, hostId uuid.UUID, resourceId uuid.UUID, query string, limit int) ([]IndexEntry, error) {
+	args := m.Called(ctx, hostId, resourceId, query, limit)
+	if entries, ok := args.Get(0).([]IndexEntry); ok {
+		return entries, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
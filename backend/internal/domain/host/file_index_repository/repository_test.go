@@ -0,0 +1,182 @@
+package file_index_repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/db"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepository makes a repository on a new in-memory database with every migration applied. Built with
+// the sqlite_fts5 tag it searches with the full text search table, otherwise with LIKE.
+func newTestRepository(t *testing.T) FileIndexRepositoryInterface {
+	database, err := db.NewSqlDatabase(
+		context.Background(),
+		"sqlite3",
+		"file:"+uuid.NewString()+"?mode=memory&cache=shared",
+		"../../../../migrations",
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	return NewFileIndexRepository(database)
+}
+
+func entry(entryPath string, kind string) IndexEntry {
+	return IndexEntry{Path: entryPath, Kind: kind, Size: 1, Modified: 1700000000000}
+}
+
+func paths(entries []IndexEntry) []string {
+	result := []string{}
+	for _, e := range entries {
+		result = append(result, e.Path)
+	}
+	return result
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success - entries are added, replaced and removed with everything below them", func(t *testing.T) {
+		repo := newTestRepository(t)
+		hostId, resourceId := uuid.New(), uuid.New()
+
+		err := repo.Update(ctx, hostId, resourceId, IndexUpdate{Entries: []IndexEntry{
+			entry("/photos", "directory"),
+			entry("/photos/a.jpg", "file"),
+			entry("/photos/b.jpg", "file"),
+			entry("/photos-old", "directory"),
+			entry("/notes.txt", "file"),
+		}})
+		require.NoError(t, err)
+
+		replaced := entry("/photos/a.jpg", "file")
+		replaced.Size = 10
+		err = repo.Update(ctx, hostId, resourceId, IndexUpdate{
+			Entries:      []IndexEntry{replaced},
+			RemovedPaths: []string{"/photos/b.jpg", "notes.txt/"},
+		})
+		require.NoError(t, err)
+
+		children, err := repo.ListDirectory(ctx, hostId, resourceId, "/photos")
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		assert.Equal(t, "a.jpg", children[0].Name)
+		assert.Equal(t, int64(10), children[0].Size)
+
+		err = repo.Update(ctx, hostId, resourceId, IndexUpdate{RemovedPaths: []string{"/photos"}})
+		require.NoError(t, err)
+
+		root, err := repo.ListDirectory(ctx, hostId, resourceId, "/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/photos-old"}, paths(root))
+	})
+
+	t.Run("success - reset replaces the index of the resource only", func(t *testing.T) {
+		repo := newTestRepository(t)
+		hostId, resourceId, otherResourceId := uuid.New(), uuid.New(), uuid.New()
+
+		require.NoError(t, repo.Update(ctx, hostId, resourceId, IndexUpdate{Entries: []IndexEntry{entry("/old.txt", "file")}}))
+		require.NoError(t, repo.Update(ctx, hostId, otherResourceId, IndexUpdate{Entries: []IndexEntry{entry("/kept.txt", "file")}}))
+
+		err := repo.Update(ctx, hostId, resourceId, IndexUpdate{Reset: true, Entries: []IndexEntry{entry("/new.txt", "file")}})
+		require.NoError(t, err)
+
+		root, err := repo.ListDirectory(ctx, hostId, resourceId, "/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/new.txt"}, paths(root))
+
+		old, err := repo.GetByPath(ctx, hostId, resourceId, "/old.txt")
+		require.NoError(t, err)
+		assert.Nil(t, old)
+
+		other, err := repo.ListDirectory(ctx, hostId, otherResourceId, "/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/kept.txt"}, paths(other))
+	})
+
+	t.Run("error - update growing the index over the limit changes nothing", func(t *testing.T) {
+		repo := newTestRepository(t)
+		hostId, resourceId := uuid.New(), uuid.New()
+
+		require.NoError(t, repo.Update(ctx, hostId, resourceId, IndexUpdate{Entries: []IndexEntry{entry("/kept.txt", "file")}}))
+
+		entries := make([]IndexEntry, MaxEntriesPerResource)
+		for i := range entries {
+			entries[i] = entry("/"+uuid.NewString(), "file")
+		}
+		err := repo.Update(ctx, hostId, resourceId, IndexUpdate{Entries: entries})
+		assert.ErrorIs(t, err, ErrIndexTooLarge)
+
+		root, err := repo.ListDirectory(ctx, hostId, resourceId, "/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/kept.txt"}, paths(root))
+	})
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	hostId, resourceId := uuid.New(), uuid.New()
+
+	err := repo.Update(ctx, hostId, resourceId, IndexUpdate{Entries: []IndexEntry{
+		entry("/Holiday Photos", "directory"),
+		entry("/Holiday Photos/beach.jpg", "file"),
+		entry("/photography.pdf", "file"),
+		entry("/a_b.txt", "file"),
+		entry("/axb.txt", "file"),
+		entry(`/say "hi".txt`, "file"),
+		entry("/NOT this.txt", "file"),
+		entry("/this.txt", "file"),
+	}})
+	require.NoError(t, err)
+	require.NoError(t, repo.Update(ctx, uuid.New(), resourceId, IndexUpdate{Entries: []IndexEntry{entry("/photos", "directory")}}))
+
+	t.Run("success - words are matched by their beginning in any case", func(t *testing.T) {
+		results, err := repo.Search(ctx, hostId, resourceId, "PHOTO", 10)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"/Holiday Photos", "/photography.pdf"}, paths(results))
+	})
+
+	t.Run("success - every word of the query has to match", func(t *testing.T) {
+		results, err := repo.Search(ctx, hostId, resourceId, "  holi   phot ", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/Holiday Photos"}, paths(results))
+	})
+
+	t.Run("success - results are limited", func(t *testing.T) {
+		results, err := repo.Search(ctx, hostId, resourceId, "txt", 2)
+		require.NoError(t, err)
+		assert.Len(t, results, 2)
+	})
+
+	t.Run("success - search syntax in the query is searched for as it is", func(t *testing.T) {
+		results, err := repo.Search(ctx, hostId, resourceId, "a_b", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/a_b.txt"}, paths(results))
+
+		results, err = repo.Search(ctx, hostId, resourceId, `say "hi"`, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{`/say "hi".txt`}, paths(results))
+
+		results, err = repo.Search(ctx, hostId, resourceId, "NOT this", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/NOT this.txt"}, paths(results))
+
+		results, err = repo.Search(ctx, hostId, resourceId, `beach* OR "`, 10)
+		require.NoError(t, err)
+		assert.Empty(t, results)
+	})
+
+	t.Run("success - empty query finds nothing", func(t *testing.T) {
+		results, err := repo.Search(ctx, hostId, resourceId, "   ", 10)
+		require.NoError(t, err)
+		assert.Empty(t, results)
+	})
+}
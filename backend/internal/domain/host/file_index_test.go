@@ -0,0 +1,259 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexUpdate(t *testing.T) {
+	t.Run("success - pushed updates are stored", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockConn := &hostconn.MockConn{}
+		mockWs := &websocket.Conn{}
+		mockSavedConnectionsRepo := &saved_connections_repository.MockSavedConnectionsRepository{}
+		mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+		defer mockIndexRepo.AssertExpectations(t)
+
+		mockHostMap.On("AddNew", mockWs).Return(hostId)
+		mockHostMap.On("Get", hostId).Return(mockConn, true)
+		mockConn.On("Query", mock.Anything).Return(message_types.ACK.Binary(), nil)
+		mockSavedConnectionsRepo.On("AddOrRenew", mock.Anything, mock.Anything).Return(nil)
+
+		entry := file_index_repository.IndexEntry{Path: "/photos/a.jpg", Name: "a.jpg", Kind: fileKind, Size: 3, Modified: 1700000000000}
+		stored := make(chan context.Context, 1)
+		mockIndexRepo.On("Update", mock.Anything, hostId, resourceId, file_index_repository.IndexUpdate{
+			Reset:        true,
+			Entries:      []file_index_repository.IndexEntry{entry},
+			RemovedPaths: []string{"/old"},
+		}).Run(func(args mock.Arguments) {
+			stored <- args.Get(0).(context.Context)
+		}).Return(nil).Once()
+
+		svc := NewHostService(mockHostMap, mockSavedConnectionsRepo, mockIndexRepo, BandwidthLimits{}, testChunkSize)
+		err := svc.InitNewHostConnection(context.Background(), mockWs, "127.0.0.1")
+		require.NoError(t, err)
+
+		updateJson, err := json.Marshal(map[string]any{
+			"entries": []file_index_repository.IndexEntry{entry},
+			"removed": []string{"/old"},
+		})
+		require.NoError(t, err)
+
+		push := append(message_types.IndexUpdate.Binary(), helpers.UUIDToBinary(resourceId)...)
+		push = append(push, indexResetFlag)
+		mockConn.PushHandler(append(push, updateJson...))
+
+		select {
+		case ctx := <-stored:
+			_, hasDeadline := ctx.Deadline()
+			assert.True(t, hasDeadline)
+		case <-time.After(time.Second):
+			t.Fatal("the pushed update has not been stored")
+		}
+	})
+
+	t.Run("error - malformed updates are dropped", func(t *testing.T) {
+		mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, mockIndexRepo, BandwidthLimits{}, testChunkSize)
+
+		push := append(message_types.IndexUpdate.Binary(), helpers.UUIDToBinary(uuid.New())...)
+		push = append(push, 0, '{')
+		svc.(*defaultConnectionService).handleHostPush(uuid.New(), push)
+
+		mockIndexRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("error - updates over the limits are dropped", func(t *testing.T) {
+		mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, mockIndexRepo, BandwidthLimits{}, testChunkSize)
+
+		updateJson, err := json.Marshal(map[string]any{"removed": make([]string, maxIndexUpdateEntries+1)})
+		require.NoError(t, err)
+
+		payload := append(helpers.UUIDToBinary(uuid.New()), 0)
+		err = svc.(*defaultConnectionService).updateIndex(uuid.New(), append(payload, updateJson...))
+		assert.ErrorIs(t, err, ws_errors.InvalidMessageBodyErr)
+
+		err = svc.(*defaultConnectionService).updateIndex(uuid.New(), make([]byte, maxIndexUpdateSize+1))
+		assert.ErrorIs(t, err, ws_errors.InvalidMessageBodyErr)
+
+		mockIndexRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("error - updates pushed while the queue is full are dropped", func(t *testing.T) {
+		hostId := uuid.New()
+		release := make(chan struct{})
+		queues := newIndexUpdateQueues(func(ctx context.Context, hostUuid uuid.UUID, update indexUpdateDto) error {
+			<-release
+			return nil
+		})
+		defer close(release)
+		defer queues.stop(hostId)
+
+		// One update is being stored while the others wait in the queue
+		var err error
+		for i := 0; i <= indexUpdateQueueSize+1 && err == nil; i++ {
+			err = queues.enqueue(hostId, indexUpdateDto{})
+		}
+		assert.ErrorIs(t, err, errIndexUpdateQueueFull)
+	})
+
+	t.Run("success - stopping all queues waits for the queued updates", func(t *testing.T) {
+		release := make(chan struct{})
+		var stored atomic.Int32
+		queues := newIndexUpdateQueues(func(ctx context.Context, hostUuid uuid.UUID, update indexUpdateDto) error {
+			<-release
+			stored.Add(1)
+			return nil
+		})
+
+		require.NoError(t, queues.enqueue(uuid.New(), indexUpdateDto{}))
+		require.NoError(t, queues.enqueue(uuid.New(), indexUpdateDto{}))
+
+		stopped := make(chan struct{})
+		go func() {
+			queues.stopAll(context.Background())
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			t.Fatal("queues stopped before their updates were stored")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(release)
+		<-stopped
+		assert.Equal(t, int32(2), stored.Load())
+		assert.ErrorIs(t, queues.enqueue(uuid.New(), indexUpdateDto{}), errIndexUpdateQueuesStopped)
+	})
+
+	t.Run("error - updates not stored in time are cancelled", func(t *testing.T) {
+		hostId := uuid.New()
+		var stored atomic.Int32
+		queues := newIndexUpdateQueues(func(ctx context.Context, hostUuid uuid.UUID, update indexUpdateDto) error {
+			<-ctx.Done()
+			stored.Add(1)
+			return ctx.Err()
+		})
+
+		require.NoError(t, queues.enqueue(hostId, indexUpdateDto{}))
+		require.NoError(t, queues.enqueue(hostId, indexUpdateDto{}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		queues.stopAll(ctx)
+
+		// The update being stored is cancelled and the one behind it is dropped
+		assert.Equal(t, int32(1), stored.Load())
+	})
+}
+
+func TestListIndexedDirectory(t *testing.T) {
+	t.Run("success - offline host is listed from its index", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+		defer mockIndexRepo.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(nil, false)
+		mockIndexRepo.On("GetByPath", mock.Anything, hostId, resourceId, "/photos").
+			Return(&file_index_repository.IndexEntry{Path: "/photos", Name: "photos", Kind: directoryKind}, nil).Once()
+		mockIndexRepo.On("ListDirectory", mock.Anything, hostId, resourceId, "/photos").Return([]file_index_repository.IndexEntry{
+			{Path: "/photos/a.jpg", Name: "a.jpg", Kind: fileKind, Size: 30},
+			{Path: "/photos/b.jpg", Name: "b.jpg", Kind: fileKind, Size: 10},
+		}, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, mockIndexRepo, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.ListDirectory(context.Background(), hostId, resourceId, "/photos", ListOptions{SortKey: ListSortBySize})
+		require.NoError(t, err)
+
+		nextCursor, page := readListPage(t, resp)
+		assert.Equal(t, uint32(0), nextCursor)
+		assert.Equal(t, "photos", page.Name)
+		assert.Equal(t, []string{"b.jpg", "a.jpg"}, pageNames(page))
+
+		var item map[string]any
+		require.NoError(t, json.Unmarshal(resp[2+1+4:], &item))
+		assert.Equal(t, true, item["offline"])
+	})
+
+	t.Run("error - index is read with the context of the request", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+		defer mockIndexRepo.AssertExpectations(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		mockHostMap.On("Get", hostId).Return(nil, false)
+		mockIndexRepo.On("GetByPath", mock.MatchedBy(func(ctx context.Context) bool {
+			return ctx.Err() != nil
+		}), hostId, resourceId, "/photos").Return(nil, context.Canceled).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, mockIndexRepo, BandwidthLimits{}, testChunkSize)
+		_, err := svc.ListDirectory(ctx, hostId, resourceId, "/photos", ListOptions{})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("error - directory missing from the index", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+
+		mockHostMap.On("Get", hostId).Return(nil, false)
+		mockIndexRepo.On("GetByPath", mock.Anything, hostId, resourceId, "/").Return(nil, nil).Once()
+		mockIndexRepo.On("ListDirectory", mock.Anything, hostId, resourceId, "/").Return(nil, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, mockIndexRepo, BandwidthLimits{}, testChunkSize)
+		_, err := svc.ListDirectory(context.Background(), hostId, resourceId, "/", ListOptions{})
+
+		assert.ErrorIs(t, err, ws_errors.HostNotFoundErr)
+	})
+}
+
+func TestSearchIndex(t *testing.T) {
+	t.Run("success - limit is lowered to the maximum", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+		defer mockIndexRepo.AssertExpectations(t)
+
+		results := []file_index_repository.IndexEntry{{Path: "/a.jpg", Name: "a.jpg", Kind: fileKind}}
+		mockIndexRepo.On("Search", mock.Anything, hostId, resourceId, "a", MaxSearchResults).Return(results, nil).Once()
+
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, mockIndexRepo, BandwidthLimits{}, testChunkSize)
+		found, err := svc.SearchIndex(context.Background(), hostId, resourceId, "a", 5000)
+
+		assert.NoError(t, err)
+		assert.Equal(t, results, found)
+	})
+
+	t.Run("error - empty query", func(t *testing.T) {
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.SearchIndex(context.Background(), uuid.New(), uuid.New(), "", 10)
+
+		assert.ErrorIs(t, err, ws_errors.MissingOrInvalidRequiredParamsErr)
+	})
+}
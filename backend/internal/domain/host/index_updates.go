@@ -0,0 +1,136 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/google/uuid"
+)
+
+const (
+	// indexUpdateQueueSize is the number of index updates of a host waiting to be stored. Updates pushed while
+	// the queue is full are dropped.
+	indexUpdateQueueSize = 16
+	// indexUpdateTimeout bounds the time a single index update may take to be stored
+	indexUpdateTimeout = 30 * time.Second
+)
+
+var (
+	errIndexUpdateQueueFull     = errors.New("index update queue is full")
+	errIndexUpdateQueuesStopped = errors.New("index update queues are stopped")
+)
+
+// indexUpdateQueues stores the index updates pushed by hosts in the background, so that the connection of a host
+// is not held up reading its messages while the database is busy. Every host has its own queue, handled by its own
+// goroutine, which keeps the updates of a host in order.
+type indexUpdateQueues struct {
+	mu      sync.Mutex
+	queues  map[uuid.UUID]chan indexUpdateDto
+	stopped bool
+	store   func(ctx context.Context, hostUuid uuid.UUID, update indexUpdateDto) error
+
+	// ctx is the parent of the contexts updates are stored with, it is cancelled once stopAll gives up waiting
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+func newIndexUpdateQueues(store func(ctx context.Context, hostUuid uuid.UUID, update indexUpdateDto) error) *indexUpdateQueues {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &indexUpdateQueues{
+		queues: make(map[uuid.UUID]chan indexUpdateDto),
+		store:  store,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// enqueue adds the update to the queue of the host, starting the queue if needed. It never blocks, it fails with
+// errIndexUpdateQueueFull instead.
+func (q *indexUpdateQueues) enqueue(hostUuid uuid.UUID, update indexUpdateDto) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		return errIndexUpdateQueuesStopped
+	}
+
+	queue, ok := q.queues[hostUuid]
+	if !ok {
+		queue = make(chan indexUpdateDto, indexUpdateQueueSize)
+		q.queues[hostUuid] = queue
+		q.running.Add(1)
+		go q.run(hostUuid, queue)
+	}
+
+	select {
+	case queue <- update:
+		return nil
+	default:
+		return errIndexUpdateQueueFull
+	}
+}
+
+// stop ends the queue of the host once the updates already in it are stored
+func (q *indexUpdateQueues) stop(hostUuid uuid.UUID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if queue, ok := q.queues[hostUuid]; ok {
+		close(queue)
+		delete(q.queues, hostUuid)
+	}
+}
+
+// stopAll ends the queues of all hosts and refuses further updates. It waits until the updates already queued are
+// stored or ctx is done, in which case the updates still being stored are cancelled and the rest is dropped.
+// It returns once no queue goroutine is running anymore, so the store can be closed afterwards.
+func (q *indexUpdateQueues) stopAll(ctx context.Context) {
+	q.mu.Lock()
+	q.stopped = true
+	for hostUuid, queue := range q.queues {
+		close(queue)
+		delete(q.queues, hostUuid)
+	}
+	q.mu.Unlock()
+
+	stored := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(stored)
+	}()
+
+	select {
+	case <-stored:
+	case <-ctx.Done():
+		slog.Warn("timeout reached, dropping index updates which are not stored yet")
+		q.cancel()
+		<-stored
+	}
+}
+
+func (q *indexUpdateQueues) run(hostUuid uuid.UUID, queue <-chan indexUpdateDto) {
+	defer q.running.Done()
+
+	for update := range queue {
+		if q.ctx.Err() != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(q.ctx, indexUpdateTimeout)
+		err := q.store(ctx, hostUuid, update)
+		cancel()
+
+		if err != nil {
+			slog.Warn("failed to store index update",
+				logging.HostIdKey, hostUuid,
+				"resource_id", update.resourceUuid,
+				"error", err,
+			)
+		}
+	}
+}
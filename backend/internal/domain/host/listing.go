@@ -0,0 +1,245 @@
+package host
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/google/uuid"
+)
+
+// MaxListPageSize is the largest page of a directory listing. Larger and zero page sizes are lowered to it.
+const MaxListPageSize = 1000
+
+const (
+	listCursorSize     = 4
+	listPageSizeSize   = 2
+	listDescendingFlag = 1
+)
+
+// ListSortKey selects the field a directory listing is sorted by. Entries with equal keys are sorted by name.
+type ListSortKey uint8
+
+const (
+	ListSortByName     ListSortKey = 0
+	ListSortBySize     ListSortKey = 1
+	ListSortByModified ListSortKey = 2
+)
+
+// ListOptions select a page of a directory listing
+type ListOptions struct {
+	// Cursor is the next cursor of the previous page, 0 for the first page
+	Cursor     uint32
+	PageSize   uint16
+	SortKey    ListSortKey
+	Descending bool
+	// NameFilter keeps only entries whose name contains it, ignoring case
+	NameFilter string
+}
+
+func (o ListOptions) flags() byte {
+	if o.Descending {
+		return listDescendingFlag
+	}
+
+	return 0
+}
+
+// ListDirectory returns a page of the directory contents as ListDirectoryResponse. Hosts announcing
+// CapabilityPagedListing are asked for the page directly. For other hosts the relay pages the full MetadataResponse
+// itself; their entries carry no size or modification time, so such listings are effectively sorted by name.
+// Encrypted metadata can not be paged by the relay and is returned as the MetadataResponse the host sent.
+// Host errors are returned as the Error message the host sent. Directories of offline hosts are listed from
+// the index the host has published.
+func (s *defaultConnectionService) ListDirectory(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToDirectory string,
+	options ListOptions,
+) ([]byte, error) {
+	if options.SortKey > ListSortByModified {
+		return nil, ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	if options.PageSize == 0 || options.PageSize > MaxListPageSize {
+		options.PageSize = MaxListPageSize
+	}
+
+	hostConn, ok := s.getHostConn(ctx, hostUuid)
+	if !ok {
+		return s.listIndexedDirectory(ctx, hostUuid, resourceUuid, pathToDirectory, options)
+	}
+
+	if hostConn.Capabilities().Has(hostconn.CapabilityPagedListing) {
+		return hostConn.Query(
+			message_types.ListDirectoryQuery.Binary(),
+			helpers.UUIDToBinary(resourceUuid),
+			helpers.Uint32ToBinary(options.Cursor),
+			helpers.Uint16ToBinary(options.PageSize),
+			[]byte{byte(options.SortKey), options.flags()},
+			[]byte(helpers.AddNullCharToString(options.NameFilter)),
+			[]byte(helpers.AddNullCharToString(pathToDirectory)),
+		)
+	}
+
+	metadataResp, err := s.queryHostResource(ctx, hostUuid, resourceUuid, pathToDirectory, message_types.MetadataQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	msgType, err := message_types.GetMsgType(metadataResp)
+	if err != nil {
+		return nil, err
+	}
+
+	if msgType != message_types.MetadataResponse || len(metadataResp) <= message_types.WebsocketMessageTypeSize {
+		return metadataResp, nil
+	}
+
+	if metadataResp[message_types.WebsocketMessageTypeSize]&encryptedFlag != 0 {
+		return metadataResp, nil
+	}
+
+	return pageMetadataResponse(metadataResp[message_types.WebsocketMessageTypeSize+1:], options)
+}
+
+// listEntry keeps the JSON of a directory entry as the host sent it next to the fields the listing is sorted by
+type listEntry struct {
+	raw      json.RawMessage
+	metadata resourceMetadataDto
+}
+
+// pageMetadataResponse builds ListDirectoryResponse from the JSON item of a MetadataResponse. Fields of the item
+// the relay does not know about are kept.
+func pageMetadataResponse(itemJson []byte, options ListOptions) ([]byte, error) {
+	var item map[string]json.RawMessage
+	err := json.Unmarshal(itemJson, &item)
+	if err != nil {
+		return nil, ws_errors.InvalidMessageBodyErr
+	}
+
+	var rawContents []json.RawMessage
+	if contentsJson, ok := item["contents"]; ok {
+		err = json.Unmarshal(contentsJson, &rawContents)
+		if err != nil {
+			return nil, ws_errors.InvalidMessageBodyErr
+		}
+	}
+
+	nameFilter := strings.ToLower(options.NameFilter)
+	entries := make([]listEntry, 0, len(rawContents))
+	for _, raw := range rawContents {
+		entry := listEntry{raw: raw}
+		err = json.Unmarshal(raw, &entry.metadata)
+		if err != nil {
+			return nil, ws_errors.InvalidMessageBodyErr
+		}
+
+		if strings.Contains(strings.ToLower(entry.metadata.Name), nameFilter) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sortListEntries(entries, options)
+
+	start := min(int(options.Cursor), len(entries))
+	end := min(start+int(options.PageSize), len(entries))
+	var nextCursor uint32
+	if end < len(entries) {
+		nextCursor = uint32(end)
+	}
+
+	page := make([]json.RawMessage, 0, end-start)
+	for _, entry := range entries[start:end] {
+		page = append(page, entry.raw)
+	}
+
+	item["contents"], err = json.Marshal(page)
+	if err != nil {
+		return nil, err
+	}
+	item["total"], err = json.Marshal(len(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	pageJson, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := bytes.NewBuffer(message_types.ListDirectoryResponse.Binary())
+	resp.WriteByte(0)
+	resp.Write(helpers.Uint32ToBinary(nextCursor))
+	resp.Write(pageJson)
+
+	return resp.Bytes(), nil
+}
+
+func sortListEntries(entries []listEntry, options ListOptions) {
+	slices.SortStableFunc(entries, func(a, b listEntry) int {
+		var order int
+		switch options.SortKey {
+		case ListSortBySize:
+			order = cmp.Compare(a.metadata.Size, b.metadata.Size)
+		case ListSortByModified:
+			order = cmp.Compare(a.metadata.Modified, b.metadata.Modified)
+		}
+		if order == 0 {
+			order = cmp.Compare(strings.ToLower(a.metadata.Name), strings.ToLower(b.metadata.Name))
+		}
+
+		if options.Descending {
+			return -order
+		}
+		return order
+	})
+}
+
+type listRequestDto struct {
+	resourceUuid uuid.UUID
+	options      ListOptions
+	path         string
+}
+
+// newListRequestDto reads a payload laid out as ListDirectoryQuery: resource UUID, cursor, page size, sort key,
+// flags, null terminated name filter and null terminated path
+func newListRequestDto(payload []byte) (listRequestDto, error) {
+	headerSize := uuidSize + listCursorSize + listPageSizeSize + 2
+	if len(payload) < headerSize {
+		return listRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	resourceUuid, err := uuid.FromBytes(payload[:uuidSize])
+	if err != nil {
+		return listRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	strs := bytes.SplitN(payload[headerSize:], []byte{0}, 3)
+	if len(strs) < 2 {
+		return listRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	offset := uuidSize
+	options := ListOptions{
+		Cursor:     helpers.BinaryToUint32(payload[offset : offset+listCursorSize]),
+		PageSize:   helpers.BinaryToUint16(payload[offset+listCursorSize : offset+listCursorSize+listPageSizeSize]),
+		SortKey:    ListSortKey(payload[headerSize-2]),
+		Descending: payload[headerSize-1]&listDescendingFlag != 0,
+		NameFilter: string(strs[0]),
+	}
+
+	return listRequestDto{
+		resourceUuid: resourceUuid,
+		options:      options,
+		path:         string(strs[1]),
+	}, nil
+}
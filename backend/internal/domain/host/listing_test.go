@@ -0,0 +1,153 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type listPage struct {
+	Name     string                `json:"name"`
+	Perms    map[string]bool       `json:"perms"`
+	Contents []resourceMetadataDto `json:"contents"`
+	Total    int                   `json:"total"`
+}
+
+func readListPage(t *testing.T, resp []byte) (uint32, listPage) {
+	msgType, err := message_types.GetMsgType(resp)
+	require.NoError(t, err)
+	require.Equal(t, message_types.ListDirectoryResponse, msgType)
+
+	var page listPage
+	require.NoError(t, json.Unmarshal(resp[2+1+4:], &page))
+
+	return helpers.BinaryToUint32(resp[2+1 : 2+1+4]), page
+}
+
+func pageNames(page listPage) []string {
+	var names []string
+	for _, entry := range page.Contents {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func TestListDirectory(t *testing.T) {
+	photosResp := func(t *testing.T) []byte {
+		item, err := json.Marshal(map[string]any{
+			"name":  "photos",
+			"kind":  directoryKind,
+			"perms": map[string]bool{"AllowDeleteFile": true},
+			"contents": []map[string]any{
+				{"name": "IMG_3.jpg", "kind": fileKind},
+				{"name": "notes.txt", "kind": fileKind},
+				{"name": "img_1.jpg", "kind": fileKind},
+				{"name": "IMG_2.jpg", "kind": fileKind},
+			},
+		})
+		require.NoError(t, err)
+
+		return append(append(message_types.MetadataResponse.Binary(), 0), item...)
+	}
+
+	t.Run("success - relay pages hosts without paged listing", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, "/photos")).Return(photosResp(t), nil).Twice()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+
+		options := ListOptions{PageSize: 2, Descending: true, NameFilter: "img"}
+		resp, err := svc.ListDirectory(context.Background(), hostId, resourceId, "/photos", options)
+		require.NoError(t, err)
+		nextCursor, page := readListPage(t, resp)
+		assert.Equal(t, uint32(2), nextCursor)
+		assert.Equal(t, []string{"IMG_3.jpg", "IMG_2.jpg"}, pageNames(page))
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, "photos", page.Name)
+		assert.Equal(t, map[string]bool{"AllowDeleteFile": true}, page.Perms)
+
+		options.Cursor = nextCursor
+		resp, err = svc.ListDirectory(context.Background(), hostId, resourceId, "/photos", options)
+		require.NoError(t, err)
+		nextCursor, page = readListPage(t, resp)
+		assert.Equal(t, uint32(0), nextCursor)
+		assert.Equal(t, []string{"img_1.jpg"}, pageNames(page))
+	})
+
+	t.Run("success - hosts with paged listing get the query", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{HostCapabilities: hostconn.CapabilityPagedListing}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		hostResp := append(message_types.ListDirectoryResponse.Binary(), 1, 2, 3)
+		mockHostConn.On("Query", [][]byte{
+			message_types.ListDirectoryQuery.Binary(),
+			helpers.UUIDToBinary(resourceId),
+			helpers.Uint32ToBinary(40),
+			helpers.Uint16ToBinary(MaxListPageSize),
+			{byte(ListSortByModified), listDescendingFlag},
+			[]byte("jpg\000"),
+			[]byte("/photos\000"),
+		}).Return(hostResp, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		options := ListOptions{Cursor: 40, PageSize: 5000, SortKey: ListSortByModified, Descending: true, NameFilter: "jpg"}
+		resp, err := svc.ListDirectory(context.Background(), hostId, resourceId, "/photos", options)
+
+		assert.NoError(t, err)
+		assert.Equal(t, hostResp, resp)
+	})
+
+	t.Run("success - encrypted metadata is returned whole", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		encryptedResp := append(message_types.MetadataResponse.Binary(), encryptedFlag, 9, 9, 9)
+		mockHostConn.On("Query", resourceQuery(message_types.MetadataQuery, resourceId, "/photos")).Return(encryptedResp, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.ListDirectory(context.Background(), hostId, resourceId, "/photos", ListOptions{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, encryptedResp, resp)
+	})
+
+	t.Run("session request layout", func(t *testing.T) {
+		resourceId := uuid.New()
+		payload := helpers.UUIDToBinary(resourceId)
+		payload = append(payload, helpers.Uint32ToBinary(7)...)
+		payload = append(payload, helpers.Uint16ToBinary(50)...)
+		payload = append(payload, byte(ListSortBySize), listDescendingFlag)
+		payload = append(payload, []byte("img\000/photos\000")...)
+
+		listReq, err := newListRequestDto(payload)
+
+		require.NoError(t, err)
+		assert.Equal(t, resourceId, listReq.resourceUuid)
+		assert.Equal(t, "/photos", listReq.path)
+		assert.Equal(t, ListOptions{Cursor: 7, PageSize: 50, SortKey: ListSortBySize, Descending: true, NameFilter: "img"}, listReq.options)
+	})
+}
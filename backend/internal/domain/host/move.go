@@ -0,0 +1,93 @@
+package host
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/google/uuid"
+)
+
+// ConflictPolicy tells what to do when the destination of a move or copy already exists
+type ConflictPolicy uint8
+
+const (
+	// ConflictFail leaves both resources untouched and fails with DestinationExists
+	ConflictFail ConflictPolicy = 0
+	// ConflictOverwrite replaces the existing destination
+	ConflictOverwrite ConflictPolicy = 1
+	// ConflictRename puts the resource under a free name next to the destination
+	ConflictRename ConflictPolicy = 2
+)
+
+func (p ConflictPolicy) valid() bool {
+	return p <= ConflictRename
+}
+
+// MoveResource moves or renames a resource inside the shared resource. Resources can not be moved between
+// shared resources, so a different destination resource fails with CrossShareMoveNotAllowedErr without
+// asking the host. The host response, ACK or Error, is returned as is.
+func (s *defaultConnectionService) MoveResource(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	sourcePath string,
+	destinationResourceUuid uuid.UUID,
+	destinationPath string,
+	policy ConflictPolicy,
+) ([]byte, error) {
+	if destinationResourceUuid != resourceUuid {
+		return nil, ws_errors.CrossShareMoveNotAllowedErr
+	}
+
+	if destinationPath == "" || !policy.valid() {
+		return nil, ws_errors.MissingOrInvalidRequiredParamsErr
+	}
+
+	hostConn, ok := s.getHostConn(ctx, hostUuid)
+	if !ok {
+		return nil, ws_errors.HostNotFoundErr
+	}
+
+	return hostConn.Query(
+		message_types.MoveResource.Binary(),
+		helpers.UUIDToBinary(resourceUuid),
+		[]byte{byte(policy)},
+		[]byte(helpers.AddNullCharToString(sourcePath)),
+		[]byte(helpers.AddNullCharToString(destinationPath)),
+	)
+}
+
+type transferRequestDto struct {
+	resourceUuid    uuid.UUID
+	policy          ConflictPolicy
+	sourcePath      string
+	destinationPath string
+}
+
+// newTransferRequestDto reads a payload laid out as MoveResource and CopyResource: resource UUID, conflict policy,
+// null terminated source path and null terminated destination path
+func newTransferRequestDto(payload []byte) (transferRequestDto, error) {
+	if len(payload) < uuidSize+1 {
+		return transferRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	resourceUuid, err := uuid.FromBytes(payload[:uuidSize])
+	if err != nil {
+		return transferRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	paths := bytes.SplitN(payload[uuidSize+1:], []byte{0}, 3)
+	if len(paths) < 2 {
+		return transferRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	return transferRequestDto{
+		resourceUuid:    resourceUuid,
+		policy:          ConflictPolicy(payload[uuidSize]),
+		sourcePath:      string(paths[0]),
+		destinationPath: string(paths[1]),
+	}, nil
+}
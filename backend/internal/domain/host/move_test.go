@@ -0,0 +1,99 @@
+package host
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func moveQuery(resourceId uuid.UUID, policy ConflictPolicy, source string, destination string) [][]byte {
+	return [][]byte{
+		message_types.MoveResource.Binary(),
+		helpers.UUIDToBinary(resourceId),
+		{byte(policy)},
+		[]byte(source + "\000"),
+		[]byte(destination + "\000"),
+	}
+}
+
+func TestMoveResource(t *testing.T) {
+	t.Run("success - host response is returned", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", moveQuery(resourceId, ConflictRename, "/a/b.txt", "/c/d.txt")).
+			Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.MoveResource(context.Background(), hostId, resourceId, "/a/b.txt", resourceId, "/c/d.txt", ConflictRename)
+
+		assert.NoError(t, err)
+		assert.Equal(t, message_types.ACK.Binary(), resp)
+	})
+
+	t.Run("error - cross share move", func(t *testing.T) {
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.MoveResource(context.Background(), uuid.New(), uuid.New(), "/a", uuid.New(), "/b", ConflictFail)
+
+		assert.ErrorIs(t, err, ws_errors.CrossShareMoveNotAllowedErr)
+	})
+
+	t.Run("error - invalid params", func(t *testing.T) {
+		resourceId := uuid.New()
+		svc := NewHostService(&hostmap.MockHostMap{}, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+
+		_, err := svc.MoveResource(context.Background(), uuid.New(), resourceId, "/a", resourceId, "", ConflictFail)
+		assert.ErrorIs(t, err, ws_errors.MissingOrInvalidRequiredParamsErr)
+
+		_, err = svc.MoveResource(context.Background(), uuid.New(), resourceId, "/a", resourceId, "/b", ConflictPolicy(7))
+		assert.ErrorIs(t, err, ws_errors.MissingOrInvalidRequiredParamsErr)
+	})
+
+	t.Run("session - move request", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockSession := &clientsession.MockClientSession{}
+		mockStream := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostConn.AssertExpectations(t)
+			mockSession.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		}()
+
+		request := message_types.MoveResource.Binary()
+		request = append(request, helpers.UUIDToBinary(resourceId)...)
+		request = append(request, byte(ConflictOverwrite))
+		request = append(request, []byte("/a\000/b\000")...)
+		mockSession.On("Accept").Return(uint32(1), mockStream, request, nil).Once()
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		hostErrorResp := append(message_types.Error.Binary(), ws_errors.DestinationExists.Binary()...)
+		mockHostConn.On("Query", moveQuery(resourceId, ConflictOverwrite, "/a", "/b")).Return(hostErrorResp, nil).Once()
+
+		mockStream.On("Send", [][]byte{hostErrorResp}).Return(nil).Once()
+		mockStream.On("Close").Return()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	})
+}
@@ -0,0 +1,264 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/bandwidth"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/google/uuid"
+)
+
+var errNegativeSeekPosition = errors.New("negative seek position")
+
+// ResourceReader reads a file shared by a host over a download stream. Every read not served from the last received
+// chunk requests the chunk at the current offset, so seeking is free and the reader can be used with
+// http.ServeContent to serve ranges. The last chunk is kept whole, so seeking back within it, like ServeContent does
+// after sniffing the content type, does not request it again.
+//
+// The reader must be closed to end the download stream on the host.
+type ResourceReader struct {
+	name    string
+	size    int64
+	modTime time.Time
+
+	hostConn hostconn.HostConn
+	streamId uint32
+
+	offset int64
+	// chunk holds the last received chunk, which starts at chunkOffset
+	chunk       []byte
+	chunkOffset int64
+
+	closeOnce     sync.Once
+	service       *defaultConnectionService
+	transfer      *transfer
+	clientLimiter *bandwidth.Limiter
+	// endTransfer is nil until the transfer has been started
+	endTransfer func()
+}
+
+// Name returns the name of the file as reported by the host
+func (r *ResourceReader) Name() string {
+	return r.name
+}
+
+// Size returns the size of the file in bytes
+func (r *ResourceReader) Size() int64 {
+	return r.size
+}
+
+// ModTime returns the modification time of the file, or the zero time if the host does not report it
+func (r *ResourceReader) ModTime() time.Time {
+	return r.modTime
+}
+
+func (r *ResourceReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.offset < r.chunkOffset || r.offset >= r.chunkOffset+int64(len(r.chunk)) {
+		chunk, err := r.queryChunk()
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = chunk
+		r.chunkOffset = r.offset
+	}
+
+	n := copy(p, r.chunk[r.offset-r.chunkOffset:])
+	if err := r.transfer.add(n); err != nil {
+		return 0, err
+	}
+	if err := r.service.waitForBandwidth(r.transfer.ctx, r.transfer.hostUuid, downloadDirection, r.clientLimiter, n); err != nil {
+		return 0, err
+	}
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *ResourceReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+
+	if offset < 0 {
+		return r.offset, errNegativeSeekPosition
+	}
+
+	r.offset = offset
+	return r.offset, nil
+}
+
+// Close ends the download stream on the host
+func (r *ResourceReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		if r.endTransfer != nil {
+			defer r.endTransfer()
+		}
+
+		_, err = r.hostConn.Query(
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(r.streamId),
+		)
+	})
+
+	return err
+}
+
+func (r *ResourceReader) queryChunk() ([]byte, error) {
+	hostResp, err := r.hostConn.QueryWithTimeout(
+		hostconn.DefaultQueryTimeout,
+		hostconn.PriorityBulk,
+		message_types.ChunkRequest.Binary(),
+		helpers.Uint32ToBinary(r.streamId),
+		helpers.Uint64ToBinary(uint64(r.offset)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	hostRespDto, err := newMsgTypeWithPayloadDto(hostResp)
+	if err != nil {
+		return nil, err
+	}
+
+	switch hostRespDto.msgType {
+	case message_types.ChunkResponse:
+		if len(hostRespDto.payload) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return hostRespDto.payload, nil
+	case message_types.EofResponse:
+		return nil, io.ErrUnexpectedEOF
+	case message_types.Error:
+		return nil, newHostError(hostResp)
+	default:
+		return nil, ws_errors.UnexpectedMessageTypeErr
+	}
+}
+
+// OpenResourceReader opens a download stream for a file without a client connection. Directories and encrypted
+// resources can not be read by the relay and fail with ResourceNotDownloadableErr. Errors reported by the host are
+// returned as host errors carrying its error code. Every reader queues its chunk requests in a flow of its own.
+// Reads pass the global, client and host download limits and fail once ctx is done. The download is listed as
+// a transfer of clientIp and cancel is called when it is aborted, both are empty for reads made by the relay itself.
+// While the server is draining, reads for a client fail with ServerShuttingDownErr.
+func (s *defaultConnectionService) OpenResourceReader(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+	clientIp string,
+	cancel func(),
+) (*ResourceReader, error) {
+	if clientIp != "" {
+		if err := s.refuseWhileDraining(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.openResourceReader(ctx, hostUuid, resourceUuid, pathToResource, clientIp, cancel)
+}
+
+// openResourceReader works like OpenResourceReader, but is not refused while the server is draining. It serves
+// the files of archives, which are read as part of an already running download.
+func (s *defaultConnectionService) openResourceReader(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+	clientIp string,
+	cancel func(),
+) (*ResourceReader, error) {
+	hostConn, ok := s.getHostConnOnFlow(ctx, hostUuid, hostconn.NewFlowId())
+	if !ok {
+		return nil, ws_errors.HostNotFoundErr
+	}
+
+	metadata, err := s.queryResourceMetadata(ctx, hostUuid, resourceUuid, pathToResource)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Kind != fileKind {
+		return nil, ws_errors.ResourceNotDownloadableErr
+	}
+
+	downloadInitResp, err := hostConn.Query(
+		message_types.DownloadInitRequest.Binary(),
+		helpers.UUIDToBinary(resourceUuid),
+		[]byte(helpers.AddNullCharToString(pathToResource)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	msgType, err := message_types.GetMsgType(downloadInitResp)
+	if err != nil {
+		return nil, err
+	}
+
+	if msgType == message_types.Error {
+		return nil, newHostError(downloadInitResp)
+	}
+
+	downloadInitRespDto, err := newHostStreamInitResponseDto(downloadInitResp)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &ResourceReader{
+		name:     metadata.Name,
+		size:     metadata.Size,
+		modTime:  metadata.modTime(),
+		hostConn: hostConn,
+		streamId: downloadInitRespDto.streamId,
+		service:  s,
+	}
+
+	if downloadInitRespDto.flags()&encryptedFlag != 0 {
+		_ = reader.Close()
+		return nil, ws_errors.ResourceNotDownloadableErr
+	}
+	reader.transfer, reader.clientLimiter, reader.endTransfer = s.startClientlessTransfer(ctx, hostUuid, downloadDirection, clientIp, cancel)
+
+	return reader, nil
+}
+
+// queryResourceMetadata reads the metadata of a resource for the relay's own use. Errors reported by the host are
+// returned as host errors carrying its error code.
+func (s *defaultConnectionService) queryResourceMetadata(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) (resourceMetadataDto, error) {
+	metadataResp, err := s.queryHostResource(ctx, hostUuid, resourceUuid, pathToResource, message_types.MetadataQuery)
+	if err != nil {
+		return resourceMetadataDto{}, err
+	}
+
+	msgType, err := message_types.GetMsgType(metadataResp)
+	if err != nil {
+		return resourceMetadataDto{}, err
+	}
+
+	if msgType == message_types.Error {
+		return resourceMetadataDto{}, newHostError(metadataResp)
+	}
+
+	return newResourceMetadataDto(metadataResp)
+}
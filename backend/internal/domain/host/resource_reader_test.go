@@ -0,0 +1,247 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metadataQuery(resourceId uuid.UUID) [][]byte {
+	return [][]byte{
+		message_types.MetadataQuery.Binary(),
+		helpers.UUIDToBinary(resourceId),
+		[]byte("aaa\000"),
+	}
+}
+
+func metadataResponse(t *testing.T, flags byte, kind string, size int) []byte {
+	item, err := json.Marshal(map[string]any{"path": "/aaa", "name": "aaa", "kind": kind, "size": size})
+	require.NoError(t, err)
+
+	resp := append(message_types.MetadataResponse.Binary(), flags)
+	return append(resp, item...)
+}
+
+func TestOpenResourceReader(t *testing.T) {
+	t.Run("success - reads and seeks through chunks", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, 0, "file", 6), nil).Once()
+		initResp := append(downloadInitResponse(123, 2), 0)
+		mockHostConn.On("Query", downloadInitQuery(resourceId)).Return(initResp, nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 0)).Return(chunkResponse(1, 2, 3), nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 3)).Return(chunkResponse(4, 5, 6), nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 1)).Return(chunkResponse(2, 3), nil).Once()
+		// The chunk at 3 is no longer the last one once the one at 1 has been received
+		onBulkQuery(mockHostConn, chunkQuery(123, 3)).Return(chunkResponse(4, 5, 6), nil).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		reader, err := svc.OpenResourceReader(context.Background(), hostId, resourceId, "aaa", "", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "aaa", reader.Name())
+		assert.Equal(t, int64(6), reader.Size())
+
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, content)
+
+		// Seeks within the last chunk are served without asking the host again
+		offset, err := reader.Seek(-2, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), offset)
+
+		content, err = io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{5, 6}, content)
+
+		offset, err = reader.Seek(1, io.SeekStart)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), offset)
+
+		content, err = io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{2, 3, 4, 5, 6}, content)
+
+		assert.NoError(t, reader.Close())
+		assert.NoError(t, reader.Close())
+	})
+
+	t.Run("success - content sniffed by http.ServeContent is not requested twice", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, 0, "file", 5), nil).Once()
+		initResp := append(downloadInitResponse(123, 1), 0)
+		mockHostConn.On("Query", downloadInitQuery(resourceId)).Return(initResp, nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 0)).Return(chunkResponse('h', 'e', 'l', 'l', 'o'), nil).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		reader, err := svc.OpenResourceReader(context.Background(), hostId, resourceId, "aaa", "", nil)
+		require.NoError(t, err)
+		defer reader.Close()
+
+		recorder := httptest.NewRecorder()
+		http.ServeContent(recorder, httptest.NewRequest(http.MethodGet, "/", nil), "", reader.ModTime(), reader)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "hello", recorder.Body.String())
+	})
+
+	t.Run("success - download is listed for the client and aborting it cancels the request", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, 0, "file", 6), nil).Once()
+		initResp := append(downloadInitResponse(123, 2), 0)
+		mockHostConn.On("Query", downloadInitQuery(resourceId)).Return(initResp, nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 0)).Return(chunkResponse(1, 2, 3), nil).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		requestCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		reader, err := svc.OpenResourceReader(requestCtx, hostId, resourceId, "aaa", "10.0.0.1", cancel)
+		require.NoError(t, err)
+
+		transfers := svc.ListTransfers()
+		require.Len(t, transfers, 1)
+		assert.Equal(t, "10.0.0.1", transfers[0].ClientIP)
+
+		require.NoError(t, svc.AbortTransfer(transfers[0].Id))
+		assert.ErrorIs(t, requestCtx.Err(), context.Canceled)
+
+		_, err = reader.Read(make([]byte, 6))
+		assert.ErrorIs(t, err, ErrTransferAborted)
+
+		require.NoError(t, reader.Close())
+		assert.Empty(t, svc.ListTransfers())
+	})
+
+	t.Run("error - encrypted metadata", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, encryptedFlag, "file", 6), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.OpenResourceReader(context.Background(), hostId, resourceId, "aaa", "", nil)
+		assert.ErrorIs(t, err, ws_errors.ResourceNotDownloadableErr)
+	})
+
+	t.Run("error - directory", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, 0, "directory", 0), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.OpenResourceReader(context.Background(), hostId, resourceId, "aaa", "", nil)
+		assert.ErrorIs(t, err, ws_errors.ResourceNotDownloadableErr)
+	})
+
+	t.Run("error - reads waiting for bandwidth fail once the request is gone", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, 0, "file", 6), nil).Once()
+		initResp := append(downloadInitResponse(123, 2), 0)
+		mockHostConn.On("Query", downloadInitQuery(resourceId)).Return(initResp, nil).Once()
+		onBulkQuery(mockHostConn, chunkQuery(123, 0)).Return(chunkResponse(1, 2, 3), nil).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		// The chunk is over the global limit of 1 byte per second
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{Global: 1}, testChunkSize)
+		ctx, cancel := context.WithCancel(context.Background())
+		reader, err := svc.OpenResourceReader(ctx, hostId, resourceId, "aaa", "", nil)
+		require.NoError(t, err)
+		defer reader.Close()
+
+		cancel()
+		_, err = reader.Read(make([]byte, 6))
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("error - encrypted download stream is ended", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(metadataResponse(t, 0, "file", 6), nil).Once()
+		initResp := append(downloadInitResponse(123, 2), encryptedFlag)
+		mockHostConn.On("Query", downloadInitQuery(resourceId)).Return(initResp, nil).Once()
+		mockHostConn.On("Query", completionQuery(123)).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.OpenResourceReader(context.Background(), hostId, resourceId, "aaa", "", nil)
+		assert.ErrorIs(t, err, ws_errors.ResourceNotDownloadableErr)
+	})
+
+	t.Run("error - host error is returned with its code", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		hostErrorResp := append(message_types.Error.Binary(), ws_errors.ResourceNotFound.Binary()...)
+		mockHostConn.On("Query", metadataQuery(resourceId)).Return(hostErrorResp, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.OpenResourceReader(context.Background(), hostId, resourceId, "aaa", "", nil)
+
+		var wsErr ws_errors.WebsocketError
+		require.ErrorAs(t, err, &wsErr)
+		assert.Equal(t, ws_errors.ResourceNotFound, wsErr.Code())
+	})
+}
@@ -0,0 +1,147 @@
+package host
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/google/uuid"
+)
+
+// maxDetachedPerClient is how many detached transfers of one client IP are kept at once. A transfer detached beyond
+// it is removed and passed to onExpire straight away.
+const maxDetachedPerClient = 16
+
+// resumeRegistry keeps track of transfers which can be taken over by a new client connection after the previous one
+// has been lost. A transfer is either attached to a client connection or detached and waiting to be resumed.
+// Detached transfers which are not resumed within the grace period are removed and passed to onExpire.
+type resumeRegistry[T any] struct {
+	mu          sync.Mutex
+	entries     map[uuid.UUID]*resumeEntry[T]
+	gracePeriod time.Duration
+	onExpire    func(value T)
+
+	// detached counts the detached transfers of every client IP
+	detached map[string]int
+}
+
+type resumeEntry[T any] struct {
+	value    T
+	clientIp string
+	attached bool
+	expiry   *time.Timer
+	// detachCount identifies the current detachment, so that an expiry timer fired before the transfer was resumed
+	// does not remove it after it has been detached again
+	detachCount uint64
+}
+
+func newResumeRegistry[T any](gracePeriod time.Duration, onExpire func(value T)) *resumeRegistry[T] {
+	return &resumeRegistry[T]{
+		entries:     make(map[uuid.UUID]*resumeEntry[T]),
+		detached:    make(map[string]int),
+		gracePeriod: gracePeriod,
+		onExpire:    onExpire,
+	}
+}
+
+// add registers a new transfer attached to the calling client connection of clientIp and returns its resume token
+func (r *resumeRegistry[T]) add(clientIp string, value T) uuid.UUID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token := uuid.New()
+	r.entries[token] = &resumeEntry[T]{
+		value:    value,
+		clientIp: clientIp,
+		attached: true,
+	}
+
+	return token
+}
+
+// attach takes over a detached transfer. Fails with InvalidResumeTokenErr if there is no such transfer or if it is
+// still attached to another client connection.
+func (r *resumeRegistry[T]) attach(token uuid.UUID) (T, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[token]
+	if !ok || entry.attached {
+		var zero T
+		return zero, ws_errors.InvalidResumeTokenErr
+	}
+
+	entry.expiry.Stop()
+	entry.attached = true
+	r.forgetDetached(entry.clientIp)
+
+	return entry.value, nil
+}
+
+// detach marks the transfer as waiting to be resumed and starts its grace period. If its client IP already has
+// maxDetachedPerClient detached transfers, the transfer is removed and passed to onExpire instead.
+func (r *resumeRegistry[T]) detach(token uuid.UUID) {
+	r.mu.Lock()
+
+	entry, ok := r.entries[token]
+	if !ok || !entry.attached {
+		r.mu.Unlock()
+		return
+	}
+
+	if r.detached[entry.clientIp] >= maxDetachedPerClient {
+		delete(r.entries, token)
+		r.mu.Unlock()
+
+		r.onExpire(entry.value)
+		return
+	}
+	defer r.mu.Unlock()
+
+	r.detached[entry.clientIp]++
+	entry.attached = false
+	entry.detachCount++
+	detachCount := entry.detachCount
+	entry.expiry = time.AfterFunc(r.gracePeriod, func() {
+		r.expire(token, detachCount)
+	})
+}
+
+// remove forgets the transfer, it can no longer be resumed
+func (r *resumeRegistry[T]) remove(token uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[token]
+	if !ok {
+		return
+	}
+
+	if !entry.attached {
+		entry.expiry.Stop()
+		r.forgetDetached(entry.clientIp)
+	}
+	delete(r.entries, token)
+}
+
+func (r *resumeRegistry[T]) expire(token uuid.UUID, detachCount uint64) {
+	r.mu.Lock()
+	entry, ok := r.entries[token]
+	if !ok || entry.attached || entry.detachCount != detachCount {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.entries, token)
+	r.forgetDetached(entry.clientIp)
+	r.mu.Unlock()
+
+	r.onExpire(entry.value)
+}
+
+// forgetDetached stops counting a detached transfer of clientIp, r.mu has to be held
+func (r *resumeRegistry[T]) forgetDetached(clientIp string) {
+	r.detached[clientIp]--
+	if r.detached[clientIp] == 0 {
+		delete(r.detached, clientIp)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/app/config"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/db"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/tracing"
 	"github.com/google/uuid"
 	"golang.org/x/net/context"
 	"time"
@@ -13,6 +15,10 @@ import (
 type SavedConnectionsRepositoryInterface interface {
 	GetById(ctx context.Context, id uuid.UUID) (*SavedConnection, error)
 	AddOrRenew(ctx context.Context, sc SavedConnection) error
+	// UpdateLastSeen stores when the host has last been connected
+	UpdateLastSeen(ctx context.Context, id uuid.UUID, lastSeen time.Time) error
+	// GetLastSeen returns nil if the host has never been seen disconnecting
+	GetLastSeen(ctx context.Context, id uuid.UUID) (*time.Time, error)
 }
 
 type SavedConnectionsRepository struct {
@@ -28,6 +34,10 @@ func NewSavedConnectionsRepository(database db.SqlDatabaseInterface, cfg config.
 }
 
 func (r *SavedConnectionsRepository) GetById(ctx context.Context, id uuid.UUID) (*SavedConnection, error) {
+	defer metrics.ObserveDbQuery("saved_connections.get_by_id", time.Now())
+	ctx, span := tracing.Start(ctx, "saved_connections.get_by_id", tracing.DbSystemSqlite)
+	defer span.End()
+
 	now := time.Now()
 	validTo := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).
 		AddDate(0, 0, r.savedConnectionsConfig.ValidForInDays)
@@ -54,13 +64,17 @@ func (r *SavedConnectionsRepository) GetById(ctx context.Context, id uuid.UUID)
 			return nil, nil
 		}
 
-		return nil, err
+		return nil, tracing.Fail(span, err)
 	}
 
 	return &sc, nil
 }
 
 func (r *SavedConnectionsRepository) AddOrRenew(ctx context.Context, sc SavedConnection) error {
+	defer metrics.ObserveDbQuery("saved_connections.add_or_renew", time.Now())
+	ctx, span := tracing.Start(ctx, "saved_connections.add_or_renew", tracing.DbSystemSqlite)
+	defer span.End()
+
 	query := `
         INSERT INTO saved_connections (id, key_hash, created_at)
         VALUES ($1, $2, CURRENT_TIMESTAMP)
@@ -73,8 +87,52 @@ func (r *SavedConnectionsRepository) AddOrRenew(ctx context.Context, sc SavedCon
 		sc.KeyHash,
 	)
 	if err != nil {
-		return err
+		return tracing.Fail(span, err)
 	}
 
 	return nil
 }
+
+func (r *SavedConnectionsRepository) UpdateLastSeen(ctx context.Context, id uuid.UUID, lastSeen time.Time) error {
+	defer metrics.ObserveDbQuery("saved_connections.update_last_seen", time.Now())
+	ctx, span := tracing.Start(ctx, "saved_connections.update_last_seen", tracing.DbSystemSqlite)
+	defer span.End()
+
+	query := `
+        INSERT INTO host_last_seen (host_id, last_seen_at)
+        VALUES ($1, $2)
+        ON CONFLICT(host_id) DO UPDATE SET
+            last_seen_at = excluded.last_seen_at
+    `
+
+	_, err := r.database.ExecContext(ctx, query, id, lastSeen.UTC())
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+
+	return nil
+}
+
+func (r *SavedConnectionsRepository) GetLastSeen(ctx context.Context, id uuid.UUID) (*time.Time, error) {
+	defer metrics.ObserveDbQuery("saved_connections.get_last_seen", time.Now())
+	ctx, span := tracing.Start(ctx, "saved_connections.get_last_seen", tracing.DbSystemSqlite)
+	defer span.End()
+
+	query := `
+        SELECT last_seen_at
+        FROM host_last_seen
+        WHERE host_id = $1
+    `
+
+	var lastSeen time.Time
+	err := r.database.QueryRowContext(ctx, query, id).Scan(&lastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, tracing.Fail(span, err)
+	}
+
+	return &lastSeen, nil
+}
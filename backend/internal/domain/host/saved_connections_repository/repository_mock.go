@@ -2,6 +2,8 @@ package saved_connections_repository
 
 import (
 	"context"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 )
@@ -22,3 +24,16 @@ func (m *MockSavedConnectionsRepository) AddOrRenew(ctx context.Context, sc Save
 	args := m.Called(ctx, sc)
 	return args.Error(0)
 }
+
+func (m *MockSavedConnectionsRepository) UpdateLastSeen(ctx context.Context, id uuid.UUID, lastSeen time.Time) error {
+	args := m.Called(ctx, id, lastSeen)
+	return args.Error(0)
+}
+
+func (m *MockSavedConnectionsRepository) GetLastSeen(ctx context.Context, id uuid.UUID) (*time.Time, error) {
+	args := m.Called(ctx, id)
+	if lastSeen, ok := args.Get(0).(*time.Time); ok {
+		return lastSeen, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
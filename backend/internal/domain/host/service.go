@@ -1,52 +1,135 @@
 package host
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/bandwidth"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 type HostService interface {
-	InitNewHostConnection(ctx context.Context, ws *websocket.Conn) error
-	InitExistingHostConnection(ctx context.Context, ws *websocket.Conn, hostId uuid.UUID, hostKey string) error
-	GetResourceMetadata(hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) ([]byte, error)
-	DownloadResource(clientConn clientconn.ClientConn, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) error
-	CreateDirectory(hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToDirectory string) ([]byte, error)
-	DeleteResource(hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) ([]byte, error)
-	CreateFile(clientConn clientconn.ClientConn, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToFile string, fileSize uint32) error
+	InitNewHostConnection(ctx context.Context, ws *websocket.Conn, remoteAddr string) error
+	InitExistingHostConnection(ctx context.Context, ws *websocket.Conn, remoteAddr string, hostId uuid.UUID, hostKey string) error
+	GetResourceMetadata(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) ([]byte, error)
+	ListDirectory(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToDirectory string, options ListOptions) ([]byte, error)
+	DownloadResource(ctx context.Context, clientConn clientconn.ClientConn, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) error
+	DownloadResourceResumable(ctx context.Context, clientConn clientconn.ClientConn, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) error
+	ResumeDownload(ctx context.Context, clientConn clientconn.ClientConn, token uuid.UUID) error
+	OpenResourceReader(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string, clientIp string, cancel func()) (*ResourceReader, error)
+	OpenResourceArchive(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string, clientIp string, cancel func()) (*ResourceArchive, error)
+	UploadFile(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToFile string, fileSize uint64, chunkSize int, body io.Reader, clientIp string, cancel func()) error
+	CreateDirectory(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToDirectory string) ([]byte, error)
+	DeleteResource(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) ([]byte, error)
+	MoveResource(
+		ctx context.Context,
+		hostUuid uuid.UUID,
+		resourceUuid uuid.UUID,
+		sourcePath string,
+		destinationResourceUuid uuid.UUID,
+		destinationPath string,
+		policy ConflictPolicy,
+	) ([]byte, error)
+	CopyResource(
+		ctx context.Context,
+		hostUuid uuid.UUID,
+		resourceUuid uuid.UUID,
+		sourcePath string,
+		destinationHostUuid uuid.UUID,
+		destinationResourceUuid uuid.UUID,
+		destinationPath string,
+		policy ConflictPolicy,
+		chunkSize int,
+	) error
+	CreateFile(ctx context.Context, clientConn clientconn.ClientConn, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToFile string, fileSize uint64) error
+	CreateFileResumable(ctx context.Context, clientConn clientconn.ClientConn, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToFile string, fileSize uint64) error
+	ResumeUpload(ctx context.Context, clientConn clientconn.ClientConn, token uuid.UUID) error
+	ServeClientSession(ctx context.Context, session clientsession.ClientSession, hostUuid uuid.UUID) error
+	SearchIndex(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, query string, limit int) ([]file_index_repository.IndexEntry, error)
+	GetHostStatus(ctx context.Context, hostUuid uuid.UUID) (*HostStatus, error)
+	StartDraining()
+	AnnounceShutdown(reconnectAfter time.Duration)
+	WaitForTransfers(ctx context.Context) bool
+	DisconnectAll()
+	StopIndexUpdates(ctx context.Context)
+	ListHosts() []HostInfo
+	DisconnectHost(hostUuid uuid.UUID) error
+	ListTransfers() []TransferInfo
+	AbortTransfer(id uuid.UUID) error
 }
 
 type defaultConnectionService struct {
 	hostMap                    hostmap.HostMap
 	savedConnectionsRepository saved_connections_repository.SavedConnectionsRepositoryInterface
+	fileIndexRepository        file_index_repository.FileIndexRepositoryInterface
+	resumableDownloads         *resumeRegistry[*resumableDownload]
+	resumableUploads           *resumeRegistry[*resumableUpload]
+	transfers                  *transferRegistry
+	indexUpdates               *indexUpdateQueues
+	// draining is set once the server has started to shut down, new transfers are refused from then on
+	draining atomic.Bool
+	// chunkSize is the size of the chunks hosts send downloads in
+	chunkSize uint32
+
+	bandwidthClock bandwidth.Clock
+	globalLimiter  *bandwidth.Limiter
+	clientLimiters *bandwidth.Group
+	hostLimiters   map[uuid.UUID]*hostBandwidthLimiters
+	hostLimitersMu sync.Mutex
 }
 
 func NewHostService(
 	hostMap hostmap.HostMap,
 	savedConnectionsRepository saved_connections_repository.SavedConnectionsRepositoryInterface,
+	fileIndexRepository file_index_repository.FileIndexRepositoryInterface,
+	bandwidthLimits BandwidthLimits,
+	chunkSize int,
 ) HostService {
-	return &defaultConnectionService{
+	s := &defaultConnectionService{
 		hostMap:                    hostMap,
 		savedConnectionsRepository: savedConnectionsRepository,
+		fileIndexRepository:        fileIndexRepository,
+		bandwidthClock:             bandwidthLimits.Clock,
+		globalLimiter:              bandwidth.NewLimiter(bandwidthLimits.Global, bandwidthLimits.Clock),
+		clientLimiters:             bandwidth.NewGroup(bandwidthLimits.PerClient, bandwidthLimits.Clock),
+		hostLimiters:               make(map[uuid.UUID]*hostBandwidthLimiters),
+		transfers:                  newTransferRegistry(),
+		chunkSize:                  uint32(chunkSize),
 	}
+	s.resumableDownloads = newResumeRegistry(downloadResumeGracePeriod, s.expireResumableDownload)
+	s.resumableUploads = newResumeRegistry(uploadResumeGracePeriod, s.expireResumableUpload)
+	s.indexUpdates = newIndexUpdateQueues(s.storeIndexUpdate)
+	hostMap.OnDisconnect(s.handleHostDisconnect)
+
+	return s
 }
 
-func (s *defaultConnectionService) InitNewHostConnection(ctx context.Context, ws *websocket.Conn) error {
+func (s *defaultConnectionService) InitNewHostConnection(ctx context.Context, ws *websocket.Conn, remoteAddr string) error {
 	hostId := s.hostMap.AddNew(ws)
-	hostConn, ok := s.hostMap.Get(hostId)
+	hostConn, ok := s.getHostConn(ctx, hostId)
 	if !ok {
 		return ws_errors.HostNotFoundErr
 	}
+	hostConn.SetRemoteAddr(remoteAddr)
+	hostConn.SetPushHandler(func(msg []byte) {
+		s.handleHostPush(hostId, msg)
+	})
 
 	hostKey := helpers.GetRandomKey()
 	keyHash := helpers.HashString(hostKey)
@@ -61,10 +144,12 @@ func (s *defaultConnectionService) InitNewHostConnection(ctx context.Context, ws
 		return fmt.Errorf("error on quering newly connected host: %w", err)
 	}
 
-	if !bytes.Equal(response, message_types.ACK.Binary()) {
+	capabilities, ok := readInitAck(response)
+	if !ok {
 		hostConn.Close()
 		return fmt.Errorf("unexpected first response from host %s: %q", hostId.String(), response)
 	}
+	hostConn.SetCapabilities(capabilities)
 
 	err = s.savedConnectionsRepository.AddOrRenew(ctx, saved_connections_repository.SavedConnection{
 		Id:      hostId,
@@ -81,6 +166,7 @@ func (s *defaultConnectionService) InitNewHostConnection(ctx context.Context, ws
 func (s *defaultConnectionService) InitExistingHostConnection(
 	ctx context.Context,
 	ws *websocket.Conn,
+	remoteAddr string,
 	hostId uuid.UUID,
 	hostKey string,
 ) error {
@@ -104,10 +190,14 @@ func (s *defaultConnectionService) InitExistingHostConnection(
 		return err
 	}
 
-	hostConn, ok := s.hostMap.Get(hostId)
+	hostConn, ok := s.getHostConn(ctx, hostId)
 	if !ok {
 		return ws_errors.HostNotFoundErr
 	}
+	hostConn.SetRemoteAddr(remoteAddr)
+	hostConn.SetPushHandler(func(msg []byte) {
+		s.handleHostPush(hostId, msg)
+	})
 
 	response, err := hostConn.Query(
 		message_types.InitExistingHost.Binary(),
@@ -117,10 +207,12 @@ func (s *defaultConnectionService) InitExistingHostConnection(
 		return fmt.Errorf("error on quering newly connected host: %w", err)
 	}
 
-	if !bytes.Equal(response, message_types.ACK.Binary()) {
+	capabilities, ok := readInitAck(response)
+	if !ok {
 		hostConn.Close()
 		return fmt.Errorf("unexpected first response from host %s: %q", hostId.String(), response)
 	}
+	hostConn.SetCapabilities(capabilities)
 
 	err = s.savedConnectionsRepository.AddOrRenew(ctx, saved_connections_repository.SavedConnection{
 		Id:      hostId,
@@ -134,43 +226,78 @@ func (s *defaultConnectionService) InitExistingHostConnection(
 	return nil
 }
 
-func (s *defaultConnectionService) GetResourceMetadata(hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) ([]byte, error) {
-	return s.queryHostResource(hostUuid, resourceUuid, pathToResource, message_types.MetadataQuery)
+func (s *defaultConnectionService) GetResourceMetadata(ctx context.Context, hostUuid uuid.UUID, resourceUuid uuid.UUID, pathToResource string) ([]byte, error) {
+	return s.queryHostResource(ctx, hostUuid, resourceUuid, pathToResource, message_types.MetadataQuery)
 
 }
 
 func (s *defaultConnectionService) DownloadResource(
+	ctx context.Context,
 	clientConn clientconn.ClientConn,
 	hostUuid uuid.UUID,
 	resourceUuid uuid.UUID,
 	pathToResource string,
 ) error {
-	hostConn, ok := s.hostMap.Get(hostUuid)
+	return s.downloadResource(ctx, clientConn, hostUuid, hostconn.NewFlowId(), resourceUuid, pathToResource)
+}
+
+// downloadResource works like DownloadResource with the queries to the host queued in the given flow
+func (s *defaultConnectionService) downloadResource(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	flow hostconn.FlowId,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) error {
+	hostConn, ok := s.getHostConnOnFlow(ctx, hostUuid, flow)
 	if !ok {
 		return ws_errors.HostNotFoundErr
 	}
 
+	clientConn, release, err := s.startTransfer(ctx, clientConn, hostUuid, downloadDirection)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	downloadInitRespDto, started, err := s.startDownloadStream(hostConn, clientConn, resourceUuid, pathToResource)
+	if err != nil || !started {
+		return err
+	}
+
+	return s.handleDownloadLoop(hostConn, clientConn, downloadInitRespDto)
+}
+
+// startDownloadStream opens a download stream on the host and sends its DownloadInitResponse to the client.
+// Returns false if the host has refused to open it, its error is then already forwarded to the client.
+func (s *defaultConnectionService) startDownloadStream(
+	hostConn hostconn.HostConn,
+	clientConn clientconn.ClientConn,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) (hostStreamInitResponseDto, bool, error) {
 	downloadInitResp, err := hostConn.Query(
 		message_types.DownloadInitRequest.Binary(),
 		helpers.UUIDToBinary(resourceUuid),
 		[]byte(helpers.AddNullCharToString(pathToResource)),
 	)
 	if err != nil {
-		return err
+		return hostStreamInitResponseDto{}, false, err
 	}
 
 	msgType, err := message_types.GetMsgType(downloadInitResp)
 	if err != nil {
-		return err
+		return hostStreamInitResponseDto{}, false, err
 	}
 
 	if msgType == message_types.Error {
-		return clientConn.Send(downloadInitResp)
+		return hostStreamInitResponseDto{}, false, clientConn.Send(downloadInitResp)
 	}
 
 	downloadInitRespDto, err := newHostStreamInitResponseDto(downloadInitResp)
 	if err != nil {
-		return err
+		return hostStreamInitResponseDto{}, false, err
 	}
 
 	err = clientConn.Send(
@@ -179,87 +306,166 @@ func (s *defaultConnectionService) DownloadResource(
 	)
 	if err != nil {
 		_ = s.sendDownloadCompletionQueryToHost(hostConn, downloadInitRespDto.streamId)
-		return err
+		return hostStreamInitResponseDto{}, false, err
 	}
 
-	return s.handleDownloadLoop(hostConn, clientConn, downloadInitRespDto.streamId)
+	return downloadInitRespDto, true, nil
 }
 
 func (s *defaultConnectionService) CreateDirectory(
+	ctx context.Context,
 	hostUuid uuid.UUID,
 	resourceUuid uuid.UUID,
 	pathToDirectory string,
 ) ([]byte, error) {
-	return s.queryHostResource(hostUuid, resourceUuid, pathToDirectory, message_types.CreateDirectory)
+	return s.queryHostResource(ctx, hostUuid, resourceUuid, pathToDirectory, message_types.CreateDirectory)
 }
 
 func (s *defaultConnectionService) DeleteResource(
+	ctx context.Context,
 	hostUuid uuid.UUID,
 	resourceUuid uuid.UUID,
 	pathToResource string,
 ) ([]byte, error) {
-	return s.queryHostResource(hostUuid, resourceUuid, pathToResource, message_types.DeleteResource)
+	return s.queryHostResource(ctx, hostUuid, resourceUuid, pathToResource, message_types.DeleteResource)
 }
 
 func (s *defaultConnectionService) CreateFile(
+	ctx context.Context,
 	clientConn clientconn.ClientConn,
 	hostUuid uuid.UUID,
 	resourceUuid uuid.UUID,
 	pathToFile string,
-	fileSize uint32,
+	fileSize uint64,
 ) error {
-	hostConn, ok := s.hostMap.Get(hostUuid)
+	return s.createFile(ctx, clientConn, hostUuid, hostconn.NewFlowId(), resourceUuid, pathToFile, fileSize)
+}
+
+// createFile works like CreateFile with the queries to the host queued in the given flow
+func (s *defaultConnectionService) createFile(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	flow hostconn.FlowId,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+) error {
+	hostConn, ok := s.getHostConnOnFlow(ctx, hostUuid, flow)
 	if !ok {
 		return ws_errors.HostNotFoundErr
 	}
 
-	// Request host to prepare for file creation with specified size and path
-	createFileInitResp, err := hostConn.Query(
-		message_types.CreateFileInitRequest.Binary(),
-		helpers.UUIDToBinary(resourceUuid),
-		helpers.Uint32ToBinary(fileSize),
-		[]byte(helpers.AddNullCharToString(pathToFile)),
-	)
+	clientConn, release, err := s.startTransfer(ctx, clientConn, hostUuid, uploadDirection)
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	createFileInitRespDto, started, err := s.startUploadStream(hostConn, clientConn, resourceUuid, pathToFile, fileSize)
+	if err != nil || !started {
+		return err
+	}
+
+	return s.handleUploadLoop(hostConn, clientConn, createFileInitRespDto.streamId)
+}
+
+// startUploadStream opens an upload stream on the host and forwards its CreateFileInitResponse to the client.
+// Returns false if the host has refused to open it, its error is then already forwarded to the client.
+func (s *defaultConnectionService) startUploadStream(
+	hostConn hostconn.HostConn,
+	clientConn clientconn.ClientConn,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+) (hostStreamInitResponseDto, bool, error) {
+	createFileInitQuery, err := newCreateFileInitQuery(hostConn, resourceUuid, pathToFile, fileSize)
+	if err != nil {
+		return hostStreamInitResponseDto{}, false, err
+	}
+
+	// Request host to prepare for file creation with specified size and path
+	createFileInitResp, err := hostConn.Query(createFileInitQuery...)
+	if err != nil {
+		return hostStreamInitResponseDto{}, false, err
+	}
 
 	msgType, err := message_types.GetMsgType(createFileInitResp)
 	if err != nil {
-		return err
+		return hostStreamInitResponseDto{}, false, err
 	}
 
 	// Forward any host errors directly to the client
 	if msgType == message_types.Error {
-		return clientConn.Send(createFileInitResp)
+		return hostStreamInitResponseDto{}, false, clientConn.Send(createFileInitResp)
 	}
 
 	createFileInitRespDto, err := newHostStreamInitResponseDto(createFileInitResp)
 	if err != nil {
-		return err
+		return hostStreamInitResponseDto{}, false, err
 	}
 
 	// Notify client that upload can begin
 	err = clientConn.Send(createFileInitResp)
 	if err != nil {
 		_ = clientConn.Send(message_types.CreateFileStreamEnd.Binary())
-		return err
+		return hostStreamInitResponseDto{}, false, err
 	}
 
-	return s.handleUploadLoop(hostConn, clientConn, createFileInitRespDto.streamId)
+	return createFileInitRespDto, true, nil
+}
+
+// newCreateFileInitQuery builds the query opening an upload stream. Hosts which have announced CapabilityLargeFiles
+// get CreateFileInitRequest64, the others only accept files up to 4 GiB.
+func newCreateFileInitQuery(
+	hostConn hostconn.HostConn,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+) ([][]byte, error) {
+	if hostConn.Capabilities().Has(hostconn.CapabilityLargeFiles) {
+		return [][]byte{
+			message_types.CreateFileInitRequest64.Binary(),
+			helpers.UUIDToBinary(resourceUuid),
+			helpers.Uint64ToBinary(fileSize),
+			[]byte(helpers.AddNullCharToString(pathToFile)),
+		}, nil
+	}
+
+	if fileSize > math.MaxUint32 {
+		return nil, ws_errors.FileTooLargeForHostErr
+	}
+
+	return [][]byte{
+		message_types.CreateFileInitRequest.Binary(),
+		helpers.UUIDToBinary(resourceUuid),
+		helpers.Uint32ToBinary(uint32(fileSize)),
+		[]byte(helpers.AddNullCharToString(pathToFile)),
+	}, nil
 }
 
 func (s *defaultConnectionService) handleDownloadLoop(
 	hostConn hostconn.HostConn,
 	clientConn clientconn.ClientConn,
-	downloadId uint32,
+	downloadInitRespDto hostStreamInitResponseDto,
 ) (err error) {
 	defer func() {
 		if err != nil {
-			_ = s.sendDownloadCompletionQueryToHost(hostConn, downloadId)
+			_ = s.sendDownloadCompletionQueryToHost(hostConn, downloadInitRespDto.streamId)
 		}
 	}()
 
+	return s.serveDownloadRequests(hostConn, clientConn, downloadInitRespDto)
+}
+
+// serveDownloadRequests answers client requests on an open download stream until the client completes the download,
+// in which case nil is returned
+func (s *defaultConnectionService) serveDownloadRequests(
+	hostConn hostconn.HostConn,
+	clientConn clientconn.ClientConn,
+	downloadInitRespDto hostStreamInitResponseDto,
+) error {
+	downloadId := downloadInitRespDto.streamId
 	for {
 		clientRequest, err := clientConn.Listen()
 		if err != nil {
@@ -279,6 +485,11 @@ func (s *defaultConnectionService) handleDownloadLoop(
 			if err != nil {
 				return err
 			}
+		case message_types.DownloadWindowRequest:
+			err = s.handleDownloadWindowRequest(hostConn, clientConn, downloadInitRespDto, chunkReqDto)
+			if err != nil {
+				return err
+			}
 		default:
 			return ws_errors.UnexpectedMessageTypeErr
 		}
@@ -297,14 +508,17 @@ func (s *defaultConnectionService) handleUploadLoop(
 		}
 	}()
 
+	var hostResp []byte
 	for {
-		// Query host for new chunk request
-		hostResp, err := hostConn.Query(
-			message_types.CreateFileHostChunkRequest.Binary(),
-			helpers.Uint32ToBinary(streamId),
-		)
-		if err != nil {
-			return err
+		// Query host for new chunk request, unless the host has already sent it as the acknowledgement of the last batch
+		if hostResp == nil {
+			hostResp, err = hostConn.Query(
+				message_types.CreateFileHostChunkRequest.Binary(),
+				helpers.Uint32ToBinary(streamId),
+			)
+			if err != nil {
+				return err
+			}
 		}
 
 		hostChunkReqMsgType, err := message_types.GetMsgType(hostResp)
@@ -317,36 +531,71 @@ func (s *defaultConnectionService) handleUploadLoop(
 			return clientConn.Send(hostResp)
 		}
 
+		credits := uint16(1)
+		if hostChunkReqMsgType == message_types.CreateFileCreditGrant {
+			credits, err = newCreditGrantDto(hostResp)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Forward chunk request to the client
 		err = clientConn.Send(hostResp)
 		if err != nil {
 			return err
 		}
 
-		// Wait for the client to send chunk data
-		clientChunkResp, err := clientConn.Listen()
+		// Forward the granted chunks to the host, only the last one of them is acknowledged
+		hostChunkProcessingResp, err := s.forwardUploadChunks(hostConn, clientConn, credits)
 		if err != nil {
 			return err
 		}
 
-		// Forward chunk data to host for processing
-		hostChunkProcessingResp, err := hostConn.Query(clientChunkResp)
+		hostChunkProcessingRespMsgType, err := message_types.GetMsgType(hostChunkProcessingResp)
 		if err != nil {
 			return err
 		}
 
-		hostChunkProcessingRespMsgType, err := message_types.GetMsgType(hostChunkProcessingResp)
+		switch hostChunkProcessingRespMsgType {
+		case message_types.Error, message_types.CreateFileStreamEnd:
+			// Host signals completion or error after processing chunk
+			return clientConn.Send(hostChunkProcessingResp)
+		case message_types.CreateFileChunkRequest, message_types.CreateFileCreditGrant:
+			// Host acknowledged the batch with the next request
+			hostResp = hostChunkProcessingResp
+		default:
+			// Host acknowledged chunk successfully - continue to next iteration
+			hostResp = nil
+		}
+	}
+}
+
+// forwardUploadChunks forwards the given number of client chunks to the host and returns the host response to the last one
+func (s *defaultConnectionService) forwardUploadChunks(
+	hostConn hostconn.HostConn,
+	clientConn clientconn.ClientConn,
+	credits uint16,
+) ([]byte, error) {
+	for i := uint16(1); i < credits; i++ {
+		// Wait for the client to send chunk data
+		clientChunkResp, err := clientConn.Listen()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		// Host signals completion or error after processing chunk
-		if hostChunkProcessingRespMsgType == message_types.Error || hostChunkProcessingRespMsgType == message_types.CreateFileStreamEnd {
-			return clientConn.Send(hostChunkProcessingResp)
+		if err = hostConn.Send(clientChunkResp); err != nil {
+			return nil, err
 		}
+	}
 
-		// Host acknowledged chunk successfully - continue to next iteration
+	// Wait for the client to send chunk data
+	clientChunkResp, err := clientConn.Listen()
+	if err != nil {
+		return nil, err
 	}
+
+	// Forward chunk data to host for processing
+	return hostConn.QueryWithTimeout(hostconn.DefaultQueryTimeout, hostconn.PriorityBulk, clientChunkResp)
 }
 
 func (s *defaultConnectionService) sendDownloadCompletionQueryToHost(hostConn hostconn.HostConn, downloadId uint32) error {
@@ -363,7 +612,9 @@ func (s *defaultConnectionService) handleChunkRequest(
 	downloadId uint32,
 	chunkReqDto msgTypeWithPayload,
 ) error {
-	hostResp, err := hostConn.Query(
+	hostResp, err := hostConn.QueryWithTimeout(
+		hostconn.DefaultQueryTimeout,
+		hostconn.PriorityBulk,
 		message_types.ChunkRequest.Binary(),
 		helpers.Uint32ToBinary(downloadId),
 		chunkReqDto.payload,
@@ -376,12 +627,13 @@ func (s *defaultConnectionService) handleChunkRequest(
 }
 
 func (s *defaultConnectionService) queryHostResource(
+	ctx context.Context,
 	hostUuid uuid.UUID,
 	resourceUuid uuid.UUID,
 	path string,
 	msgType message_types.WebsocketMessageType,
 ) ([]byte, error) {
-	hostConn, ok := s.hostMap.Get(hostUuid)
+	hostConn, ok := s.getHostConn(ctx, hostUuid)
 	if !ok {
 		return nil, ws_errors.HostNotFoundErr
 	}
@@ -397,3 +649,40 @@ func (s *defaultConnectionService) queryHostResource(
 
 	return resp, nil
 }
+
+// getHostConn returns the connection of the host as a view logging and tracing its queries with the logger and
+// the span of the request in ctx
+func (s *defaultConnectionService) getHostConn(ctx context.Context, hostUuid uuid.UUID) (hostconn.HostConn, bool) {
+	hostConn, ok := s.hostMap.Get(hostUuid)
+	if !ok {
+		return nil, false
+	}
+
+	return hostConn.WithContext(hostContext(ctx, hostUuid)), true
+}
+
+// getHostConnOnFlow works like getHostConn with the queries of the view queued in the given flow
+func (s *defaultConnectionService) getHostConnOnFlow(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	flow hostconn.FlowId,
+) (hostconn.HostConn, bool) {
+	hostConn, ok := s.hostMap.Get(hostUuid)
+	if !ok {
+		return nil, false
+	}
+
+	return flowView(ctx, hostConn, hostUuid, flow), true
+}
+
+// flowView returns a view of the connection of the host queuing its queries in the given flow and logging and tracing
+// them with the logger and the span of the request in ctx. Connections kept for resumable transfers are compared as they are, so views are
+// made of them only when they are queried.
+func flowView(ctx context.Context, hostConn hostconn.HostConn, hostUuid uuid.UUID, flow hostconn.FlowId) hostconn.HostConn {
+	return hostConn.Flow(flow).WithContext(hostContext(ctx, hostUuid))
+}
+
+// hostContext returns a copy of ctx whose logger carries the ID of the host the request is served by
+func hostContext(ctx context.Context, hostUuid uuid.UUID) context.Context {
+	return logging.WithLogger(ctx, logging.FromContext(ctx).With(logging.HostIdKey, hostUuid))
+}
@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
@@ -32,8 +34,8 @@ func TestInitialiseNewHostConnection(t *testing.T) {
 		mockConn.On("Query", mock.Anything).Return(message_types.ACK.Binary(), nil)
 		mockSavedConnectionsRepo.On("AddOrRenew", mock.Anything, mock.Anything).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitNewHostConnection(context.Background(), mockWs)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitNewHostConnection(context.Background(), mockWs, "127.0.0.1")
 
 		assert.NoError(t, err)
 		mockHostMap.AssertExpectations(t)
@@ -41,6 +43,29 @@ func TestInitialiseNewHostConnection(t *testing.T) {
 		mockSavedConnectionsRepo.AssertExpectations(t)
 	})
 
+	t.Run("success: host replies OK with capabilities", func(t *testing.T) {
+		id := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockConn := &hostconn.MockConn{}
+		mockWs := &websocket.Conn{}
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+
+		mockHostMap.On("AddNew", mockWs).Return(id)
+		mockHostMap.On("Get", id).Return(mockConn, true)
+
+		ack := append(message_types.ACK.Binary(), helpers.Uint32ToBinary(uint32(hostconn.CapabilityLargeFiles))...)
+		mockConn.On("Query", mock.Anything).Return(ack, nil)
+		mockSavedConnectionsRepo.On("AddOrRenew", mock.Anything, mock.Anything).Return(nil)
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitNewHostConnection(context.Background(), mockWs, "127.0.0.1")
+
+		assert.NoError(t, err)
+		assert.True(t, mockConn.HostCapabilities.Has(hostconn.CapabilityLargeFiles))
+		mockHostMap.AssertExpectations(t)
+		mockConn.AssertExpectations(t)
+	})
+
 	t.Run("error: host not found", func(t *testing.T) {
 		id := uuid.New()
 		mockHostMap := &hostmap.MockHostMap{}
@@ -50,8 +75,8 @@ func TestInitialiseNewHostConnection(t *testing.T) {
 		mockHostMap.On("AddNew", mockWs).Return(id)
 		mockHostMap.On("Get", id).Return(nil, false)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitNewHostConnection(context.Background(), mockWs)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitNewHostConnection(context.Background(), mockWs, "127.0.0.1")
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "host not found error")
@@ -73,8 +98,8 @@ func TestInitialiseNewHostConnection(t *testing.T) {
 		mockConn.On("Query", mock.Anything).Return(nil, queryErr)
 		mockConn.On("Close").Return()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitNewHostConnection(context.Background(), mockWs)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitNewHostConnection(context.Background(), mockWs, "127.0.0.1")
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "error on quering newly connected host")
@@ -98,8 +123,8 @@ func TestInitialiseNewHostConnection(t *testing.T) {
 
 		mockConn.On("Query", mock.Anything).Return([]byte("NO"), nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitNewHostConnection(context.Background(), mockWs)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitNewHostConnection(context.Background(), mockWs, "127.0.0.1")
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "unexpected first response from host")
@@ -122,8 +147,8 @@ func TestInitialiseNewHostConnection(t *testing.T) {
 		mockConn.On("Query", mock.Anything).Return(message_types.ACK.Binary(), nil)
 		mockSavedConnectionsRepo.On("AddOrRenew", mock.Anything, mock.Anything).Return(errors.New("test error"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitNewHostConnection(context.Background(), mockWs)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitNewHostConnection(context.Background(), mockWs, "127.0.0.1")
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "test error")
@@ -151,8 +176,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 
 		mockHostMap.On("Get", hostId).Return(mockConn, true)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "already connected")
@@ -175,8 +200,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockHostMap.On("Get", hostId).Return(nil, false)
 		mockSavedConnectionsRepo.On("GetById", mock.Anything, hostId).Return(nil, errors.New("test error"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "test error")
@@ -203,8 +228,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockHostMap.On("Get", hostId).Return(nil, false)
 		mockSavedConnectionsRepo.On("GetById", mock.Anything, hostId).Return(&savedConnection, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "invalid host key error")
@@ -232,8 +257,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockSavedConnectionsRepo.On("GetById", mock.Anything, hostId).Return(&savedConnection, nil)
 		mockHostMap.On("Add", mockWs, hostId).Return(errors.New("test error"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "test error")
@@ -262,8 +287,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockHostMap.On("Add", mockWs, hostId).Return(nil).Once()
 		mockHostMap.On("Get", hostId).Return(nil, false).Once()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "host not found error")
@@ -294,8 +319,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockConn.On("Query", mock.Anything).Return([]byte{66}, errors.New("test error")).Once()
 		mockConn.On("Close").Return()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "test error")
@@ -326,8 +351,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockConn.On("Query", mock.Anything).Return([]byte{66}, nil)
 		mockConn.On("Close").Return()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "unexpected first response from host")
@@ -359,8 +384,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockSavedConnectionsRepo.On("AddOrRenew", mock.Anything, mock.Anything).Return(errors.New("test error")).Once()
 		mockConn.On("Close").Return().Once()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "test error")
@@ -391,8 +416,8 @@ func TestInitExistingHostConnection(t *testing.T) {
 		mockConn.On("Query", mock.Anything).Return(message_types.ACK.Binary(), nil).Once()
 		mockSavedConnectionsRepo.On("AddOrRenew", mock.Anything, mock.Anything).Return(nil).Once()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.InitExistingHostConnection(context.Background(), mockWs, hostId, hostKey)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.InitExistingHostConnection(context.Background(), mockWs, "127.0.0.1", hostId, hostKey)
 
 		assert.NoError(t, err)
 	})
@@ -414,8 +439,8 @@ func TestGetResourceMetadata(t *testing.T) {
 
 		expectedResponse := message_types.ACK.Binary()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		resp, err := svc.GetResourceMetadata(hostId, resourceId, "abc/cba")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.GetResourceMetadata(context.Background(), hostId, resourceId, "abc/cba")
 
 		assert.NoError(t, err)
 		assert.Equal(t, expectedResponse, resp)
@@ -434,8 +459,8 @@ func TestGetResourceMetadata(t *testing.T) {
 
 		mockHostMap.On("Get", hostId).Return(nil, false)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		resp, err := svc.GetResourceMetadata(hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.GetResourceMetadata(context.Background(), hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "host not found error", err.Error())
@@ -458,8 +483,8 @@ func TestGetResourceMetadata(t *testing.T) {
 		expectedQuery := [][]byte{message_types.MetadataQuery.Binary(), helpers.UUIDToBinary(resourceId), []byte("bbb\000")}
 		mockConn.On("Query", expectedQuery).Return(nil, errors.New("test error"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		resp, err := svc.GetResourceMetadata(hostId, resourceId, "bbb")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.GetResourceMetadata(context.Background(), hostId, resourceId, "bbb")
 
 		require.Error(t, err)
 		assert.Equal(t, "test error", err.Error())
@@ -487,8 +512,8 @@ func TestDownloadResource(t *testing.T) {
 
 		mockHostMap.On("Get", hostId).Return(nil, false)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "host not found error", err.Error())
@@ -517,8 +542,8 @@ func TestDownloadResource(t *testing.T) {
 		}
 		mockHostConn.On("Query", expectedDownloadInitQuery).Return(nil, errors.New("test error"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "test error", err.Error())
@@ -548,8 +573,8 @@ func TestDownloadResource(t *testing.T) {
 		downloadInitResponse := []byte{1}
 		mockHostConn.On("Query", expectedDownloadInitQuery).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "invalid message body error", err.Error())
@@ -581,8 +606,8 @@ func TestDownloadResource(t *testing.T) {
 
 		mockClientConn.On("Send", [][]byte{message_types.Error.Binary()}).Return(errors.New("some error from send client"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "some error from send client", err.Error())
@@ -612,8 +637,8 @@ func TestDownloadResource(t *testing.T) {
 		downloadInitResponse := message_types.DownloadInitResponse.Binary()
 		mockHostConn.On("Query", expectedDownloadInitQuery).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "invalid message body error", err.Error())
@@ -656,8 +681,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "some error from send client", err.Error())
@@ -702,8 +727,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "some client listen error", err.Error())
@@ -748,8 +773,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "invalid message body error", err.Error())
@@ -794,8 +819,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "unexpected message type error", err.Error())
@@ -836,7 +861,7 @@ func TestDownloadResource(t *testing.T) {
 		clientListenResp = append(clientListenResp, 1) // some payload
 		mockClientConn.On("Listen").Return(clientListenResp, nil)
 
-		mockHostConn.On("Query", [][]byte{
+		onBulkQuery(mockHostConn, [][]byte{
 			message_types.ChunkRequest.Binary(),
 			helpers.Uint32ToBinary(888),
 			{1},
@@ -847,8 +872,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "chunk request host error", err.Error())
@@ -891,7 +916,7 @@ func TestDownloadResource(t *testing.T) {
 		clientListenResp = append(clientListenResp, 1) // some payload
 		mockClientConn.On("Listen").Return(clientListenResp, nil)
 
-		mockHostConn.On("Query", [][]byte{
+		onBulkQuery(mockHostConn, [][]byte{
 			message_types.ChunkRequest.Binary(),
 			helpers.Uint32ToBinary(888),
 			{1},
@@ -906,8 +931,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(downloadInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "client send chunk response error", err.Error())
@@ -950,7 +975,7 @@ func TestDownloadResource(t *testing.T) {
 		clientListenResp = append(clientListenResp, 1) // some payload
 		mockClientConn.On("Listen").Return(clientListenResp, nil).Once()
 
-		mockHostConn.On("Query", [][]byte{
+		onBulkQuery(mockHostConn, [][]byte{
 			message_types.ChunkRequest.Binary(),
 			helpers.Uint32ToBinary(888),
 			{1},
@@ -967,8 +992,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(nil, errors.New("downloadCompletionQuerySendError"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		require.Error(t, err)
 		assert.Equal(t, "downloadCompletionQuerySendError", err.Error())
@@ -1011,7 +1036,7 @@ func TestDownloadResource(t *testing.T) {
 		clientListenResp = append(clientListenResp, 1) // some payload
 		mockClientConn.On("Listen").Return(clientListenResp, nil).Once()
 
-		mockHostConn.On("Query", [][]byte{
+		onBulkQuery(mockHostConn, [][]byte{
 			message_types.ChunkRequest.Binary(),
 			helpers.Uint32ToBinary(888),
 			{1},
@@ -1028,8 +1053,8 @@ func TestDownloadResource(t *testing.T) {
 			helpers.Uint32ToBinary(888),
 		}).Return(nil, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.DownloadResource(mockClientConn, hostId, resourceId, "aaa")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.DownloadResource(context.Background(), mockClientConn, hostId, resourceId, "aaa")
 
 		assert.NoError(t, err)
 	})
@@ -1060,8 +1085,8 @@ func TestCreateDirectory(t *testing.T) {
 
 		expectedResponse := message_types.ACK.Binary()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		resp, err := svc.CreateDirectory(hostId, resourceId, "path/to/dir")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.CreateDirectory(context.Background(), hostId, resourceId, "path/to/dir")
 
 		assert.NoError(t, err)
 		assert.Equal(t, expectedResponse, resp)
@@ -1081,8 +1106,8 @@ func TestCreateDirectory(t *testing.T) {
 
 		mockHostMap.On("Get", hostId).Return(nil, false)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		resp, err := svc.CreateDirectory(hostId, resourceId, "some/path")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.CreateDirectory(context.Background(), hostId, resourceId, "some/path")
 
 		require.Error(t, err)
 		assert.Equal(t, "host not found error", err.Error())
@@ -1110,8 +1135,8 @@ func TestCreateDirectory(t *testing.T) {
 		}
 		mockConn.On("Query", expectedQuery).Return(nil, errors.New("test error"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		resp, err := svc.CreateDirectory(hostId, resourceId, "another/path")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.CreateDirectory(context.Background(), hostId, resourceId, "another/path")
 
 		require.Error(t, err)
 		assert.Equal(t, "test error", err.Error())
@@ -1144,8 +1169,8 @@ func TestDeleteDirectory(t *testing.T) {
 
 		expectedResponse := message_types.ACK.Binary()
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		resp, err := svc.DeleteResource(hostId, resourceId, "path/to/dir")
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		resp, err := svc.DeleteResource(context.Background(), hostId, resourceId, "path/to/dir")
 
 		assert.NoError(t, err)
 		assert.Equal(t, expectedResponse, resp)
@@ -1169,8 +1194,8 @@ func TestCreateFile(t *testing.T) {
 
 		mockHostMap.On("Get", hostId).Return(nil, false)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "host not found error", err.Error())
@@ -1200,8 +1225,8 @@ func TestCreateFile(t *testing.T) {
 		}
 		mockHostConn.On("Query", expectedCreateFileInitQuery).Return(nil, errors.New("test error"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "test error", err.Error())
@@ -1232,8 +1257,8 @@ func TestCreateFile(t *testing.T) {
 		createFileInitResponse := []byte{1}
 		mockHostConn.On("Query", expectedCreateFileInitQuery).Return(createFileInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "invalid message body error", err.Error())
@@ -1266,8 +1291,8 @@ func TestCreateFile(t *testing.T) {
 
 		mockClientConn.On("Send", [][]byte{message_types.Error.Binary()}).Return(errors.New("some error from send client"))
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "some error from send client", err.Error())
@@ -1298,8 +1323,8 @@ func TestCreateFile(t *testing.T) {
 		createFileInitResponse := message_types.CreateFileInitResponse.Binary()
 		mockHostConn.On("Query", expectedCreateFileInitQuery).Return(createFileInitResponse, nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "invalid message body error", err.Error())
@@ -1334,8 +1359,8 @@ func TestCreateFile(t *testing.T) {
 		mockClientConn.On("Send", [][]byte{createFileInitResponse}).Return(errors.New("some error from send client"))
 		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "some error from send client", err.Error())
@@ -1376,8 +1401,8 @@ func TestCreateFile(t *testing.T) {
 
 		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "host chunk request error", err.Error())
@@ -1418,8 +1443,8 @@ func TestCreateFile(t *testing.T) {
 
 		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "invalid message body error", err.Error())
@@ -1461,8 +1486,8 @@ func TestCreateFile(t *testing.T) {
 
 		mockClientConn.On("Send", [][]byte{hostErrorResp}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		assert.NoError(t, err)
 	})
@@ -1503,8 +1528,8 @@ func TestCreateFile(t *testing.T) {
 
 		mockClientConn.On("Send", [][]byte{hostCompletionResp}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		assert.NoError(t, err)
 	})
@@ -1547,8 +1572,8 @@ func TestCreateFile(t *testing.T) {
 		mockClientConn.On("Send", [][]byte{hostChunkReq}).Return(errors.New("client send error"))
 		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "client send error", err.Error())
@@ -1593,8 +1618,8 @@ func TestCreateFile(t *testing.T) {
 		mockClientConn.On("Listen").Return(nil, errors.New("client listen error"))
 		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "client listen error", err.Error())
@@ -1638,11 +1663,11 @@ func TestCreateFile(t *testing.T) {
 		clientChunkData := []byte{4, 5, 6}
 		mockClientConn.On("Send", [][]byte{hostChunkReq}).Return(nil)
 		mockClientConn.On("Listen").Return(clientChunkData, nil)
-		mockHostConn.On("Query", [][]byte{clientChunkData}).Return(nil, errors.New("host query chunk error"))
+		onBulkQuery(mockHostConn, [][]byte{clientChunkData}).Return(nil, errors.New("host query chunk error"))
 		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "host query chunk error", err.Error())
@@ -1686,11 +1711,11 @@ func TestCreateFile(t *testing.T) {
 		clientChunkData := []byte{4, 5, 6}
 		mockClientConn.On("Send", [][]byte{hostChunkReq}).Return(nil)
 		mockClientConn.On("Listen").Return(clientChunkData, nil)
-		mockHostConn.On("Query", [][]byte{clientChunkData}).Return([]byte{1}, nil)
+		onBulkQuery(mockHostConn, [][]byte{clientChunkData}).Return([]byte{1}, nil)
 		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		require.Error(t, err)
 		assert.Equal(t, "invalid message body error", err.Error())
@@ -1735,11 +1760,11 @@ func TestCreateFile(t *testing.T) {
 		hostErrorResp := message_types.Error.Binary()
 		mockClientConn.On("Send", [][]byte{hostChunkReq}).Return(nil)
 		mockClientConn.On("Listen").Return(clientChunkData, nil)
-		mockHostConn.On("Query", [][]byte{clientChunkData}).Return(hostErrorResp, nil)
+		onBulkQuery(mockHostConn, [][]byte{clientChunkData}).Return(hostErrorResp, nil)
 		mockClientConn.On("Send", [][]byte{hostErrorResp}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		assert.NoError(t, err)
 	})
@@ -1783,11 +1808,11 @@ func TestCreateFile(t *testing.T) {
 		hostCompletionResp := message_types.CreateFileStreamEnd.Binary()
 		mockClientConn.On("Send", [][]byte{hostChunkReq}).Return(nil)
 		mockClientConn.On("Listen").Return(clientChunkData, nil)
-		mockHostConn.On("Query", [][]byte{clientChunkData}).Return(hostCompletionResp, nil)
+		onBulkQuery(mockHostConn, [][]byte{clientChunkData}).Return(hostCompletionResp, nil)
 		mockClientConn.On("Send", [][]byte{hostCompletionResp}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		assert.NoError(t, err)
 	})
@@ -1832,7 +1857,7 @@ func TestCreateFile(t *testing.T) {
 		hostAckResp1 := message_types.ACK.Binary()
 		mockClientConn.On("Send", [][]byte{hostChunkReq1}).Return(nil).Once()
 		mockClientConn.On("Listen").Return(clientChunkData1, nil).Once()
-		mockHostConn.On("Query", [][]byte{clientChunkData1}).Return(hostAckResp1, nil).Once()
+		onBulkQuery(mockHostConn, [][]byte{clientChunkData1}).Return(hostAckResp1, nil).Once()
 
 		// Second chunk
 		hostChunkReq2 := message_types.CreateFileHostChunkRequest.Binary()
@@ -1846,7 +1871,7 @@ func TestCreateFile(t *testing.T) {
 		hostAckResp2 := message_types.ACK.Binary()
 		mockClientConn.On("Send", [][]byte{hostChunkReq2}).Return(nil).Once()
 		mockClientConn.On("Listen").Return(clientChunkData2, nil).Once()
-		mockHostConn.On("Query", [][]byte{clientChunkData2}).Return(hostAckResp2, nil).Once()
+		onBulkQuery(mockHostConn, [][]byte{clientChunkData2}).Return(hostAckResp2, nil).Once()
 
 		// Completion
 		hostCompletionResp := message_types.CreateFileStreamEnd.Binary()
@@ -1856,9 +1881,116 @@ func TestCreateFile(t *testing.T) {
 		}).Return(hostCompletionResp, nil).Once()
 		mockClientConn.On("Send", [][]byte{hostCompletionResp}).Return(nil)
 
-		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo)
-		err := svc.CreateFile(mockClientConn, hostId, resourceId, "test.txt", 1024)
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
 
 		assert.NoError(t, err)
 	})
+
+	t.Run("success - upload with credit grants", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+			mockSavedConnectionsRepo.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		createFileInitResponse := message_types.CreateFileInitResponse.Binary()
+		createFileInitResponse = append(createFileInitResponse, helpers.Uint32ToBinary(777)...) // streamId
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileInitRequest.Binary(),
+			helpers.UUIDToBinary(resourceId),
+			helpers.Uint32ToBinary(1024),
+			[]byte("test.txt\000"),
+		}).Return(createFileInitResponse, nil)
+		mockClientConn.On("Send", [][]byte{createFileInitResponse}).Return(nil).Once()
+
+		// First batch - 3 credits, the last chunk is acknowledged with the next grant
+		grant1 := message_types.CreateFileCreditGrant.Binary()
+		grant1 = append(grant1, helpers.Uint16ToBinary(3)...)
+		grant1 = append(grant1, helpers.Uint64ToBinary(0)...)
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileHostChunkRequest.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(grant1, nil).Once()
+		mockClientConn.On("Send", [][]byte{grant1}).Return(nil).Once()
+
+		chunk1, chunk2, chunk3 := []byte{1}, []byte{2}, []byte{3}
+		mockClientConn.On("Listen").Return(chunk1, nil).Once()
+		mockClientConn.On("Listen").Return(chunk2, nil).Once()
+		mockClientConn.On("Listen").Return(chunk3, nil).Once()
+		mockHostConn.On("Send", [][]byte{chunk1}).Return(nil).Once()
+		mockHostConn.On("Send", [][]byte{chunk2}).Return(nil).Once()
+
+		grant2 := message_types.CreateFileCreditGrant.Binary()
+		grant2 = append(grant2, helpers.Uint16ToBinary(1)...)
+		grant2 = append(grant2, helpers.Uint64ToBinary(3)...)
+		onBulkQuery(mockHostConn, [][]byte{chunk3}).Return(grant2, nil).Once()
+
+		// Second batch - 1 credit, acknowledged with completion
+		mockClientConn.On("Send", [][]byte{grant2}).Return(nil).Once()
+		chunk4 := []byte{4}
+		mockClientConn.On("Listen").Return(chunk4, nil).Once()
+		hostCompletionResp := message_types.CreateFileStreamEnd.Binary()
+		onBulkQuery(mockHostConn, [][]byte{chunk4}).Return(hostCompletionResp, nil).Once()
+		mockClientConn.On("Send", [][]byte{hostCompletionResp}).Return(nil).Once()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("error - upload with invalid credit grant", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+			mockSavedConnectionsRepo.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		createFileInitResponse := message_types.CreateFileInitResponse.Binary()
+		createFileInitResponse = append(createFileInitResponse, helpers.Uint32ToBinary(777)...) // streamId
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileInitRequest.Binary(),
+			helpers.UUIDToBinary(resourceId),
+			helpers.Uint32ToBinary(1024),
+			[]byte("test.txt\000"),
+		}).Return(createFileInitResponse, nil)
+		mockClientConn.On("Send", [][]byte{createFileInitResponse}).Return(nil).Once()
+
+		grant := message_types.CreateFileCreditGrant.Binary()
+		grant = append(grant, helpers.Uint16ToBinary(maxUploadCredits+1)...)
+		grant = append(grant, helpers.Uint64ToBinary(0)...)
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileHostChunkRequest.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(grant, nil).Once()
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileStreamEnd.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(message_types.ACK.Binary(), nil).Maybe()
+		mockClientConn.On("Send", mock.Anything).Return(nil).Maybe()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.CreateFile(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
+
+		assert.ErrorIs(t, err, ws_errors.InvalidMessageBodyErr)
+	})
 }
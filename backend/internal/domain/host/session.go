@@ -0,0 +1,181 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ServeClientSession accepts operations opened by the client on the session and runs each of them concurrently
+// against the given host. It returns once the session has been closed and all started operations have finished.
+//
+// The message opening an operation has the same layout as the query the host receives for it:
+//   - MetadataQuery, CreateDirectory, DeleteResource and DownloadInitRequest: resource UUID and null terminated path
+//   - CreateFileInitRequest: resource UUID, file size (uint32) and null terminated path
+//   - CreateFileInitRequest64: resource UUID, file size (uint64) and null terminated path
+//   - ListDirectoryQuery: resource UUID, cursor (uint32), page size (uint16), sort key (uint8), flags (uint8),
+//     null terminated name filter and null terminated path
+//   - MoveResource and CopyResource: resource UUID, conflict policy (uint8), null terminated source and destination paths
+//
+// All following messages of the operation are the same as on the dedicated endpoints.
+//
+// Downloads and uploads of the session share one flow on the host connection, so a session streaming many files
+// at once gets the same share of the host as a session streaming one.
+func (s *defaultConnectionService) ServeClientSession(ctx context.Context, session clientsession.ClientSession, hostUuid uuid.UUID) error {
+	flow := hostconn.NewFlowId()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		streamId, stream, initMessage, err := session.Accept()
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer stream.Close()
+
+			// Operations of the session are told apart by the request ID the client has opened them with
+			streamCtx := logging.WithLogger(ctx, logging.FromContext(ctx).With("session_request_id", streamId))
+			streamCtx, span := tracing.Start(streamCtx, "ClientSession.Request",
+				attribute.Int64("session_request_id", int64(streamId)),
+			)
+			err := s.handleSessionRequest(streamCtx, stream, hostUuid, flow, initMessage)
+			if err != nil && !errors.Is(err, ws_errors.ConnectionClosedErr) {
+				sendErrorToClient(streamCtx, stream, err)
+			}
+			tracing.End(span, err)
+		}()
+	}
+}
+
+func (s *defaultConnectionService) handleSessionRequest(
+	ctx context.Context,
+	stream clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	flow hostconn.FlowId,
+	initMessage []byte,
+) error {
+	request, err := newMsgTypeWithPayloadDto(initMessage)
+	if err != nil {
+		return err
+	}
+
+	switch request.msgType {
+	case message_types.MetadataQuery, message_types.CreateDirectory, message_types.DeleteResource:
+		resourceReq, err := newResourceRequestDto(request.payload)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.queryHostResource(ctx, hostUuid, resourceReq.resourceUuid, resourceReq.path, request.msgType)
+		if err != nil {
+			return err
+		}
+
+		return stream.Send(resp)
+	case message_types.DownloadInitRequest:
+		resourceReq, err := newResourceRequestDto(request.payload)
+		if err != nil {
+			return err
+		}
+
+		return s.downloadResource(ctx, stream, hostUuid, flow, resourceReq.resourceUuid, resourceReq.path)
+	case message_types.CreateFileInitRequest:
+		createFileReq, err := newCreateFileRequestDto(request.payload)
+		if err != nil {
+			return err
+		}
+
+		return s.createFile(ctx, stream, hostUuid, flow, createFileReq.resourceUuid, createFileReq.path, createFileReq.fileSize)
+	case message_types.CreateFileInitRequest64:
+		createFileReq, err := newCreateFileRequest64Dto(request.payload)
+		if err != nil {
+			return err
+		}
+
+		return s.createFile(ctx, stream, hostUuid, flow, createFileReq.resourceUuid, createFileReq.path, createFileReq.fileSize)
+	case message_types.ListDirectoryQuery:
+		listReq, err := newListRequestDto(request.payload)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.ListDirectory(ctx, hostUuid, listReq.resourceUuid, listReq.path, listReq.options)
+		if err != nil {
+			return err
+		}
+
+		return stream.Send(resp)
+	case message_types.MoveResource:
+		moveReq, err := newTransferRequestDto(request.payload)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.MoveResource(
+			ctx,
+			hostUuid,
+			moveReq.resourceUuid,
+			moveReq.sourcePath,
+			moveReq.resourceUuid,
+			moveReq.destinationPath,
+			moveReq.policy,
+		)
+		if err != nil {
+			return err
+		}
+
+		return stream.Send(resp)
+	case message_types.CopyResource:
+		copyReq, err := newTransferRequestDto(request.payload)
+		if err != nil {
+			return err
+		}
+
+		// Copies within one shared resource are made by the host, so no chunk size is needed
+		err = s.CopyResource(
+			ctx,
+			hostUuid,
+			copyReq.resourceUuid,
+			copyReq.sourcePath,
+			hostUuid,
+			copyReq.resourceUuid,
+			copyReq.destinationPath,
+			copyReq.policy,
+			0,
+		)
+		if err != nil {
+			return err
+		}
+
+		return stream.Send(message_types.ACK.Binary())
+	default:
+		return ws_errors.UnexpectedMessageTypeErr
+	}
+}
+
+// sendErrorToClient reports the error to the client with its websocket error code, or UnknownError if it has none
+func sendErrorToClient(ctx context.Context, clientConn clientconn.ClientConn, err error) {
+	var wsErr ws_errors.WebsocketError
+	if errors.As(err, &wsErr) {
+		clientConn.SendAndLogError(message_types.Error.Binary(), wsErr.Code().Binary())
+		return
+	}
+
+	logging.FromContext(ctx).Error("client session operation failed", "error", err)
+	clientConn.SendAndLogError(message_types.Error.Binary(), ws_errors.UnknownError.Binary())
+}
@@ -0,0 +1,229 @@
+package host
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func resourceRequest(msgType message_types.WebsocketMessageType, resourceId uuid.UUID, path string) []byte {
+	msg := msgType.Binary()
+	msg = append(msg, helpers.UUIDToBinary(resourceId)...)
+	return append(msg, []byte(helpers.AddNullCharToString(path))...)
+}
+
+func TestServeClientSession(t *testing.T) {
+	t.Run("session closed", func(t *testing.T) {
+		hostId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSession := &clientsession.MockClientSession{}
+		defer mockSession.AssertExpectations(t)
+
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	})
+
+	t.Run("metadata query", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockSession := &clientsession.MockClientSession{}
+		mockStream := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockSession.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		}()
+
+		mockSession.On("Accept").Return(uint32(1), mockStream, resourceRequest(message_types.MetadataQuery, resourceId, "/a/b"), nil).Once()
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		mockHostConn.On("Query", [][]byte{
+			message_types.MetadataQuery.Binary(),
+			helpers.UUIDToBinary(resourceId),
+			[]byte("/a/b\000"),
+		}).Return([]byte{1, 2, 3}, nil)
+
+		mockStream.On("Send", [][]byte{{1, 2, 3}}).Return(nil)
+		mockStream.On("Close").Return()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockSession := &clientsession.MockClientSession{}
+		mockStream := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockSession.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		}()
+
+		mockSession.On("Accept").Return(uint32(1), mockStream, resourceRequest(message_types.DownloadInitRequest, resourceId, "/file"), nil).Once()
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		downloadInitResponse := message_types.DownloadInitResponse.Binary()
+		downloadInitResponse = append(downloadInitResponse, helpers.Uint32ToBinary(5)...)
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadInitRequest.Binary(),
+			helpers.UUIDToBinary(resourceId),
+			[]byte("/file\000"),
+		}).Return(downloadInitResponse, nil)
+		mockStream.On("Send", [][]byte{message_types.DownloadInitResponse.Binary(), {}}).Return(nil)
+
+		mockStream.On("Listen").Return(message_types.DownloadCompletionRequest.Binary(), nil).Once()
+		mockHostConn.On("Query", [][]byte{
+			message_types.DownloadCompletionRequest.Binary(),
+			helpers.Uint32ToBinary(5),
+		}).Return(message_types.ACK.Binary(), nil)
+		mockStream.On("Close").Return()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	})
+
+	t.Run("downloads of the session share a flow", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockSession := &clientsession.MockClientSession{}
+		mockStream := &clientconn.MockClientConn{}
+
+		initMessage := resourceRequest(message_types.DownloadInitRequest, resourceId, "/file")
+		mockSession.On("Accept").Return(uint32(1), mockStream, initMessage, nil).Once()
+		mockSession.On("Accept").Return(uint32(2), mockStream, initMessage, nil).Once()
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		errorResponse := append(message_types.Error.Binary(), ws_errors.ResourceNotFound.Binary()...)
+		mockHostConn.On("Query", mock.Anything).Return(errorResponse, nil)
+		mockStream.On("Send", [][]byte{errorResponse}).Return(nil)
+		mockStream.On("Close").Return()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+		require.Len(t, mockHostConn.Flows, 2)
+		assert.NotEqual(t, hostconn.DefaultFlow, mockHostConn.Flows[0])
+		assert.Equal(t, mockHostConn.Flows[0], mockHostConn.Flows[1])
+	})
+
+	t.Run("error - unexpected message type is reported on the stream", func(t *testing.T) {
+		hostId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSession := &clientsession.MockClientSession{}
+		mockStream := &clientconn.MockClientConn{}
+		defer func() {
+			mockSession.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		}()
+
+		mockSession.On("Accept").Return(uint32(1), mockStream, message_types.ChunkRequest.Binary(), nil).Once()
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		mockStream.On("SendAndLogError", [][]byte{
+			message_types.Error.Binary(),
+			ws_errors.UnexpectedMessageType.Binary(),
+		}).Return()
+		mockStream.On("Close").Return()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	})
+
+	t.Run("error - host not found is reported on the stream", func(t *testing.T) {
+		hostId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSession := &clientsession.MockClientSession{}
+		mockStream := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockSession.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		}()
+
+		mockSession.On("Accept").Return(uint32(1), mockStream, resourceRequest(message_types.DeleteResource, uuid.New(), "/x"), nil).Once()
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		mockHostMap.On("Get", hostId).Return(nil, false)
+		mockStream.On("SendAndLogError", [][]byte{
+			message_types.Error.Binary(),
+			ws_errors.HostNotFound.Binary(),
+		}).Return()
+		mockStream.On("Close").Return()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	})
+
+	t.Run("error - invalid message body is reported on the stream", func(t *testing.T) {
+		hostId := uuid.New()
+		mockSavedConnectionsRepo := saved_connections_repository.MockSavedConnectionsRepository{}
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSession := &clientsession.MockClientSession{}
+		mockStream := &clientconn.MockClientConn{}
+		defer func() {
+			mockSession.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		}()
+
+		initMessage := append(message_types.CreateFileInitRequest.Binary(), 1, 2, 3)
+		mockSession.On("Accept").Return(uint32(1), mockStream, initMessage, nil).Once()
+		mockSession.On("Accept").Return(uint32(0), nil, nil, ws_errors.ConnectionClosedErr).Once()
+
+		mockStream.On("SendAndLogError", [][]byte{
+			message_types.Error.Binary(),
+			ws_errors.InvalidMessageBody.Binary(),
+		}).Return()
+		mockStream.On("Close").Return()
+
+		svc := NewHostService(mockHostMap, &mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.ServeClientSession(context.Background(), mockSession, hostId)
+
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	})
+}
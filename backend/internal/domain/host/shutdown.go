@@ -0,0 +1,79 @@
+package host
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+)
+
+// goingAwayTimeout is how long hosts have to acknowledge Server Going Away
+const goingAwayTimeout = 5 * time.Second
+
+// StartDraining makes the downloads and uploads started from now on fail with ServerShuttingDownErr. Running ones
+// are not affected.
+func (s *defaultConnectionService) StartDraining() {
+	s.draining.Store(true)
+}
+
+// refuseWhileDraining returns ServerShuttingDownErr once the server has started draining
+func (s *defaultConnectionService) refuseWhileDraining() error {
+	if s.draining.Load() {
+		return ws_errors.ServerShuttingDownErr
+	}
+
+	return nil
+}
+
+// AnnounceShutdown sends Server Going Away to every connected host, with the number of seconds after which it should
+// reconnect (uint32), and waits for them to acknowledge it. Hosts keep serving running transfers until they are
+// disconnected.
+func (s *defaultConnectionService) AnnounceShutdown(reconnectAfter time.Duration) {
+	reconnectAfterSeconds := uint32(min(math.Ceil(reconnectAfter.Seconds()), math.MaxUint32))
+
+	var wg sync.WaitGroup
+	for hostId, hostConn := range s.hostMap.All() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := hostConn.QueryWithTimeout(
+				goingAwayTimeout,
+				hostconn.PriorityControl,
+				message_types.ServerGoingAway.Binary(),
+				helpers.Uint32ToBinary(reconnectAfterSeconds),
+			)
+			if err != nil {
+				slog.Warn("failed to tell host that the server is going away", logging.HostIdKey, hostId, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// WaitForTransfers blocks until no download or upload is running or ctx is done, it returns false in the latter
+// case. Client connections and sessions which are not transferring anything are not waited for.
+func (s *defaultConnectionService) WaitForTransfers(ctx context.Context) bool {
+	return s.transfers.wait(ctx)
+}
+
+// DisconnectAll aborts the running transfers and closes the connections of all hosts
+func (s *defaultConnectionService) DisconnectAll() {
+	s.transfers.abortAll()
+	for _, hostConn := range s.hostMap.All() {
+		hostConn.Close()
+	}
+}
+
+// StopIndexUpdates refuses further index updates and waits until the queued ones are stored. Once ctx is done,
+// the updates which are not stored yet are dropped. No update is stored after it has returned.
+func (s *defaultConnectionService) StopIndexUpdates(ctx context.Context) {
+	s.indexUpdates.stopAll(ctx)
+}
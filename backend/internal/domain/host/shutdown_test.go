@@ -0,0 +1,124 @@
+package host
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnounceShutdown(t *testing.T) {
+	t.Run("success - every host is told when to reconnect", func(t *testing.T) {
+		mockHostMap := &hostmap.MockHostMap{}
+		mockConn1 := &hostconn.MockConn{}
+		mockConn2 := &hostconn.MockConn{}
+		defer mockConn1.AssertExpectations(t)
+		defer mockConn2.AssertExpectations(t)
+
+		mockHostMap.On("All").Return(map[uuid.UUID]hostconn.HostConn{uuid.New(): mockConn1, uuid.New(): mockConn2})
+
+		goingAway := [][]byte{message_types.ServerGoingAway.Binary(), helpers.Uint32ToBinary(15)}
+		mockConn1.On("QueryWithTimeout", goingAway, goingAwayTimeout, hostconn.PriorityControl).
+			Return(message_types.ACK.Binary(), nil).Once()
+		// A host which does not answer does not stop the others from being told
+		mockConn2.On("QueryWithTimeout", goingAway, goingAwayTimeout, hostconn.PriorityControl).
+			Return(nil, ws_errors.TimeoutErr).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		svc.AnnounceShutdown(14500 * time.Millisecond)
+	})
+}
+
+func TestDisconnectAll(t *testing.T) {
+	t.Run("success - every host is disconnected", func(t *testing.T) {
+		mockHostMap := &hostmap.MockHostMap{}
+		mockConn1 := &hostconn.MockConn{}
+		mockConn2 := &hostconn.MockConn{}
+		defer mockConn1.AssertExpectations(t)
+		defer mockConn2.AssertExpectations(t)
+
+		mockHostMap.On("All").Return(map[uuid.UUID]hostconn.HostConn{uuid.New(): mockConn1, uuid.New(): mockConn2})
+		mockConn1.On("Close").Return().Once()
+		mockConn2.On("Close").Return().Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		svc.DisconnectAll()
+	})
+
+	t.Run("success - running transfers are aborted", func(t *testing.T) {
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostMap.On("All").Return(map[uuid.UUID]hostconn.HostConn{})
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+
+		mockClientConn := &clientconn.MockClientConn{}
+		defer mockClientConn.AssertExpectations(t)
+		mockClientConn.On("Close").Return().Once()
+		download, endDownload := startTestTransfer(t, svc.(*defaultConnectionService), context.Background(), mockClientConn, uuid.New(), downloadDirection)
+		defer endDownload()
+
+		svc.DisconnectAll()
+		assert.ErrorIs(t, download.Send([]byte("data")), ErrTransferAborted)
+	})
+}
+
+func TestWaitForTransfers(t *testing.T) {
+	t.Run("success - waits for running transfers only", func(t *testing.T) {
+		svc := newBandwidthTestService(BandwidthLimits{})
+		assert.True(t, svc.WaitForTransfers(context.Background()))
+
+		_, endFirst := startTestTransfer(t, svc, context.Background(), &clientconn.MockClientConn{}, uuid.New(), downloadDirection)
+		_, endSecond := startTestTransfer(t, svc, context.Background(), &clientconn.MockClientConn{}, uuid.New(), uploadDirection)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.False(t, svc.WaitForTransfers(ctx))
+
+		endFirst()
+		done := make(chan bool)
+		go func() {
+			done <- svc.WaitForTransfers(context.Background())
+		}()
+		endSecond()
+		assert.True(t, <-done)
+
+		// Transfers started once the registry has been idle are waited for again
+		_, endThird := startTestTransfer(t, svc, context.Background(), &clientconn.MockClientConn{}, uuid.New(), downloadDirection)
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.False(t, svc.WaitForTransfers(ctx))
+		endThird()
+		assert.True(t, svc.WaitForTransfers(context.Background()))
+	})
+}
+
+func TestStartDraining(t *testing.T) {
+	t.Run("error - new transfers are refused", func(t *testing.T) {
+		svc := newBandwidthTestService(BandwidthLimits{})
+		_, endRunning := startTestTransfer(t, svc, context.Background(), &clientconn.MockClientConn{}, uuid.New(), downloadDirection)
+		defer endRunning()
+
+		svc.StartDraining()
+
+		_, _, err := svc.startTransfer(context.Background(), &clientconn.MockClientConn{}, uuid.New(), uploadDirection)
+		assert.ErrorIs(t, err, ws_errors.ServerShuttingDownErr)
+		_, err = svc.OpenResourceReader(context.Background(), uuid.New(), uuid.New(), "/a", "10.0.0.1", func() {})
+		assert.ErrorIs(t, err, ws_errors.ServerShuttingDownErr)
+		_, err = svc.OpenResourceArchive(context.Background(), uuid.New(), uuid.New(), "/a", "10.0.0.1", func() {})
+		assert.ErrorIs(t, err, ws_errors.ServerShuttingDownErr)
+		err = svc.UploadFile(context.Background(), uuid.New(), uuid.New(), "/a", 1, 1, nil, "10.0.0.1", func() {})
+		assert.ErrorIs(t, err, ws_errors.ServerShuttingDownErr)
+
+		// The running transfer is not affected
+		assert.Len(t, svc.ListTransfers(), 1)
+	})
+}
@@ -0,0 +1,84 @@
+package host
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/google/uuid"
+)
+
+// capabilityNames are the names under which the capabilities of a host are reported in its status
+var capabilityNames = []struct {
+	capability hostconn.Capabilities
+	name       string
+}{
+	{hostconn.CapabilityLargeFiles, "large_files"},
+	{hostconn.CapabilityPagedListing, "paged_listing"},
+}
+
+// HostStatus tells whether a host is online, and since when or when it has last been seen otherwise
+type HostStatus struct {
+	Online bool `json:"online"`
+	// OnlineSince is set while the host is online
+	OnlineSince *time.Time `json:"online_since,omitempty"`
+	// LastSeen is set while the host is offline
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	// ActiveTransfers is the number of downloads and uploads running from and to the host
+	ActiveTransfers int `json:"active_transfers"`
+	// Capabilities are the protocol features the host has announced, empty while it is offline
+	Capabilities []string `json:"capabilities"`
+}
+
+// GetHostStatus returns the status of a host which is online or has been seen disconnecting
+func (s *defaultConnectionService) GetHostStatus(ctx context.Context, hostUuid uuid.UUID) (*HostStatus, error) {
+	if hostConn, ok := s.hostMap.Get(hostUuid); ok {
+		connectedAt := hostConn.ConnectedAt().UTC()
+		return &HostStatus{
+			Online:          true,
+			OnlineSince:     &connectedAt,
+			ActiveTransfers: s.transfers.count(hostUuid),
+			Capabilities:    capabilityList(hostConn.Capabilities()),
+		}, nil
+	}
+
+	lastSeen, err := s.savedConnectionsRepository.GetLastSeen(ctx, hostUuid)
+	if err != nil {
+		return nil, err
+	}
+	if lastSeen == nil {
+		return nil, ws_errors.HostNotFoundErr
+	}
+
+	return &HostStatus{
+		LastSeen:        lastSeen,
+		ActiveTransfers: s.transfers.count(hostUuid),
+		Capabilities:    []string{},
+	}, nil
+}
+
+// handleHostDisconnect records when the host has last been seen, stops the queue of its index updates and drops its
+// bandwidth limits
+func (s *defaultConnectionService) handleHostDisconnect(hostUuid uuid.UUID) {
+	s.indexUpdates.stop(hostUuid)
+	s.removeHostBandwidthLimit(hostUuid)
+
+	err := s.savedConnectionsRepository.UpdateLastSeen(context.Background(), hostUuid, time.Now())
+	if err != nil {
+		slog.Error("failed to save when host has last been seen", logging.HostIdKey, hostUuid, "error", err)
+	}
+}
+
+func capabilityList(capabilities hostconn.Capabilities) []string {
+	names := []string{}
+	for _, c := range capabilityNames {
+		if capabilities.Has(c.capability) {
+			names = append(names, c.name)
+		}
+	}
+
+	return names
+}
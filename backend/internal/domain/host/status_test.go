@@ -0,0 +1,114 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHostStatus(t *testing.T) {
+	t.Run("success - online host", func(t *testing.T) {
+		hostId := uuid.New()
+		connectedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		mockHostMap := &hostmap.MockHostMap{}
+		mockConn := &hostconn.MockConn{
+			StartedAt:        connectedAt,
+			HostCapabilities: hostconn.CapabilityPagedListing,
+		}
+
+		mockHostMap.On("Get", hostId).Return(mockConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+
+		// Transfers are counted until they have ended
+		_, endDownload := startTestTransfer(t, svc.(*defaultConnectionService), context.Background(), &clientconn.MockClientConn{}, hostId, downloadDirection)
+		_, endUpload := startTestTransfer(t, svc.(*defaultConnectionService), context.Background(), &clientconn.MockClientConn{}, hostId, uploadDirection)
+		endUpload()
+		endUpload()
+		defer endDownload()
+
+		status, err := svc.GetHostStatus(context.Background(), hostId)
+		require.NoError(t, err)
+		assert.Equal(t, &HostStatus{
+			Online:          true,
+			OnlineSince:     &connectedAt,
+			ActiveTransfers: 1,
+			Capabilities:    []string{"paged_listing"},
+		}, status)
+	})
+
+	t.Run("success - offline host", func(t *testing.T) {
+		hostId := uuid.New()
+		lastSeen := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSavedConnectionsRepo := &saved_connections_repository.MockSavedConnectionsRepository{}
+
+		mockHostMap.On("Get", hostId).Return(nil, false)
+		mockSavedConnectionsRepo.On("GetLastSeen", mock.Anything, hostId).Return(&lastSeen, nil)
+
+		svc := NewHostService(mockHostMap, mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		status, err := svc.GetHostStatus(context.Background(), hostId)
+		require.NoError(t, err)
+		assert.Equal(t, &HostStatus{
+			LastSeen:     &lastSeen,
+			Capabilities: []string{},
+		}, status)
+	})
+
+	t.Run("error - host never seen", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSavedConnectionsRepo := &saved_connections_repository.MockSavedConnectionsRepository{}
+
+		mockHostMap.On("Get", hostId).Return(nil, false)
+		mockSavedConnectionsRepo.On("GetLastSeen", mock.Anything, hostId).Return(nil, nil)
+
+		svc := NewHostService(mockHostMap, mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.GetHostStatus(context.Background(), hostId)
+		assert.ErrorIs(t, err, ws_errors.HostNotFoundErr)
+	})
+
+	t.Run("error - repository error", func(t *testing.T) {
+		hostId := uuid.New()
+		repoErr := errors.New("database is locked")
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSavedConnectionsRepo := &saved_connections_repository.MockSavedConnectionsRepository{}
+
+		mockHostMap.On("Get", hostId).Return(nil, false)
+		mockSavedConnectionsRepo.On("GetLastSeen", mock.Anything, hostId).Return(nil, repoErr)
+
+		svc := NewHostService(mockHostMap, mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		_, err := svc.GetHostStatus(context.Background(), hostId)
+		assert.ErrorIs(t, err, repoErr)
+	})
+}
+
+func TestHostDisconnect(t *testing.T) {
+	t.Run("success - last seen time is saved", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockSavedConnectionsRepo := &saved_connections_repository.MockSavedConnectionsRepository{}
+		defer mockSavedConnectionsRepo.AssertExpectations(t)
+
+		before := time.Now()
+		mockSavedConnectionsRepo.On("UpdateLastSeen", mock.Anything, hostId, mock.MatchedBy(func(lastSeen time.Time) bool {
+			return !lastSeen.Before(before)
+		})).Return(nil).Once()
+
+		NewHostService(mockHostMap, mockSavedConnectionsRepo, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		require.NotNil(t, mockHostMap.DisconnectHandler)
+		mockHostMap.DisconnectHandler(hostId)
+	})
+}
@@ -0,0 +1,293 @@
+package host
+
+import (
+	"context"
+	"io"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/tracing"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedHostService traces every operation made for a client request with a span named after the method, which
+// the queries sent to the host for it are children of. Operations calling each other inside the service are traced
+// only once, as the outer operation.
+type tracedHostService struct {
+	HostService
+}
+
+// NewTracedHostService wraps the service so that its operations are traced
+func NewTracedHostService(service HostService) HostService {
+	return &tracedHostService{HostService: service}
+}
+
+func startOperation(ctx context.Context, name string, hostUuid uuid.UUID) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "HostService."+name, tracing.HostIdKey.String(hostUuid.String()))
+}
+
+func (s *tracedHostService) InitNewHostConnection(ctx context.Context, ws *websocket.Conn, remoteAddr string) error {
+	ctx, span := tracing.Start(ctx, "HostService.InitNewHostConnection")
+	err := s.HostService.InitNewHostConnection(ctx, ws, remoteAddr)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) InitExistingHostConnection(
+	ctx context.Context,
+	ws *websocket.Conn,
+	remoteAddr string,
+	hostId uuid.UUID,
+	hostKey string,
+) error {
+	ctx, span := startOperation(ctx, "InitExistingHostConnection", hostId)
+	err := s.HostService.InitExistingHostConnection(ctx, ws, remoteAddr, hostId, hostKey)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) GetResourceMetadata(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) ([]byte, error) {
+	ctx, span := startOperation(ctx, "GetResourceMetadata", hostUuid)
+	resp, err := s.HostService.GetResourceMetadata(ctx, hostUuid, resourceUuid, pathToResource)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *tracedHostService) ListDirectory(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToDirectory string,
+	options ListOptions,
+) ([]byte, error) {
+	ctx, span := startOperation(ctx, "ListDirectory", hostUuid)
+	resp, err := s.HostService.ListDirectory(ctx, hostUuid, resourceUuid, pathToDirectory, options)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *tracedHostService) DownloadResource(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) error {
+	ctx, span := startOperation(ctx, "DownloadResource", hostUuid)
+	err := s.HostService.DownloadResource(ctx, clientConn, hostUuid, resourceUuid, pathToResource)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) DownloadResourceResumable(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) error {
+	ctx, span := startOperation(ctx, "DownloadResourceResumable", hostUuid)
+	err := s.HostService.DownloadResourceResumable(ctx, clientConn, hostUuid, resourceUuid, pathToResource)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) ResumeDownload(ctx context.Context, clientConn clientconn.ClientConn, token uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "HostService.ResumeDownload")
+	err := s.HostService.ResumeDownload(ctx, clientConn, token)
+	tracing.End(span, err)
+	return err
+}
+
+// OpenResourceReader is traced until the reader is open, the queries of the reads that follow are children of
+// its span all the same
+func (s *tracedHostService) OpenResourceReader(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+	clientIp string,
+	cancel func(),
+) (*ResourceReader, error) {
+	ctx, span := startOperation(ctx, "OpenResourceReader", hostUuid)
+	reader, err := s.HostService.OpenResourceReader(ctx, hostUuid, resourceUuid, pathToResource, clientIp, cancel)
+	tracing.End(span, err)
+	return reader, err
+}
+
+// OpenResourceArchive is traced until the archive is open, like OpenResourceReader
+func (s *tracedHostService) OpenResourceArchive(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+	clientIp string,
+	cancel func(),
+) (*ResourceArchive, error) {
+	ctx, span := startOperation(ctx, "OpenResourceArchive", hostUuid)
+	archive, err := s.HostService.OpenResourceArchive(ctx, hostUuid, resourceUuid, pathToResource, clientIp, cancel)
+	tracing.End(span, err)
+	return archive, err
+}
+
+func (s *tracedHostService) UploadFile(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+	chunkSize int,
+	body io.Reader,
+	clientIp string,
+	cancel func(),
+) error {
+	ctx, span := startOperation(ctx, "UploadFile", hostUuid)
+	span.SetAttributes(attribute.Int64("file_size", int64(fileSize)))
+	err := s.HostService.UploadFile(ctx, hostUuid, resourceUuid, pathToFile, fileSize, chunkSize, body, clientIp, cancel)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) CreateDirectory(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToDirectory string,
+) ([]byte, error) {
+	ctx, span := startOperation(ctx, "CreateDirectory", hostUuid)
+	resp, err := s.HostService.CreateDirectory(ctx, hostUuid, resourceUuid, pathToDirectory)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *tracedHostService) DeleteResource(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToResource string,
+) ([]byte, error) {
+	ctx, span := startOperation(ctx, "DeleteResource", hostUuid)
+	resp, err := s.HostService.DeleteResource(ctx, hostUuid, resourceUuid, pathToResource)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *tracedHostService) MoveResource(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	sourcePath string,
+	destinationResourceUuid uuid.UUID,
+	destinationPath string,
+	policy ConflictPolicy,
+) ([]byte, error) {
+	ctx, span := startOperation(ctx, "MoveResource", hostUuid)
+	resp, err := s.HostService.MoveResource(ctx, hostUuid, resourceUuid, sourcePath, destinationResourceUuid, destinationPath, policy)
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (s *tracedHostService) CopyResource(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	sourcePath string,
+	destinationHostUuid uuid.UUID,
+	destinationResourceUuid uuid.UUID,
+	destinationPath string,
+	policy ConflictPolicy,
+	chunkSize int,
+) error {
+	ctx, span := startOperation(ctx, "CopyResource", hostUuid)
+	span.SetAttributes(attribute.String("destination_host_id", destinationHostUuid.String()))
+	err := s.HostService.CopyResource(
+		ctx,
+		hostUuid,
+		resourceUuid,
+		sourcePath,
+		destinationHostUuid,
+		destinationResourceUuid,
+		destinationPath,
+		policy,
+		chunkSize,
+	)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) CreateFile(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+) error {
+	ctx, span := startOperation(ctx, "CreateFile", hostUuid)
+	span.SetAttributes(attribute.Int64("file_size", int64(fileSize)))
+	err := s.HostService.CreateFile(ctx, clientConn, hostUuid, resourceUuid, pathToFile, fileSize)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) CreateFileResumable(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+) error {
+	ctx, span := startOperation(ctx, "CreateFileResumable", hostUuid)
+	span.SetAttributes(attribute.Int64("file_size", int64(fileSize)))
+	err := s.HostService.CreateFileResumable(ctx, clientConn, hostUuid, resourceUuid, pathToFile, fileSize)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) ResumeUpload(ctx context.Context, clientConn clientconn.ClientConn, token uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "HostService.ResumeUpload")
+	err := s.HostService.ResumeUpload(ctx, clientConn, token)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) ServeClientSession(
+	ctx context.Context,
+	session clientsession.ClientSession,
+	hostUuid uuid.UUID,
+) error {
+	ctx, span := startOperation(ctx, "ServeClientSession", hostUuid)
+	err := s.HostService.ServeClientSession(ctx, session, hostUuid)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedHostService) SearchIndex(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	query string,
+	limit int,
+) ([]file_index_repository.IndexEntry, error) {
+	ctx, span := startOperation(ctx, "SearchIndex", hostUuid)
+	entries, err := s.HostService.SearchIndex(ctx, hostUuid, resourceUuid, query, limit)
+	tracing.End(span, err)
+	return entries, err
+}
+
+func (s *tracedHostService) GetHostStatus(ctx context.Context, hostUuid uuid.UUID) (*HostStatus, error) {
+	ctx, span := startOperation(ctx, "GetHostStatus", hostUuid)
+	status, err := s.HostService.GetHostStatus(ctx, hostUuid)
+	tracing.End(span, err)
+	return status, err
+}
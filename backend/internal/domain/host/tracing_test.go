@@ -0,0 +1,72 @@
+package host
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/tracing"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedHostService(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(provider)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	newService := func(mockHostMap *hostmap.MockHostMap) HostService {
+		return NewTracedHostService(NewHostService(
+			mockHostMap,
+			&saved_connections_repository.MockSavedConnectionsRepository{},
+			&file_index_repository.MockFileIndexRepository{},
+			BandwidthLimits{},
+			testChunkSize,
+		))
+	}
+
+	t.Run("success - operation span with the host ID", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockConn := &hostconn.MockConn{}
+		mockHostMap.On("Get", hostId).Return(mockConn, true)
+		mockConn.On("Query", mock.Anything).Return(message_types.ACK.Binary(), nil)
+
+		_, err := newService(mockHostMap).GetResourceMetadata(context.Background(), hostId, uuid.New(), "/a")
+		require.NoError(t, err)
+
+		spans := recorder.Ended()
+		require.NotEmpty(t, spans)
+		span := spans[len(spans)-1]
+		assert.Equal(t, "HostService.GetResourceMetadata", span.Name())
+		assert.Contains(t, span.Attributes(), tracing.HostIdKey.String(hostId.String()))
+		assert.Equal(t, codes.Unset, span.Status().Code)
+	})
+
+	t.Run("error - failed operation span", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostMap.On("Get", hostId).Return(nil, false)
+
+		_, err := newService(mockHostMap).DeleteResource(context.Background(), hostId, uuid.New(), "/a")
+		assert.ErrorIs(t, err, ws_errors.HostNotFoundErr)
+
+		spans := recorder.Ended()
+		require.NotEmpty(t, spans)
+		span := spans[len(spans)-1]
+		assert.Equal(t, "HostService.DeleteResource", span.Name())
+		assert.Equal(t, codes.Error, span.Status().Code)
+	})
+}
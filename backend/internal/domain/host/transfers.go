@@ -0,0 +1,240 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/google/uuid"
+)
+
+// ErrTransferAborted is returned by transfers which have been aborted with AbortTransfer
+var ErrTransferAborted = errors.New("transfer aborted")
+
+// ErrTransferNotFound is returned by AbortTransfer when no transfer with the ID is running
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// TransferInfo describes a running download or upload
+type TransferInfo struct {
+	Id        uuid.UUID `json:"id"`
+	HostUuid  uuid.UUID `json:"host_uuid"`
+	Direction string    `json:"direction"`
+	// ClientIP is empty for transfers made by the relay itself, e.g. copies between hosts
+	ClientIP  string    `json:"client_ip,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// Bytes is the number of bytes relayed so far
+	Bytes int64 `json:"bytes"`
+}
+
+// ListTransfers returns the running downloads and uploads, oldest first
+func (s *defaultConnectionService) ListTransfers() []TransferInfo {
+	return s.transfers.list()
+}
+
+// AbortTransfer makes the transfer fail with ErrTransferAborted. Transfers over a client connection fail at once
+// as the connection is closed, others the next time they pass data. Transfers waiting for bandwidth stop waiting.
+func (s *defaultConnectionService) AbortTransfer(id uuid.UUID) error {
+	if !s.transfers.abort(id) {
+		return ErrTransferNotFound
+	}
+
+	return nil
+}
+
+// transfer is a running download or upload. All its methods may be called on a nil transfer, which does nothing.
+type transfer struct {
+	id        uuid.UUID
+	hostUuid  uuid.UUID
+	direction transferDirection
+	clientIp  string
+	startedAt time.Time
+
+	// ctx is done once the transfer has ended, been aborted or the context it was started with is done. Its cause
+	// is ErrTransferAborted for aborted transfers.
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	bytes   atomic.Int64
+	aborted atomic.Bool
+	// closeClientConn is nil for transfers without a client connection
+	closeClientConn func()
+
+	registry *transferRegistry
+	endOnce  sync.Once
+}
+
+// add counts n relayed bytes and returns ErrTransferAborted once the transfer has been aborted
+func (t *transfer) add(n int) error {
+	if t == nil {
+		return nil
+	}
+
+	if t.aborted.Load() {
+		return ErrTransferAborted
+	}
+
+	t.bytes.Add(int64(n))
+	metrics.RelayedBytes.WithLabelValues(t.direction.String()).Add(float64(n))
+	return nil
+}
+
+func (t *transfer) abort() {
+	t.aborted.Store(true)
+	t.cancel(ErrTransferAborted)
+	if t.closeClientConn != nil {
+		t.closeClientConn()
+	}
+}
+
+// end removes the transfer from the registry, it has to be called once the transfer has ended
+func (t *transfer) end() {
+	if t == nil {
+		return
+	}
+
+	t.endOnce.Do(func() {
+		t.cancel(nil)
+		t.registry.remove(t.id)
+	})
+}
+
+func (t *transfer) info() TransferInfo {
+	return TransferInfo{
+		Id:        t.id,
+		HostUuid:  t.hostUuid,
+		Direction: t.direction.String(),
+		ClientIP:  t.clientIp,
+		StartedAt: t.startedAt.UTC(),
+		Bytes:     t.bytes.Load(),
+	}
+}
+
+// transferRegistry holds the running transfers of all hosts
+type transferRegistry struct {
+	mu        sync.Mutex
+	transfers map[uuid.UUID]*transfer
+	// idle is closed while no transfer is running
+	idle chan struct{}
+}
+
+func newTransferRegistry() *transferRegistry {
+	idle := make(chan struct{})
+	close(idle)
+
+	return &transferRegistry{transfers: make(map[uuid.UUID]*transfer), idle: idle}
+}
+
+// start registers a transfer of the host made for ctx, closeClientConn is called when it is aborted and may be nil
+func (r *transferRegistry) start(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	direction transferDirection,
+	clientIp string,
+	closeClientConn func(),
+) *transfer {
+	t := &transfer{
+		id:              uuid.New(),
+		hostUuid:        hostUuid,
+		direction:       direction,
+		clientIp:        clientIp,
+		startedAt:       time.Now(),
+		closeClientConn: closeClientConn,
+		registry:        r,
+	}
+	t.ctx, t.cancel = context.WithCancelCause(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.transfers) == 0 {
+		r.idle = make(chan struct{})
+	}
+	r.transfers[t.id] = t
+	metrics.ActiveTransfers.WithLabelValues(direction.String()).Inc()
+	return t
+}
+
+func (r *transferRegistry) remove(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.transfers[id]; ok {
+		delete(r.transfers, id)
+		metrics.ActiveTransfers.WithLabelValues(t.direction.String()).Dec()
+		if len(r.transfers) == 0 {
+			close(r.idle)
+		}
+	}
+}
+
+// wait blocks until no transfer is running or ctx is done, it returns false in the latter case
+func (r *transferRegistry) wait(ctx context.Context) bool {
+	r.mu.Lock()
+	idle := r.idle
+	r.mu.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *transferRegistry) abort(id uuid.UUID) bool {
+	r.mu.Lock()
+	t, ok := r.transfers[id]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	t.abort()
+	return true
+}
+
+// abortAll aborts every running transfer
+func (r *transferRegistry) abortAll() {
+	r.mu.Lock()
+	transfers := make([]*transfer, 0, len(r.transfers))
+	for _, t := range r.transfers {
+		transfers = append(transfers, t)
+	}
+	r.mu.Unlock()
+
+	for _, t := range transfers {
+		t.abort()
+	}
+}
+
+// count returns the number of transfers of the host
+func (r *transferRegistry) count(hostUuid uuid.UUID) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, t := range r.transfers {
+		if t.hostUuid == hostUuid {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *transferRegistry) list() []TransferInfo {
+	r.mu.Lock()
+	infos := make([]TransferInfo, 0, len(r.transfers))
+	for _, t := range r.transfers {
+		infos = append(infos, t.info())
+	}
+	r.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+	return infos
+}
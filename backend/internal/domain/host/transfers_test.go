@@ -0,0 +1,102 @@
+package host
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransfers(t *testing.T) {
+	t.Run("success - transfers are listed with their bytes", func(t *testing.T) {
+		hostId := uuid.New()
+		svc := newBandwidthTestService(BandwidthLimits{})
+		mockClientConn := &clientconn.MockClientConn{IP: "10.0.0.1"}
+		mockClientConn.On("Send", mock.Anything).Return(nil)
+		mockClientConn.On("Listen").Return([]byte("chunk"), nil)
+
+		download, endDownload := startTestTransfer(t, svc, context.Background(), mockClientConn, hostId, downloadDirection)
+		upload, endUpload := startTestTransfer(t, svc, context.Background(), mockClientConn, hostId, uploadDirection)
+		defer endUpload()
+
+		require.NoError(t, download.Send([]byte("type"), []byte("data")))
+		_, err := upload.Listen()
+		require.NoError(t, err)
+
+		transfers := svc.ListTransfers()
+		require.Len(t, transfers, 2)
+		assert.Equal(t, hostId, transfers[0].HostUuid)
+		assert.Equal(t, "download", transfers[0].Direction)
+		assert.Equal(t, "10.0.0.1", transfers[0].ClientIP)
+		assert.Equal(t, int64(8), transfers[0].Bytes)
+		assert.Equal(t, "upload", transfers[1].Direction)
+		assert.Equal(t, int64(5), transfers[1].Bytes)
+
+		endDownload()
+		assert.Len(t, svc.ListTransfers(), 1)
+	})
+
+	t.Run("success - aborted transfer closes its client connection and fails", func(t *testing.T) {
+		svc := newBandwidthTestService(BandwidthLimits{})
+		mockClientConn := &clientconn.MockClientConn{}
+		defer mockClientConn.AssertExpectations(t)
+		mockClientConn.On("Close").Return().Once()
+
+		download, endDownload := startTestTransfer(t, svc, context.Background(), mockClientConn, uuid.New(), downloadDirection)
+		defer endDownload()
+
+		require.NoError(t, svc.AbortTransfer(svc.ListTransfers()[0].Id))
+		assert.ErrorIs(t, download.Send([]byte("data")), ErrTransferAborted)
+	})
+
+	t.Run("success - aborted transfer stops waiting for bandwidth", func(t *testing.T) {
+		svc := newBandwidthTestService(BandwidthLimits{PerClient: 1_000})
+		mockClientConn := &clientconn.MockClientConn{IP: "10.0.0.1"}
+		mockClientConn.On("Close").Return()
+
+		download, endDownload := startTestTransfer(t, svc, context.Background(), mockClientConn, uuid.New(), downloadDirection)
+		defer endDownload()
+
+		// The bytes over the limit would wait for an hour
+		sent := make(chan error, 1)
+		go func() {
+			sent <- download.Send(make([]byte, 3_600_000))
+		}()
+		require.Eventually(t, func() bool {
+			return len(svc.ListTransfers()) == 1 && svc.ListTransfers()[0].Bytes > 0
+		}, time.Second, time.Millisecond)
+
+		require.NoError(t, svc.AbortTransfer(svc.ListTransfers()[0].Id))
+		select {
+		case err := <-sent:
+			assert.ErrorIs(t, err, ErrTransferAborted)
+		case <-time.After(time.Second):
+			t.Fatal("the aborted transfer is still waiting for bandwidth")
+		}
+	})
+
+	t.Run("success - transfer stops waiting for bandwidth once its context is done", func(t *testing.T) {
+		svc := newBandwidthTestService(BandwidthLimits{PerClient: 1_000})
+		mockClientConn := &clientconn.MockClientConn{IP: "10.0.0.1"}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		upload, endUpload := startTestTransfer(t, svc, ctx, mockClientConn, uuid.New(), uploadDirection)
+		defer endUpload()
+		mockClientConn.On("Listen").Return(make([]byte, 3_600_000), nil).Run(func(mock.Arguments) {
+			cancel()
+		})
+
+		_, err := upload.Listen()
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("error - unknown transfer", func(t *testing.T) {
+		svc := newBandwidthTestService(BandwidthLimits{})
+		assert.ErrorIs(t, svc.AbortTransfer(uuid.New()), ErrTransferNotFound)
+	})
+}
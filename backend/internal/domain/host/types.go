@@ -1,13 +1,24 @@
 package host
 
 import (
+	"encoding/json"
+	"strings"
+	"time"
+
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/google/uuid"
 )
 
 const (
-	uploadIdSize = 4
+	uploadIdSize     = 4
+	sizeInChunksSize = 4
+	uuidSize         = 16
+	fileSizeSize     = 4
+	fileSize64Size   = 8
+	capabilitiesSize = 4
 )
 
 type hostStreamInitResponseDto struct {
@@ -30,6 +41,16 @@ func newHostStreamInitResponseDto(resp []byte) (hostStreamInitResponseDto, error
 	return hostResp, nil
 }
 
+// sizeInChunks returns the download size reported by the host in DownloadInitResponse,
+// or false if the host has not reported it
+func (dto hostStreamInitResponseDto) sizeInChunks() (uint32, bool) {
+	if len(dto.payload) < sizeInChunksSize {
+		return 0, false
+	}
+
+	return helpers.BinaryToUint32(dto.payload[:sizeInChunksSize]), true
+}
+
 type msgTypeWithPayload struct {
 	msgType message_types.WebsocketMessageType
 	payload []byte
@@ -47,3 +68,189 @@ func newMsgTypeWithPayloadDto(req []byte) (msgTypeWithPayload, error) {
 
 	return clientReq, nil
 }
+
+type resourceRequestDto struct {
+	resourceUuid uuid.UUID
+	path         string
+}
+
+// newResourceRequestDto reads a payload in the same layout the host receives it: resource UUID followed
+// by a null terminated path
+func newResourceRequestDto(payload []byte) (resourceRequestDto, error) {
+	if len(payload) < uuidSize {
+		return resourceRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	resourceUuid, err := uuid.FromBytes(payload[:uuidSize])
+	if err != nil {
+		return resourceRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	return resourceRequestDto{
+		resourceUuid: resourceUuid,
+		path:         strings.TrimSuffix(string(payload[uuidSize:]), "\000"),
+	}, nil
+}
+
+type createFileRequestDto struct {
+	resourceUuid uuid.UUID
+	fileSize     uint64
+	path         string
+}
+
+// newCreateFileRequestDto reads a payload laid out as CreateFileInitRequest: resource UUID, file size
+// and a null terminated path
+func newCreateFileRequestDto(payload []byte) (createFileRequestDto, error) {
+	if len(payload) < uuidSize+fileSizeSize {
+		return createFileRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	resourceUuid, err := uuid.FromBytes(payload[:uuidSize])
+	if err != nil {
+		return createFileRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	return createFileRequestDto{
+		resourceUuid: resourceUuid,
+		fileSize:     uint64(helpers.BinaryToUint32(payload[uuidSize : uuidSize+fileSizeSize])),
+		path:         strings.TrimSuffix(string(payload[uuidSize+fileSizeSize:]), "\000"),
+	}, nil
+}
+
+// newCreateFileRequest64Dto reads a payload laid out as CreateFileInitRequest64, which differs from
+// CreateFileInitRequest only by the 64-bit file size
+func newCreateFileRequest64Dto(payload []byte) (createFileRequestDto, error) {
+	if len(payload) < uuidSize+fileSize64Size {
+		return createFileRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	resourceUuid, err := uuid.FromBytes(payload[:uuidSize])
+	if err != nil {
+		return createFileRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	return createFileRequestDto{
+		resourceUuid: resourceUuid,
+		fileSize:     helpers.BinaryToUint64(payload[uuidSize : uuidSize+fileSize64Size]),
+		path:         strings.TrimSuffix(string(payload[uuidSize+fileSize64Size:]), "\000"),
+	}, nil
+}
+
+// readInitAck checks that the host has answered the init query with ACK and returns the capabilities the host
+// has announced after it. Hosts which announce none send a bare ACK.
+func readInitAck(resp []byte) (hostconn.Capabilities, bool) {
+	msgType, err := message_types.GetMsgType(resp)
+	if err != nil || msgType != message_types.ACK {
+		return 0, false
+	}
+
+	if len(resp) < message_types.WebsocketMessageTypeSize+capabilitiesSize {
+		return 0, true
+	}
+
+	capabilities := helpers.BinaryToUint32(resp[message_types.WebsocketMessageTypeSize : message_types.WebsocketMessageTypeSize+capabilitiesSize])
+	return hostconn.Capabilities(capabilities), true
+}
+
+type downloadWindowRequestDto struct {
+	windowSize  uint16
+	chunkSize   uint32
+	startOffset uint64
+}
+
+func newDownloadWindowRequestDto(payload []byte) (downloadWindowRequestDto, error) {
+	if len(payload) < 2+4+8 {
+		return downloadWindowRequestDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	return downloadWindowRequestDto{
+		windowSize:  helpers.BinaryToUint16(payload[0:2]),
+		chunkSize:   helpers.BinaryToUint32(payload[2:6]),
+		startOffset: helpers.BinaryToUint64(payload[6:14]),
+	}, nil
+}
+
+// maxUploadCredits is the largest number of chunks the host can ask the client to send in one batch
+const maxUploadCredits = 64
+
+// newCreditGrantDto reads the number of credits from CreateFileCreditGrant: credits (uint16) followed by the start offset
+func newCreditGrantDto(resp []byte) (uint16, error) {
+	if len(resp) < message_types.WebsocketMessageTypeSize+2+8 {
+		return 0, ws_errors.InvalidMessageBodyErr
+	}
+
+	credits := helpers.BinaryToUint16(resp[message_types.WebsocketMessageTypeSize : message_types.WebsocketMessageTypeSize+2])
+	if credits == 0 || credits > maxUploadCredits {
+		return 0, ws_errors.InvalidMessageBodyErr
+	}
+
+	return credits, nil
+}
+
+// encryptedFlag is set in the flags byte of MetadataResponse and DownloadInitResponse when the resource is encrypted
+const encryptedFlag = 1
+
+// flags returns the flags byte of DownloadInitResponse, which follows the size in chunks
+func (dto hostStreamInitResponseDto) flags() uint8 {
+	if len(dto.payload) <= sizeInChunksSize {
+		return 0
+	}
+
+	return dto.payload[sizeInChunksSize]
+}
+
+// Kinds of resources reported in metadata
+const (
+	fileKind      = "file"
+	directoryKind = "directory"
+)
+
+type resourceMetadataDto struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Size int64  `json:"size"`
+	// Modified is the modification time in milliseconds since the Unix epoch, 0 when the host does not report it
+	Modified int64 `json:"modified,omitempty"`
+	// Contents lists the direct children of a directory. Children carry no size.
+	Contents []resourceMetadataDto `json:"contents,omitempty"`
+}
+
+func (m resourceMetadataDto) modTime() time.Time {
+	if m.Modified == 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(m.Modified)
+}
+
+// newResourceMetadataDto reads MetadataResponse: flags byte followed by the JSON item. Encrypted metadata
+// can not be read by the relay.
+func newResourceMetadataDto(resp []byte) (resourceMetadataDto, error) {
+	if len(resp) < message_types.WebsocketMessageTypeSize+1 {
+		return resourceMetadataDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	flags := resp[message_types.WebsocketMessageTypeSize]
+	if flags&encryptedFlag != 0 {
+		return resourceMetadataDto{}, ws_errors.ResourceNotDownloadableErr
+	}
+
+	var metadata resourceMetadataDto
+	err := json.Unmarshal(resp[message_types.WebsocketMessageTypeSize+1:], &metadata)
+	if err != nil {
+		return resourceMetadataDto{}, ws_errors.InvalidMessageBodyErr
+	}
+
+	return metadata, nil
+}
+
+// newHostError reads the error code from an Error message sent by the host
+func newHostError(resp []byte) error {
+	if len(resp) < message_types.WebsocketMessageTypeSize+2 {
+		return ws_errors.NewHostError(ws_errors.UnknownError)
+	}
+
+	code := helpers.BinaryToUint16(resp[message_types.WebsocketMessageTypeSize : message_types.WebsocketMessageTypeSize+2])
+	return ws_errors.NewHostError(ws_errors.WebsocketErrorCode(code))
+}
@@ -0,0 +1,175 @@
+package host
+
+import (
+	"context"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/google/uuid"
+)
+
+// uploadResumeGracePeriod is how long the host keeps the partial file of a resumable upload after its client
+// has disconnected. Once it passes, the host is told to discard it.
+const uploadResumeGracePeriod = 2 * time.Minute
+
+const committedBytesSize = 8
+
+type resumableUpload struct {
+	hostUuid uuid.UUID
+	hostConn hostconn.HostConn
+	// flow is the flow the queries of the upload are queued in, kept across resumes
+	flow     hostconn.FlowId
+	streamId uint32
+}
+
+// CreateFileResumable works like CreateFile, but right after CreateFileInitResponse the client receives
+// an UploadResumeToken. If the client connection is lost, the host keeps the partial file for the grace period
+// and the upload can be continued with ResumeUpload. Uploads which fail for any other reason are ended on the host,
+// which discards the partial file, and can not be resumed.
+func (s *defaultConnectionService) CreateFileResumable(
+	ctx context.Context,
+	clientConn clientconn.ClientConn,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+) error {
+	hostConn, ok := s.hostMap.Get(hostUuid)
+	if !ok {
+		return ws_errors.HostNotFoundErr
+	}
+
+	limitedConn, release, err := s.startTransfer(ctx, clientConn, hostUuid, uploadDirection)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	flow := hostconn.NewFlowId()
+	createFileInitRespDto, started, err := s.startUploadStream(flowView(ctx, hostConn, hostUuid, flow), limitedConn, resourceUuid, pathToFile, fileSize)
+	if err != nil || !started {
+		return err
+	}
+
+	upload := &resumableUpload{
+		hostUuid: hostUuid,
+		hostConn: hostConn,
+		flow:     flow,
+		streamId: createFileInitRespDto.streamId,
+	}
+	token := s.resumableUploads.add(clientConn.ClientIP(), upload)
+
+	err = limitedConn.Send(message_types.UploadResumeToken.Binary(), helpers.UUIDToBinary(token))
+	if err != nil {
+		return s.finishResumableUpload(limitedConn, token, upload, err)
+	}
+
+	return s.handleResumableUploadLoop(ctx, limitedConn, token, upload)
+}
+
+// ResumeUpload attaches the client to an upload started with CreateFileResumable. The client first receives
+// CreateFileStatusResponse with the number of bytes the host has committed, after which the upload continues
+// with chunk requests from that offset.
+//
+// Uploads can only be resumed on the host connection they were started on, as the partial file does not
+// survive the host reconnecting.
+func (s *defaultConnectionService) ResumeUpload(ctx context.Context, clientConn clientconn.ClientConn, token uuid.UUID) error {
+	upload, err := s.resumableUploads.attach(token)
+	if err != nil {
+		return err
+	}
+
+	limitedConn, release, err := s.startTransfer(ctx, clientConn, upload.hostUuid, uploadDirection)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	hostConn, ok := s.hostMap.Get(upload.hostUuid)
+	if !ok || hostConn != upload.hostConn {
+		s.resumableUploads.remove(token)
+		return ws_errors.InvalidResumeTokenErr
+	}
+
+	statusResp, err := flowView(ctx, hostConn, upload.hostUuid, upload.flow).Query(
+		message_types.CreateFileStatusQuery.Binary(),
+		helpers.Uint32ToBinary(upload.streamId),
+	)
+	if err != nil {
+		return s.finishResumableUpload(limitedConn, token, upload, err)
+	}
+
+	statusRespMsgType, err := message_types.GetMsgType(statusResp)
+	if err != nil {
+		return s.finishResumableUpload(limitedConn, token, upload, err)
+	}
+
+	switch statusRespMsgType {
+	case message_types.CreateFileStatusResponse:
+		if len(statusResp) < message_types.WebsocketMessageTypeSize+committedBytesSize {
+			return s.finishResumableUpload(limitedConn, token, upload, ws_errors.InvalidMessageBodyErr)
+		}
+	case message_types.Error:
+		// The host no longer has the upload
+		s.resumableUploads.remove(token)
+		return limitedConn.Send(statusResp)
+	default:
+		return s.finishResumableUpload(limitedConn, token, upload, ws_errors.UnexpectedMessageTypeErr)
+	}
+
+	err = limitedConn.Send(statusResp)
+	if err != nil {
+		return s.finishResumableUpload(limitedConn, token, upload, err)
+	}
+
+	return s.handleResumableUploadLoop(ctx, limitedConn, token, upload)
+}
+
+func (s *defaultConnectionService) handleResumableUploadLoop(
+	ctx context.Context,
+	clientConn *limitedClientConn,
+	token uuid.UUID,
+	upload *resumableUpload,
+) error {
+	err := s.handleUploadLoop(flowView(ctx, upload.hostConn, upload.hostUuid, upload.flow), clientConn, upload.streamId)
+	return s.finishResumableUpload(clientConn, token, upload, err)
+}
+
+// finishResumableUpload keeps the upload resumable if it has failed because its client connection was lost.
+// Uploads which have been aborted or failed on a host or protocol error are removed and the host is told to
+// discard the partial file.
+func (s *defaultConnectionService) finishResumableUpload(
+	clientConn *limitedClientConn,
+	token uuid.UUID,
+	upload *resumableUpload,
+	err error,
+) error {
+	if err != nil && clientConn.clientLost() {
+		s.resumableUploads.detach(token)
+		return err
+	}
+
+	s.resumableUploads.remove(token)
+	if err != nil {
+		s.expireResumableUpload(upload)
+	}
+
+	return err
+}
+
+func (s *defaultConnectionService) expireResumableUpload(upload *resumableUpload) {
+	_ = s.sendUploadAbortQueryToHost(upload.hostConn, upload.streamId)
+}
+
+// sendUploadAbortQueryToHost tells the host to end the upload stream and discard the partial file
+func (s *defaultConnectionService) sendUploadAbortQueryToHost(hostConn hostconn.HostConn, streamId uint32) error {
+	_, err := hostConn.Query(
+		message_types.CreateFileStreamEnd.Binary(),
+		helpers.Uint32ToBinary(streamId),
+	)
+	return err
+}
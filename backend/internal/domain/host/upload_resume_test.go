@@ -0,0 +1,305 @@
+package host
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func hostChunkPrompt(streamId uint32) [][]byte {
+	return [][]byte{
+		message_types.CreateFileHostChunkRequest.Binary(),
+		helpers.Uint32ToBinary(streamId),
+	}
+}
+
+func chunkRequestAt(offset uint64) []byte {
+	return append(message_types.CreateFileChunkRequest.Binary(), helpers.Uint64ToBinary(offset)...)
+}
+
+// startDroppedResumableUpload starts a resumable upload whose client disconnects while the first chunk
+// is requested from it, and returns the resume token
+func startDroppedResumableUpload(
+	t *testing.T,
+	svc HostService,
+	hostId uuid.UUID,
+	resourceId uuid.UUID,
+	streamId uint32,
+	mockHostConn *hostconn.MockConn,
+) uuid.UUID {
+	t.Helper()
+
+	mockClientConn := &clientconn.MockClientConn{}
+	defer mockClientConn.AssertExpectations(t)
+
+	createFileInitResponse := append(message_types.CreateFileInitResponse.Binary(), helpers.Uint32ToBinary(streamId)...)
+	mockHostConn.On("Query", [][]byte{
+		message_types.CreateFileInitRequest.Binary(),
+		helpers.UUIDToBinary(resourceId),
+		helpers.Uint32ToBinary(1024),
+		[]byte("test.txt\000"),
+	}).Return(createFileInitResponse, nil).Once()
+	mockClientConn.On("Send", [][]byte{createFileInitResponse}).Return(nil).Once()
+
+	var token uuid.UUID
+	mockClientConn.On("Send", mock.MatchedBy(func(msg [][]byte) bool {
+		return len(msg) == 2 && string(msg[0]) == string(message_types.UploadResumeToken.Binary())
+	})).Run(func(args mock.Arguments) {
+		token = uuid.UUID(args.Get(0).([][]byte)[1])
+	}).Return(nil).Once()
+
+	mockHostConn.On("Query", hostChunkPrompt(streamId)).Return(chunkRequestAt(0), nil).Once()
+	mockClientConn.On("Send", [][]byte{chunkRequestAt(0)}).Return(nil).Once()
+	mockClientConn.On("Listen").Return(nil, ws_errors.ConnectionClosedErr).Once()
+	mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil).Once()
+
+	err := svc.CreateFileResumable(context.Background(), mockClientConn, hostId, resourceId, "test.txt", 1024)
+	require.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	require.NotEqual(t, uuid.Nil, token)
+
+	return token
+}
+
+func TestResumableUpload(t *testing.T) {
+	t.Run("success - resume from the committed offset", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableUpload(t, svc, hostId, resourceId, 777, mockHostConn)
+
+		statusResponse := append(message_types.CreateFileStatusResponse.Binary(), helpers.Uint64ToBinary(0)...)
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileStatusQuery.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(statusResponse, nil).Once()
+		mockClientConn.On("Send", [][]byte{statusResponse}).Return(nil).Once()
+
+		// The host asks for the same chunk again
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		mockClientConn.On("Send", [][]byte{chunkRequestAt(0)}).Return(nil).Once()
+		chunk := []byte{1, 2, 3}
+		mockClientConn.On("Listen").Return(chunk, nil).Once()
+		hostCompletionResp := message_types.CreateFileStreamEnd.Binary()
+		onBulkQuery(mockHostConn, [][]byte{chunk}).Return(hostCompletionResp, nil).Once()
+		mockClientConn.On("Send", [][]byte{hostCompletionResp}).Return(nil).Once()
+
+		err := svc.ResumeUpload(context.Background(), mockClientConn, token)
+		assert.NoError(t, err)
+
+		// A completed upload can not be resumed again
+		err = svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("host discards the partial file after the grace period", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		svcImpl := svc.(*defaultConnectionService)
+		svcImpl.resumableUploads = newResumeRegistry(10*time.Millisecond, svcImpl.expireResumableUpload)
+
+		aborted := make(chan struct{})
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileStreamEnd.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Run(func(args mock.Arguments) {
+			close(aborted)
+		}).Return(message_types.ACK.Binary(), nil).Once()
+
+		token := startDroppedResumableUpload(t, svc, hostId, resourceId, 777, mockHostConn)
+
+		select {
+		case <-aborted:
+		case <-time.After(time.Second):
+			t.Fatal("host was not told to discard the upload")
+		}
+
+		err := svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - host has reconnected", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableUpload(t, svc, hostId, resourceId, 777, mockHostConn)
+
+		mockHostMap.On("Get", hostId).Return(&hostconn.MockConn{}, true).Once()
+		err := svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("host error on status query is forwarded to the client", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableUpload(t, svc, hostId, resourceId, 777, mockHostConn)
+
+		hostErrorResp := append(message_types.Error.Binary(), ws_errors.UnknownError.Binary()...)
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileStatusQuery.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(hostErrorResp, nil).Once()
+		mockClientConn.On("Send", [][]byte{hostErrorResp}).Return(nil).Once()
+
+		err := svc.ResumeUpload(context.Background(), mockClientConn, token)
+		assert.NoError(t, err)
+
+		err = svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+	t.Run("error - status query fails, host discards the partial file", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableUpload(t, svc, hostId, resourceId, 777, mockHostConn)
+
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileStatusQuery.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(nil, ws_errors.TimeoutErr).Once()
+		mockHostConn.On("Query", uploadAbortQuery(777)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.TimeoutErr)
+
+		err = svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - malformed host message, host discards the partial file", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableUpload(t, svc, hostId, resourceId, 777, mockHostConn)
+
+		statusResponse := append(message_types.CreateFileStatusResponse.Binary(), helpers.Uint64ToBinary(0)...)
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileStatusQuery.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(statusResponse, nil).Once()
+		mockClientConn.On("Send", [][]byte{statusResponse}).Return(nil).Once()
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return([]byte{1}, nil).Once()
+		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(nil).Once()
+		mockHostConn.On("Query", uploadAbortQuery(777)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeUpload(context.Background(), mockClientConn, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidMessageBodyErr)
+
+		err = svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+
+	t.Run("error - aborted upload, host discards the partial file", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		mockClientConn := &clientconn.MockClientConn{}
+		defer func() {
+			mockHostMap.AssertExpectations(t)
+			mockHostConn.AssertExpectations(t)
+			mockClientConn.AssertExpectations(t)
+		}()
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		token := startDroppedResumableUpload(t, svc, hostId, resourceId, 777, mockHostConn)
+
+		statusResponse := append(message_types.CreateFileStatusResponse.Binary(), helpers.Uint64ToBinary(0)...)
+		mockHostConn.On("Query", [][]byte{
+			message_types.CreateFileStatusQuery.Binary(),
+			helpers.Uint32ToBinary(777),
+		}).Return(statusResponse, nil).Once()
+		mockClientConn.On("Send", [][]byte{statusResponse}).Return(nil).Once()
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		mockClientConn.On("Send", [][]byte{chunkRequestAt(0)}).Return(nil).Once()
+		mockClientConn.On("Close").Return().Once()
+		// Aborting closes the client connection, which ends the pending Listen
+		mockClientConn.On("Listen").Run(func(args mock.Arguments) {
+			require.NoError(t, svc.AbortTransfer(svc.ListTransfers()[0].Id))
+		}).Return(nil, ws_errors.ConnectionClosedErr).Once()
+		mockClientConn.On("Send", [][]byte{message_types.CreateFileStreamEnd.Binary()}).Return(ws_errors.ConnectionClosedErr).Once()
+		mockHostConn.On("Query", uploadAbortQuery(777)).Return(message_types.ACK.Binary(), nil).Once()
+
+		err := svc.ResumeUpload(context.Background(), mockClientConn, token)
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+
+		err = svc.ResumeUpload(context.Background(), &clientconn.MockClientConn{}, token)
+		assert.ErrorIs(t, err, ws_errors.InvalidResumeTokenErr)
+	})
+}
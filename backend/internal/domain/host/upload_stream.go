@@ -0,0 +1,223 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/bandwidth"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/google/uuid"
+)
+
+// ErrUploadBodyTooShort is returned by UploadFile when the body ends before the declared file size has been sent
+var ErrUploadBodyTooShort = errors.New("upload body is shorter than the declared file size")
+
+var errUploadOffsetBehind = errors.New("host requested an already sent part of the upload")
+
+const uploadOffsetSize = 8
+
+// UploadFile creates a file on the host from the body without a client connection. The body is sent in chunks
+// of chunkSize bytes in the order the host requests them. The body can not be rewound, so the host may only skip
+// ahead. On any error the host is told to discard the partial file. Errors reported by the host are returned as
+// host errors carrying its error code. The upload queues its chunks in a flow of its own. Chunks pass the global,
+// client and host upload limits and the upload fails once ctx is done. The upload is listed as a transfer of
+// clientIp and cancel is called when it is aborted, both are empty for uploads made by the relay itself.
+// While the server is draining, uploads for a client fail with ServerShuttingDownErr.
+func (s *defaultConnectionService) UploadFile(
+	ctx context.Context,
+	hostUuid uuid.UUID,
+	resourceUuid uuid.UUID,
+	pathToFile string,
+	fileSize uint64,
+	chunkSize int,
+	body io.Reader,
+	clientIp string,
+	cancel func(),
+) error {
+	if clientIp != "" {
+		if err := s.refuseWhileDraining(); err != nil {
+			return err
+		}
+	}
+
+	hostConn, ok := s.getHostConnOnFlow(ctx, hostUuid, hostconn.NewFlowId())
+	if !ok {
+		return ws_errors.HostNotFoundErr
+	}
+
+	t, clientLimiter, endTransfer := s.startClientlessTransfer(ctx, hostUuid, uploadDirection, clientIp, cancel)
+	defer endTransfer()
+
+	createFileInitQuery, err := newCreateFileInitQuery(hostConn, resourceUuid, pathToFile, fileSize)
+	if err != nil {
+		return err
+	}
+
+	createFileInitResp, err := hostConn.Query(createFileInitQuery...)
+	if err != nil {
+		return err
+	}
+
+	msgType, err := message_types.GetMsgType(createFileInitResp)
+	if err != nil {
+		return err
+	}
+
+	if msgType == message_types.Error {
+		return newHostError(createFileInitResp)
+	}
+
+	createFileInitRespDto, err := newHostStreamInitResponseDto(createFileInitResp)
+	if err != nil {
+		return err
+	}
+
+	upload := uploadBodyStream{
+		service:       s,
+		hostConn:      hostConn,
+		streamId:      createFileInitRespDto.streamId,
+		body:          body,
+		chunkSize:     chunkSize,
+		transfer:      t,
+		clientLimiter: clientLimiter,
+	}
+	err = upload.run()
+	if err != nil {
+		_ = s.sendUploadAbortQueryToHost(hostConn, createFileInitRespDto.streamId)
+		return err
+	}
+
+	return nil
+}
+
+// uploadBodyStream plays the client side of the upload loop, answering host chunk requests from the body
+type uploadBodyStream struct {
+	service       *defaultConnectionService
+	hostConn      hostconn.HostConn
+	streamId      uint32
+	body          io.Reader
+	chunkSize     int
+	transfer      *transfer
+	clientLimiter *bandwidth.Limiter
+
+	// offset is the number of body bytes read so far
+	offset uint64
+}
+
+func (u *uploadBodyStream) run() error {
+	var hostResp []byte
+	var err error
+	for {
+		// Query host for new chunk request, unless the host has already sent it as the acknowledgement of the last batch
+		if hostResp == nil {
+			hostResp, err = u.hostConn.Query(
+				message_types.CreateFileHostChunkRequest.Binary(),
+				helpers.Uint32ToBinary(u.streamId),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		hostRespDto, err := newMsgTypeWithPayloadDto(hostResp)
+		if err != nil {
+			return err
+		}
+
+		credits := uint16(1)
+		switch hostRespDto.msgType {
+		case message_types.CreateFileStreamEnd:
+			return nil
+		case message_types.Error:
+			return newHostError(hostResp)
+		case message_types.CreateFileChunkRequest:
+			if len(hostRespDto.payload) < uploadOffsetSize {
+				return ws_errors.InvalidMessageBodyErr
+			}
+			err = u.skipTo(helpers.BinaryToUint64(hostRespDto.payload[:uploadOffsetSize]))
+		case message_types.CreateFileCreditGrant:
+			credits, err = newCreditGrantDto(hostResp)
+			if err == nil {
+				err = u.skipTo(helpers.BinaryToUint64(hostRespDto.payload[2 : 2+uploadOffsetSize]))
+			}
+		default:
+			return ws_errors.UnexpectedMessageTypeErr
+		}
+		if err != nil {
+			return err
+		}
+
+		ack, err := u.sendChunks(credits)
+		if err != nil {
+			return err
+		}
+
+		ackMsgType, err := message_types.GetMsgType(ack)
+		if err != nil {
+			return err
+		}
+
+		// Anything other than a plain ACK is either the next request or the end of the upload
+		hostResp = nil
+		if ackMsgType != message_types.ACK {
+			hostResp = ack
+		}
+	}
+}
+
+// skipTo moves the body forward to the offset requested by the host
+func (u *uploadBodyStream) skipTo(offset uint64) error {
+	if offset < u.offset {
+		return errUploadOffsetBehind
+	}
+
+	if offset == u.offset {
+		return nil
+	}
+
+	skipped, err := io.CopyN(io.Discard, u.body, int64(offset-u.offset))
+	u.offset += uint64(skipped)
+	if err != nil {
+		return ErrUploadBodyTooShort
+	}
+
+	return nil
+}
+
+// sendChunks sends the given number of chunks to the host and returns the host response to the last one
+func (u *uploadBodyStream) sendChunks(count uint16) ([]byte, error) {
+	chunk := make([]byte, u.chunkSize)
+	for i := uint16(1); ; i++ {
+		n, err := io.ReadFull(u.body, chunk)
+		if n == 0 {
+			return nil, ErrUploadBodyTooShort
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		if err := u.transfer.add(n); err != nil {
+			return nil, err
+		}
+		if err := u.service.waitForBandwidth(u.transfer.ctx, u.transfer.hostUuid, uploadDirection, u.clientLimiter, n); err != nil {
+			return nil, err
+		}
+		u.offset += uint64(n)
+
+		msg := [][]byte{
+			message_types.CreateFileChunk.Binary(),
+			helpers.Uint32ToBinary(u.streamId),
+			chunk[:n],
+		}
+		if i == count {
+			return u.hostConn.QueryWithTimeout(hostconn.DefaultQueryTimeout, hostconn.PriorityBulk, msg...)
+		}
+
+		if err := u.hostConn.Send(msg...); err != nil {
+			return nil, err
+		}
+	}
+}
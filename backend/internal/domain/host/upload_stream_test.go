@@ -0,0 +1,229 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createFileInitQuery(resourceId uuid.UUID, fileSize uint32) [][]byte {
+	return [][]byte{
+		message_types.CreateFileInitRequest.Binary(),
+		helpers.UUIDToBinary(resourceId),
+		helpers.Uint32ToBinary(fileSize),
+		[]byte("test.txt\000"),
+	}
+}
+
+func uploadChunk(streamId uint32, chunk ...byte) [][]byte {
+	return [][]byte{
+		message_types.CreateFileChunk.Binary(),
+		helpers.Uint32ToBinary(streamId),
+		chunk,
+	}
+}
+
+func uploadAbortQuery(streamId uint32) [][]byte {
+	return [][]byte{
+		message_types.CreateFileStreamEnd.Binary(),
+		helpers.Uint32ToBinary(streamId),
+	}
+}
+
+func setUpStreamedUpload(hostId uuid.UUID, resourceId uuid.UUID, fileSize uint32) (*hostmap.MockHostMap, *hostconn.MockConn) {
+	mockHostMap := &hostmap.MockHostMap{}
+	mockHostConn := &hostconn.MockConn{}
+
+	mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+	createFileInitResponse := append(message_types.CreateFileInitResponse.Binary(), helpers.Uint32ToBinary(777)...)
+	mockHostConn.On("Query", createFileInitQuery(resourceId, fileSize)).Return(createFileInitResponse, nil).Once()
+
+	return mockHostMap, mockHostConn
+}
+
+// readerFunc is an io.Reader reading with the function
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestUploadFile(t *testing.T) {
+	t.Run("success - chunks requested one by one", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap, mockHostConn := setUpStreamedUpload(hostId, resourceId, 5)
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		onBulkQuery(mockHostConn, uploadChunk(777, 1, 2, 3)).Return(message_types.ACK.Binary(), nil).Once()
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(3), nil).Once()
+		onBulkQuery(mockHostConn, uploadChunk(777, 4, 5)).Return(message_types.ACK.Binary(), nil).Once()
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(message_types.CreateFileStreamEnd.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.UploadFile(context.Background(), hostId, resourceId, "test.txt", 5, 3, bytes.NewReader([]byte{1, 2, 3, 4, 5}), "", nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("success - credit grant", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap, mockHostConn := setUpStreamedUpload(hostId, resourceId, 5)
+		defer mockHostConn.AssertExpectations(t)
+
+		grant := message_types.CreateFileCreditGrant.Binary()
+		grant = append(grant, helpers.Uint16ToBinary(3)...)
+		grant = append(grant, helpers.Uint64ToBinary(0)...)
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(grant, nil).Once()
+		mockHostConn.On("Send", uploadChunk(777, 1, 2)).Return(nil).Once()
+		mockHostConn.On("Send", uploadChunk(777, 3, 4)).Return(nil).Once()
+		onBulkQuery(mockHostConn, uploadChunk(777, 5)).Return(message_types.CreateFileStreamEnd.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.UploadFile(context.Background(), hostId, resourceId, "test.txt", 5, 2, bytes.NewReader([]byte{1, 2, 3, 4, 5}), "", nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("error - body shorter than declared", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap, mockHostConn := setUpStreamedUpload(hostId, resourceId, 5)
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		onBulkQuery(mockHostConn, uploadChunk(777, 1, 2)).Return(message_types.ACK.Binary(), nil).Once()
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(2), nil).Once()
+		mockHostConn.On("Query", uploadAbortQuery(777)).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.UploadFile(context.Background(), hostId, resourceId, "test.txt", 5, 3, bytes.NewReader([]byte{1, 2}), "", nil)
+		assert.ErrorIs(t, err, ErrUploadBodyTooShort)
+	})
+
+	t.Run("error - upload is listed for the client and aborting it cancels the request", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap, mockHostConn := setUpStreamedUpload(hostId, resourceId, 5)
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		mockHostConn.On("Query", uploadAbortQuery(777)).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		requestCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// The upload is aborted while its first chunk is read from the body
+		body := readerFunc(func(p []byte) (int, error) {
+			transfers := svc.ListTransfers()
+			require.Len(t, transfers, 1)
+			assert.Equal(t, "10.0.0.1", transfers[0].ClientIP)
+			require.NoError(t, svc.AbortTransfer(transfers[0].Id))
+			return copy(p, []byte{1, 2, 3}), nil
+		})
+		err := svc.UploadFile(requestCtx, hostId, resourceId, "test.txt", 5, 3, body, "10.0.0.1", cancel)
+		assert.ErrorIs(t, err, ErrTransferAborted)
+		assert.ErrorIs(t, requestCtx.Err(), context.Canceled)
+		assert.Empty(t, svc.ListTransfers())
+	})
+
+	t.Run("error - chunks waiting for bandwidth fail once the request is gone", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap, mockHostConn := setUpStreamedUpload(hostId, resourceId, 5)
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		mockHostConn.On("Query", uploadAbortQuery(777)).Return(message_types.ACK.Binary(), nil).Once()
+
+		// The chunk is over the global limit of 1 byte per second
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{Global: 1}, testChunkSize)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := svc.UploadFile(ctx, hostId, resourceId, "test.txt", 5, 3, bytes.NewReader([]byte{1, 2, 3, 4, 5}), "", nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("error - host requests an already sent offset", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap, mockHostConn := setUpStreamedUpload(hostId, resourceId, 5)
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(chunkRequestAt(0), nil).Once()
+		onBulkQuery(mockHostConn, uploadChunk(777, 1, 2, 3)).Return(chunkRequestAt(0), nil).Once()
+		mockHostConn.On("Query", uploadAbortQuery(777)).Return(message_types.ACK.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.UploadFile(context.Background(), hostId, resourceId, "test.txt", 5, 3, bytes.NewReader([]byte{1, 2, 3, 4, 5}), "", nil)
+		assert.ErrorIs(t, err, errUploadOffsetBehind)
+	})
+
+	t.Run("success - 64-bit init request for hosts with large file support", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{HostCapabilities: hostconn.CapabilityLargeFiles}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		createFileInitQuery64 := [][]byte{
+			message_types.CreateFileInitRequest64.Binary(),
+			helpers.UUIDToBinary(resourceId),
+			helpers.Uint64ToBinary(5),
+			[]byte("test.txt\000"),
+		}
+		createFileInitResponse := append(message_types.CreateFileInitResponse.Binary(), helpers.Uint32ToBinary(777)...)
+		mockHostConn.On("Query", createFileInitQuery64).Return(createFileInitResponse, nil).Once()
+		mockHostConn.On("Query", hostChunkPrompt(777)).Return(message_types.CreateFileStreamEnd.Binary(), nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.UploadFile(context.Background(), hostId, resourceId, "test.txt", 5, 3, bytes.NewReader([]byte{1, 2, 3, 4, 5}), "", nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("error - file too large for host without large file support", func(t *testing.T) {
+		hostId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.UploadFile(context.Background(), hostId, uuid.New(), "test.txt", math.MaxUint32+1, 3, bytes.NewReader(nil), "", nil)
+		assert.ErrorIs(t, err, ws_errors.FileTooLargeForHostErr)
+	})
+
+	t.Run("error - host refuses the upload", func(t *testing.T) {
+		hostId := uuid.New()
+		resourceId := uuid.New()
+		mockHostMap := &hostmap.MockHostMap{}
+		mockHostConn := &hostconn.MockConn{}
+		defer mockHostConn.AssertExpectations(t)
+
+		mockHostMap.On("Get", hostId).Return(mockHostConn, true)
+		hostErrorResp := append(message_types.Error.Binary(), ws_errors.OperationForbidden.Binary()...)
+		mockHostConn.On("Query", createFileInitQuery(resourceId, 5)).Return(hostErrorResp, nil).Once()
+
+		svc := NewHostService(mockHostMap, &saved_connections_repository.MockSavedConnectionsRepository{}, &file_index_repository.MockFileIndexRepository{}, BandwidthLimits{}, testChunkSize)
+		err := svc.UploadFile(context.Background(), hostId, resourceId, "test.txt", 5, 3, bytes.NewReader([]byte{1, 2, 3, 4, 5}), "", nil)
+
+		var wsErr ws_errors.WebsocketError
+		require.ErrorAs(t, err, &wsErr)
+		assert.Equal(t, ws_errors.OperationForbidden, wsErr.Code())
+	})
+}
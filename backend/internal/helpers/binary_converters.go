@@ -26,6 +26,16 @@ func BinaryToUint32(data []byte) uint32 {
 	return binary.BigEndian.Uint32(data)
 }
 
+func Uint64ToBinary(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func BinaryToUint64(data []byte) uint64 {
+	return binary.BigEndian.Uint64(data)
+}
+
 func UUIDToBinary(u uuid.UUID) []byte {
 	b := make([]byte, 16)
 	copy(b, u[:])
@@ -65,3 +65,34 @@ func TestUint32ToBytesBE(t *testing.T) {
 		})
 	}
 }
+
+func TestUint64ToBytesBE(t *testing.T) {
+	tests := []struct {
+		name string
+		v    uint64
+		want string // hex
+	}{
+		{"zero", 0x0000000000000000, "0000000000000000"},
+		{"one", 1, "0000000000000001"},
+		{"above uint32", 0x100000000, "0000000100000000"},
+		{"mid", 0x1122334455667788, "1122334455667788"},
+		{"max", 0xFFFFFFFFFFFFFFFF, "ffffffffffffffff"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := Uint64ToBinary(tc.v)
+			if len(b) != 8 {
+				t.Fatalf("len = %d; want 8", len(b))
+			}
+
+			if hex.EncodeToString(b) != tc.want {
+				t.Fatalf("hex = %s; want %s", hex.EncodeToString(b), tc.want)
+			}
+
+			if got := BinaryToUint64(b); got != tc.v {
+				t.Fatalf("roundtrip = %d; want %d", got, tc.v)
+			}
+		})
+	}
+}
@@ -2,15 +2,21 @@ package appcontainer
 
 import (
 	"context"
+	"os"
+	"time"
 
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
 
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/app/config"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/db"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/tracing"
 )
 
 const (
@@ -27,7 +33,11 @@ type Container struct {
 	HostService     host.HostService
 	Db              db.SqlDatabaseInterface
 
-	ClientConnFactory clientconn.ClientConnFactory
+	ClientConnFactory    clientconn.ClientConnFactory
+	ClientSessionFactory clientsession.ClientSessionFactory
+
+	// ShutdownTracing exports the remaining spans, it does nothing when tracing is disabled
+	ShutdownTracing func(ctx context.Context) error
 }
 
 func NewContainer(ctx context.Context) (*Container, error) {
@@ -37,30 +47,59 @@ func NewContainer(ctx context.Context) (*Container, error) {
 	}
 	cfg := config.Get()
 
+	err = logging.Setup(os.Stdout, cfg.Logging.Format, cfg.Logging.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownTracing := func(ctx context.Context) error { return nil }
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err = tracing.Setup(ctx, cfg.Tracing.OtlpEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	database, err := db.NewSqlDatabase(ctx, cfg.Database.SqlDriver, cfg.Database.DataSourcePath, cfg.Database.MigrationsPath)
 	savedConnectionsRepository := saved_connections_repository.NewSavedConnectionsRepository(
 		database,
 		cfg.SavedConnections,
 	)
+	fileIndexRepository := file_index_repository.NewFileIndexRepository(database)
 
-	hostConnFactory := &hostconn.DefaultHostConnFactory{}
+	pingInterval := time.Duration(cfg.Websocket.PingIntervalSeconds) * time.Second
+	pongTimeout := time.Duration(cfg.Websocket.PongTimeoutSeconds) * time.Second
+
+	hostConnFactory := &hostconn.DefaultHostConnFactory{
+		PingInterval:          pingInterval,
+		PongTimeout:           pongTimeout,
+		MaxPendingQueries:     cfg.Host.MaxPendingQueries,
+		MaxFlowPendingQueries: cfg.Host.MaxFlowPendingQueries,
+	}
 	hostMap := hostmap.NewDefaultHostMap(ctx, hostConnFactory)
 
-	clientConnFactory := &clientconn.DefaultClientConnFactory{}
+	clientConnFactory := &clientconn.DefaultClientConnFactory{PingInterval: pingInterval, PongTimeout: pongTimeout}
+	clientSessionFactory := &clientsession.DefaultClientSessionFactory{PingInterval: pingInterval, PongTimeout: pongTimeout}
 
-	hostService := host.NewHostService(hostMap, savedConnectionsRepository)
+	hostService := host.NewHostService(hostMap, savedConnectionsRepository, fileIndexRepository, host.BandwidthLimits{
+		Global:    int64(cfg.Bandwidth.GlobalBytesPerSecond),
+		PerClient: int64(cfg.Bandwidth.ClientBytesPerSecond),
+	}, cfg.Websocket.BatchSize)
+	hostService = host.NewTracedHostService(hostService)
 
 	if err != nil {
 		return nil, err
 	}
 
 	container := Container{
-		Config:            *cfg,
-		HostConnFactory:   hostConnFactory,
-		HostMap:           hostMap,
-		HostService:       hostService,
-		Db:                database,
-		ClientConnFactory: clientConnFactory,
+		Config:               *cfg,
+		HostConnFactory:      hostConnFactory,
+		HostMap:              hostMap,
+		HostService:          hostService,
+		Db:                   database,
+		ClientConnFactory:    clientConnFactory,
+		ClientSessionFactory: clientSessionFactory,
+		ShutdownTracing:      shutdownTracing,
 	}
 	return &container, nil
 }
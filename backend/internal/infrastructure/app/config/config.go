@@ -16,10 +16,73 @@ const envTag = "env"
 
 type ServerCfg struct {
 	Port int `env:"PORT"`
+	// TrustedProxies are the comma separated IP addresses and CIDR ranges of the reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers tell the client IP address, "none" to trust no proxy
+	TrustedProxies string `env:"TRUSTED_PROXIES"`
 }
 
 type WebsocketCfg struct {
 	BatchSize int `env:"BATCH_SIZE"`
+	// PingIntervalSeconds is how often host and client sockets are pinged, 0 disables pings
+	PingIntervalSeconds int `env:"WEBSOCKET_PING_INTERVAL_SECONDS"`
+	// PongTimeoutSeconds is how long a peer may stay silent after a ping is due before it is considered dead
+	PongTimeoutSeconds int `env:"WEBSOCKET_PONG_TIMEOUT_SECONDS"`
+}
+
+type HostCfg struct {
+	// MaxPendingQueries is how many queries may wait for a host at once before it is reported saturated, 0 means no limit
+	MaxPendingQueries int `env:"HOST_MAX_PENDING_QUERIES"`
+	// MaxFlowPendingQueries is how many of them a single download, upload or client session may hold, 0 means no limit
+	MaxFlowPendingQueries int `env:"HOST_MAX_FLOW_PENDING_QUERIES"`
+}
+
+type BandwidthCfg struct {
+	// GlobalBytesPerSecond limits all file data passing the relay, 0 means no limit
+	GlobalBytesPerSecond int `env:"BANDWIDTH_GLOBAL_BYTES_PER_SECOND"`
+	// ClientBytesPerSecond limits the file data of a single client IP address, 0 means no limit
+	ClientBytesPerSecond int `env:"BANDWIDTH_CLIENT_BYTES_PER_SECOND"`
+}
+
+type RateLimitCfg struct {
+	// HostRegistrationsPerMinute limits new hosts connecting from a single IP address, 0 means no limit
+	HostRegistrationsPerMinute int `env:"RATE_LIMIT_HOST_REGISTRATIONS_PER_MINUTE"`
+	// FailedReconnectsPerMinute limits failed host reconnects from a single IP address, 0 means no limit
+	FailedReconnectsPerMinute int `env:"RATE_LIMIT_FAILED_RECONNECTS_PER_MINUTE"`
+	// ClientRequestsPerMinute limits all other requests of a single IP address, 0 means no limit
+	ClientRequestsPerMinute int `env:"RATE_LIMIT_CLIENT_REQUESTS_PER_MINUTE"`
+}
+
+type ShutdownCfg struct {
+	// DrainTimeoutSeconds is how long running transfers may go on after a shutdown has been requested
+	DrainTimeoutSeconds int `env:"SHUTDOWN_DRAIN_TIMEOUT_SECONDS"`
+	// HostReconnectAfterSeconds is how long hosts are told to wait before reconnecting once disconnected
+	HostReconnectAfterSeconds int `env:"SHUTDOWN_HOST_RECONNECT_AFTER_SECONDS"`
+}
+
+type AdminCfg struct {
+	// Port is the port of the admin API listener, 0 disables the admin API
+	Port int `env:"ADMIN_PORT"`
+	// Token is the bearer token required by every admin API request
+	Token string `env:"ADMIN_TOKEN"`
+}
+
+type MetricsCfg struct {
+	// Enabled serves the Prometheus metrics at /metrics on the server port
+	Enabled bool `env:"METRICS_ENABLED"`
+}
+
+type LoggingCfg struct {
+	// Format is the format of the log output, "text" or "json"
+	Format string `env:"LOG_FORMAT"`
+	// Level is the lowest level logged, "debug", "info", "warn" or "error"
+	Level string `env:"LOG_LEVEL"`
+}
+
+type TracingCfg struct {
+	// Enabled exports OpenTelemetry spans to the collector at OtlpEndpoint
+	Enabled bool `env:"TRACING_ENABLED"`
+	// OtlpEndpoint is the URL of the OTLP/HTTP receiver of the collector, e.g. "http://localhost:4318"
+	OtlpEndpoint string `env:"TRACING_OTLP_ENDPOINT"`
 }
 
 type FrontendCfg struct {
@@ -32,10 +95,16 @@ type FrontendCfg struct {
 	HostConnectWSURL                  string `env:"FRONTEND_HOST_CONNECT_WS_URL" json:"host_connect_ws_url"`
 	HostReconnectWSURLTemplate        string `env:"FRONTEND_HOST_RECONNECT_WS_URL_TEMPLATE" json:"host_reconnect_ws_url_template"`
 	ClientMetadataWSURLTemplate       string `env:"FRONTEND_CLIENT_CONNECT_WS_URL_TEMPLATE" json:"client_metadata_ws_url_template"`
+	ClientListWSURLTemplate           string `env:"FRONTEND_CLIENT_LIST_WS_URL_TEMPLATE" json:"client_list_ws_url_template"`
 	ClientDownloadWSURLTemplate       string `env:"FRONTEND_CLIENT_DOWNLOAD_WS_URL_TEMPLATE" json:"client_download_ws_url_template"`
 	ClientCreateDirWSURLTemplate      string `env:"FRONTEND_CLIENT_CREATE_DIR_WS_URL_TEMPLATE" json:"client_create_dir_ws_url_template"`
 	ClientDeleteResourceWSURLTemplate string `env:"FRONTEND_CLIENT_DELETE_RESOURCE_WS_URL_TEMPLATE" json:"client_delete_resource_ws_url_template"`
+	ClientMoveResourceWSURLTemplate   string `env:"FRONTEND_CLIENT_MOVE_RESOURCE_WS_URL_TEMPLATE" json:"client_move_resource_ws_url_template"`
+	ClientCopyResourceWSURLTemplate   string `env:"FRONTEND_CLIENT_COPY_RESOURCE_WS_URL_TEMPLATE" json:"client_copy_resource_ws_url_template"`
 	ClientCreateFileWSURLTemplate     string `env:"FRONTEND_CLIENT_CREATE_FILE_WS_URL_TEMPLATE" json:"client_create_file_ws_url_template"`
+	ClientSessionWSURLTemplate        string `env:"FRONTEND_CLIENT_SESSION_WS_URL_TEMPLATE" json:"client_session_ws_url_template"`
+	ClientDownloadResumeWSURLTemplate string `env:"FRONTEND_CLIENT_DOWNLOAD_RESUME_WS_URL_TEMPLATE" json:"client_download_resume_ws_url_template"`
+	ClientUploadResumeWSURLTemplate   string `env:"FRONTEND_CLIENT_UPLOAD_RESUME_WS_URL_TEMPLATE" json:"client_upload_resume_ws_url_template"`
 
 	// Cryptography
 	PBKDF2Iterations int `env:"FRONTEND_PBKDF2_ITERATIONS" json:"pbkdf2_iterations"`
@@ -57,6 +126,14 @@ type DatabaseCfg struct {
 type Config struct {
 	Server           ServerCfg
 	Websocket        WebsocketCfg
+	Host             HostCfg
+	Bandwidth        BandwidthCfg
+	RateLimit        RateLimitCfg
+	Shutdown         ShutdownCfg
+	Admin            AdminCfg
+	Metrics          MetricsCfg
+	Logging          LoggingCfg
+	Tracing          TracingCfg
 	Frontend         FrontendCfg
 	SavedConnections SavedConnectionsCfg
 	Database         DatabaseCfg
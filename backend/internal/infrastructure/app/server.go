@@ -2,22 +2,38 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/controllers/admin"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/controllers/config"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/controllers/host"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/controllers/ping"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/app/appcontainer"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/ratelimit"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/tracing"
 	"github.com/gin-gonic/gin"
 )
 
-const frontendBuildLocation = "../frontend/build/client/"
+const (
+	frontendBuildLocation = "../frontend/build/client/"
+
+	minAdminTokenLength = 16
+
+	// noTrustedProxies is the TRUSTED_PROXIES value trusting no proxy
+	noTrustedProxies = "none"
+)
 
 type Server struct {
-	container *appcontainer.Container
-	engine    *gin.Engine
+	container  *appcontainer.Container
+	engine     *gin.Engine
+	httpServer *http.Server
+	// adminServer serves the admin API on its own port, nil when it is disabled
+	adminServer *http.Server
 }
 
 func NewServer(ctx context.Context) (*Server, error) {
@@ -29,18 +45,90 @@ func NewServer(ctx context.Context) (*Server, error) {
 	}
 	server.container = container
 
-	server.engine = server.setUpRoutes()
+	server.engine, err = server.setUpRoutes()
+	if err != nil {
+		return nil, err
+	}
+	server.httpServer = &http.Server{
+		Addr:    fmt.Sprintf(":%d", container.Config.Server.Port),
+		Handler: server.engine,
+	}
+
+	adminCfg := container.Config.Admin
+	if adminCfg.Port != 0 {
+		if len(adminCfg.Token) < minAdminTokenLength {
+			return nil, fmt.Errorf("ADMIN_TOKEN has to be at least %d characters long", minAdminTokenLength)
+		}
+
+		adminRouter, err := server.setUpAdminRoutes()
+		if err != nil {
+			return nil, err
+		}
+		server.adminServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", adminCfg.Port),
+			Handler: adminRouter,
+		}
+	}
 
 	return &server, nil
 }
 
+// ListenAndServe serves requests, and admin API requests when enabled, until Shutdown is called. It returns the
+// first error of either listener.
 func (s *Server) ListenAndServe() error {
-	return s.engine.Run(fmt.Sprintf(":%d", s.container.Config.Server.Port))
+	errs := make(chan error, 2)
+	go func() {
+		errs <- listenAndServe(s.httpServer)
+	}()
+
+	if s.adminServer == nil {
+		return <-errs
+	}
+
+	go func() {
+		errs <- listenAndServe(s.adminServer)
+	}()
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return <-errs
+}
+
+func listenAndServe(server *http.Server) error {
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-func (s *Server) setUpRoutes() *gin.Engine {
+// newRouter creates a router taking the client IP address from the forwarding headers of the trusted proxies only,
+// so that clients can not pass as someone else to the rate limits by sending the headers themselves
+func newRouter(trustedProxies string) (*gin.Engine, error) {
+	router := gin.New()
+
+	var proxies []string
+	if trustedProxies != noTrustedProxies {
+		for _, proxy := range strings.Split(trustedProxies, ",") {
+			proxies = append(proxies, strings.TrimSpace(proxy))
+		}
+	}
+
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	return router, nil
+}
+
+func (s *Server) setUpRoutes() (*gin.Engine, error) {
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
+	router, err := newRouter(s.container.Config.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(gin.Recovery(), tracing.Middleware, logging.Middleware)
 
 	api := router.Group("api")
 
@@ -55,12 +143,18 @@ func (s *Server) setUpRoutes() *gin.Engine {
 
 	hostGroup := v1.Group("host")
 	hostConnectController := host.Controller{
-		HostService:       s.container.HostService,
-		WebsocketCfg:      s.container.Config.Websocket,
-		ClientConnFactory: s.container.ClientConnFactory,
+		HostService:          s.container.HostService,
+		WebsocketCfg:         s.container.Config.Websocket,
+		ClientConnFactory:    s.container.ClientConnFactory,
+		ClientSessionFactory: s.container.ClientSessionFactory,
+		RateLimits:           s.hostRateLimits(),
 	}
 	hostConnectController.SetUpRoutes(hostGroup)
 
+	if s.container.Config.Metrics.Enabled {
+		router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	}
+
 	// Serving the frontend
 	router.StaticFS("/assets", http.Dir(frontendBuildLocation+"assets"))
 	router.StaticFile("/favicon.ico", frontendBuildLocation+"favicon.ico")
@@ -73,5 +167,40 @@ func (s *Server) setUpRoutes() *gin.Engine {
 		c.File(frontendBuildLocation + "index.html")
 	})
 
-	return router
+	return router, nil
+}
+
+func (s *Server) setUpAdminRoutes() (*gin.Engine, error) {
+	router, err := newRouter(s.container.Config.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(gin.Recovery(), logging.Middleware)
+
+	adminGroup := router.Group("api/v1/admin")
+	adminController := admin.Controller{
+		HostService: s.container.HostService,
+		Token:       s.container.Config.Admin.Token,
+	}
+	adminController.SetUpRoutes(adminGroup)
+
+	return router, nil
+}
+
+// hostRateLimits creates the rate limits of the host routes, limits set to 0 are left out
+func (s *Server) hostRateLimits() host.RateLimits {
+	cfg := s.container.Config.RateLimit
+	var limits host.RateLimits
+
+	if limiter := ratelimit.NewLimiter(cfg.HostRegistrationsPerMinute); limiter != nil {
+		limits.HostRegistration = ratelimit.Middleware(limiter)
+	}
+	if limiter := ratelimit.NewLimiter(cfg.FailedReconnectsPerMinute); limiter != nil {
+		limits.FailedReconnect = ratelimit.FailureMiddleware(limiter)
+	}
+	if limiter := ratelimit.NewLimiter(cfg.ClientRequestsPerMinute); limiter != nil {
+		limits.ClientRequest = ratelimit.Middleware(limiter)
+	}
+
+	return limits
 }
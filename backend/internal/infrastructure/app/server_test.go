@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRouter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRateLimitedRouter := func(t *testing.T, trustedProxies string) *gin.Engine {
+		router, err := newRouter(trustedProxies)
+		require.NoError(t, err)
+		router.GET("/limited", ratelimit.Middleware(ratelimit.NewLimiter(1)), func(ctx *gin.Context) {
+			ctx.String(http.StatusOK, ctx.ClientIP())
+		})
+		return router
+	}
+
+	request := func(router *gin.Engine, remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("X-Real-IP", forwardedFor)
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("success - spoofed X-Forwarded-For does not reset the rate limit", func(t *testing.T) {
+		router := newRateLimitedRouter(t, noTrustedProxies)
+
+		resp := request(router, "203.0.113.5:1234", "198.51.100.1")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "203.0.113.5", resp.Body.String())
+
+		resp = request(router, "203.0.113.5:1234", "198.51.100.2")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	})
+
+	t.Run("success - clients behind a trusted proxy are limited one by one", func(t *testing.T) {
+		router := newRateLimitedRouter(t, "10.0.0.0/8, 192.168.1.1")
+
+		resp := request(router, "10.0.0.2:1234", "198.51.100.1")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "198.51.100.1", resp.Body.String())
+
+		assert.Equal(t, http.StatusOK, request(router, "192.168.1.1:1234", "198.51.100.2").Code)
+		assert.Equal(t, http.StatusTooManyRequests, request(router, "10.0.0.2:1234", "198.51.100.2").Code)
+
+		// Headers of anyone else are ignored
+		resp = request(router, "203.0.113.5:1234", "198.51.100.3")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "203.0.113.5", resp.Body.String())
+	})
+
+	t.Run("error - invalid proxy", func(t *testing.T) {
+		_, err := newRouter("10.0.0.0/33")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Shutdown stops the server gracefully. New connections and new downloads and uploads are refused at once and hosts
+// are told that the server is going away. Running downloads and uploads, over plain HTTP and WebSockets, may finish
+// for up to the drain timeout or until ctx is done; idle client connections and sessions are not waited for. Then
+// running transfers are aborted, hosts are disconnected, the index updates already queued are stored within what is
+// left of the drain timeout, the database is closed and the remaining spans are exported. The admin API is served
+// until the end, so running transfers can still be looked at and aborted while draining.
+func (s *Server) Shutdown(ctx context.Context) error {
+	cfg := s.container.Config.Shutdown
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.DrainTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	s.container.HostService.StartDraining()
+
+	announced := make(chan struct{})
+	go func() {
+		defer close(announced)
+		s.container.HostService.AnnounceShutdown(time.Duration(cfg.HostReconnectAfterSeconds) * time.Second)
+	}()
+
+	// Plain HTTP requests are waited for by the http.Server, WebSocket ones are not once upgraded
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		_ = s.httpServer.Close()
+	}
+
+	if !s.container.HostService.WaitForTransfers(ctx) {
+		slog.Warn("drain timeout reached, aborting running transfers", "transfers", len(s.container.HostService.ListTransfers()))
+	}
+	<-announced
+
+	s.container.HostService.DisconnectAll()
+	s.container.HostService.StopIndexUpdates(ctx)
+
+	if s.adminServer != nil {
+		err = errors.Join(err, s.adminServer.Close())
+	}
+
+	return errors.Join(err, s.container.Db.Close(), s.container.ShutdownTracing(context.WithoutCancel(ctx)))
+}
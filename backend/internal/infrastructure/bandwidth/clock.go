@@ -0,0 +1,84 @@
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is what limiters refill their buckets by and wait for the bytes over their rate with
+type Clock interface {
+	Now() time.Time
+	// Sleep blocks for d or until ctx is done, in which case it returns the error of ctx
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// SystemClock is the real time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ManualClock is a Clock for tests. Its time moves only when it is advanced or something sleeps on it, so delays
+// can be checked exactly and without waiting for them.
+type ManualClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func NewManualClock() *ManualClock {
+	return &ManualClock{now: time.Unix(0, 0)}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Sleep moves the time forward by d at once, unless ctx is already done
+func (c *ManualClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.slept += d
+	return nil
+}
+
+// Advance moves the time forward by d, as if it has passed without anything waiting
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Slept returns the total time slept on the clock
+func (c *ManualClock) Slept() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.slept
+}
@@ -0,0 +1,67 @@
+package bandwidth
+
+import "sync"
+
+// Group hands out limiters by key, all with the same rate, so everything done under one key shares a limit.
+// A limiter is kept only while someone holds it.
+type Group struct {
+	rate  int64
+	clock Clock
+
+	mu       sync.Mutex
+	limiters map[string]*groupEntry
+}
+
+type groupEntry struct {
+	limiter *Limiter
+	holders int
+}
+
+// NewGroup creates a group of limiters with the given rate in bytes per second, 0 means no limit. The limiters go by
+// the clock, SystemClock when nil.
+func NewGroup(bytesPerSecond int64, clock Clock) *Group {
+	return &Group{
+		rate:     bytesPerSecond,
+		clock:    clock,
+		limiters: make(map[string]*groupEntry),
+	}
+}
+
+// Acquire returns the limiter of the key and the function releasing it, which has to be called once the limiter
+// is no longer used. A group without a limit returns a nil limiter.
+func (g *Group) Acquire(key string) (*Limiter, func()) {
+	if g.rate <= 0 {
+		return nil, func() {}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	entry, ok := g.limiters[key]
+	if !ok {
+		entry = &groupEntry{limiter: NewLimiter(g.rate, g.clock)}
+		g.limiters[key] = entry
+	}
+	entry.holders++
+
+	var once sync.Once
+	return entry.limiter, func() {
+		once.Do(func() {
+			g.release(key)
+		})
+	}
+}
+
+func (g *Group) release(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	entry, ok := g.limiters[key]
+	if !ok {
+		return
+	}
+
+	if entry.holders--; entry.holders <= 0 {
+		delete(g.limiters, key)
+	}
+}
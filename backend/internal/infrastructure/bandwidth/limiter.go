@@ -0,0 +1,107 @@
+// Package bandwidth limits the rate at which bytes are relayed with token buckets.
+//
+// A Limiter lets a burst of up to one second of its rate pass at once. Bytes passing an empty bucket put it into
+// debt, and the next ones wait until the debt has been paid off, so transfers of any size average out at the rate.
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket limiting the rate of bytes passing through it. A nil Limiter and a Limiter with
+// a rate of 0 let everything through.
+type Limiter struct {
+	mu sync.Mutex
+	// rate is in bytes per second, also the size of the bucket
+	rate   float64
+	tokens float64
+	last   time.Time
+	clock  Clock
+}
+
+// NewLimiter creates a limiter with the given rate in bytes per second, 0 means no limit. It goes by the clock,
+// SystemClock when nil.
+func NewLimiter(bytesPerSecond int64, clock Clock) *Limiter {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   clock.Now(),
+		clock:  clock,
+	}
+}
+
+// SetRate changes the rate of the limiter, 0 removes the limit. Transfers already waiting keep their delay.
+func (l *Limiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(l.clock.Now())
+	l.rate = float64(bytesPerSecond)
+	l.tokens = min(l.tokens, l.rate)
+}
+
+// Rate returns the rate of the limiter in bytes per second, 0 if it has no limit
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int64(l.rate)
+}
+
+// reserve takes n bytes from the bucket and returns how long they have to wait before passing
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.refill(l.clock.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	if elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed*l.rate, l.rate)
+	}
+}
+
+// Wait takes n bytes from every limiter and blocks until all of them let the bytes pass, or ctx is done.
+// Nil limiters are skipped. It sleeps on the clock of the limiter making the bytes wait the longest.
+func Wait(ctx context.Context, n int, limiters ...*Limiter) error {
+	var delay time.Duration
+	var clock Clock
+	for _, l := range limiters {
+		if d := l.reserve(n); d > delay {
+			delay = d
+			clock = l.clock
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	return clock.Sleep(ctx, delay)
+}
@@ -0,0 +1,111 @@
+package bandwidth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLimiterLetsBurstThrough(t *testing.T) {
+	clock := NewManualClock()
+	limiter := NewLimiter(10_000, clock)
+
+	require.NoError(t, Wait(context.Background(), 10_000, limiter))
+	assert.Equal(t, time.Duration(0), clock.Slept())
+}
+
+func TestLimiterDelaysBytesOverRate(t *testing.T) {
+	clock := NewManualClock()
+	limiter := NewLimiter(10_000, clock)
+	require.NoError(t, Wait(context.Background(), 10_000, limiter))
+
+	// The bucket is empty, 1000 bytes take 100ms
+	require.NoError(t, Wait(context.Background(), 1_000, limiter))
+	assert.Equal(t, 100*time.Millisecond, clock.Slept())
+}
+
+func TestLimiterRefillsOverTime(t *testing.T) {
+	clock := NewManualClock()
+	limiter := NewLimiter(10_000, clock)
+	require.NoError(t, Wait(context.Background(), 10_000, limiter))
+
+	// Half a second refills half of the bucket, the bucket never holds more than a second of the rate
+	clock.Advance(500 * time.Millisecond)
+	require.NoError(t, Wait(context.Background(), 5_000, limiter))
+	assert.Equal(t, time.Duration(0), clock.Slept())
+
+	clock.Advance(time.Hour)
+	require.NoError(t, Wait(context.Background(), 11_000, limiter))
+	assert.Equal(t, 100*time.Millisecond, clock.Slept())
+}
+
+func TestWaitUsesSlowestLimiter(t *testing.T) {
+	clock := NewManualClock()
+	fast := NewLimiter(1_000_000, clock)
+	slow := NewLimiter(10_000, clock)
+	require.NoError(t, Wait(context.Background(), 10_000, fast, slow))
+
+	require.NoError(t, Wait(context.Background(), 1_000, fast, nil, slow))
+	assert.Equal(t, 100*time.Millisecond, clock.Slept())
+}
+
+func TestWaitWithoutLimits(t *testing.T) {
+	clock := NewManualClock()
+	var nilLimiter *Limiter
+	unlimited := NewLimiter(0, clock)
+
+	require.NoError(t, Wait(context.Background(), 1<<30, nilLimiter, unlimited))
+	assert.Equal(t, time.Duration(0), clock.Slept())
+	assert.Equal(t, int64(0), nilLimiter.Rate())
+}
+
+func TestWaitCancelled(t *testing.T) {
+	limiter := NewLimiter(1_000, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// The bytes over the limit would wait for 9 seconds
+	err := Wait(ctx, 10_000, limiter)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSetRate(t *testing.T) {
+	clock := NewManualClock()
+	limiter := NewLimiter(0, clock)
+	limiter.SetRate(10_000)
+	assert.Equal(t, int64(10_000), limiter.Rate())
+
+	// The bucket starts empty when a limit is set on an unlimited limiter
+	require.NoError(t, Wait(context.Background(), 1_000, limiter))
+	assert.Equal(t, 100*time.Millisecond, clock.Slept())
+
+	limiter.SetRate(0)
+	require.NoError(t, Wait(context.Background(), 1<<30, limiter))
+	assert.Equal(t, 100*time.Millisecond, clock.Slept())
+}
+
+func TestGroupSharesLimiterPerKey(t *testing.T) {
+	group := NewGroup(1_000, nil)
+
+	first, releaseFirst := group.Acquire("10.0.0.1")
+	second, releaseSecond := group.Acquire("10.0.0.1")
+	other, releaseOther := group.Acquire("10.0.0.2")
+	defer releaseOther()
+
+	assert.Same(t, first, second)
+	assert.NotSame(t, first, other)
+
+	releaseFirst()
+	releaseFirst()
+	assert.Len(t, group.limiters, 2)
+
+	releaseSecond()
+	assert.Len(t, group.limiters, 1)
+
+	unlimited, release := NewGroup(0, nil).Acquire("10.0.0.1")
+	defer release()
+	assert.Nil(t, unlimited)
+}
@@ -2,11 +2,16 @@ package clientconn
 
 import (
 	"fmt"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
 	"github.com/gorilla/websocket"
 	"io"
-	"log"
+	"log/slog"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,18 +19,53 @@ type ClientConn interface {
 	Send(payload ...[]byte) error
 	SendAndLogError(payload ...[]byte)
 	Listen() ([]byte, error)
+	// RTT returns the round trip time measured with the last ping the client has answered, 0 until it has answered one
+	// or when pings are disabled. Pongs are only read while Listen waits for a message.
+	RTT() time.Duration
+	// ClientIP returns the IP address the client has connected from
+	ClientIP() string
 	Close()
 }
 
+const (
+	pingPayloadSize  = 8
+	pingWriteTimeout = 10 * time.Second
+)
+
+// defaultClientConn is used by one handler at a time, which calls Send and Listen itself. When pings are enabled
+// the ping goroutine pings the client periodically, and closes the connection when the client has not answered
+// within pingInterval + pongTimeout while Listen has been waiting.
 type defaultClientConn struct {
-	ws      *websocket.Conn
-	timeout time.Duration
+	ws       *websocket.Conn
+	clientIp string
+	timeout  time.Duration
+	logger   *slog.Logger
+
+	startedAt time.Time
+	rtt       atomic.Int64
+	// lastPong is the time elapsed since startedAt when the last pong has arrived or Listen has started waiting
+	lastPong  atomic.Int64
+	listening atomic.Bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
 }
 
 func (c *defaultClientConn) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
 	_ = c.ws.Close()
 }
 
+func (c *defaultClientConn) ClientIP() string {
+	return c.clientIp
+}
+
+func (c *defaultClientConn) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
 func (c *defaultClientConn) Send(payload ...[]byte) error {
 	if err := c.ws.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
 		c.Close()
@@ -56,12 +96,41 @@ func (c *defaultClientConn) Send(payload ...[]byte) error {
 		return c.resolveError(err, "clientconn close writer error: %w")
 	}
 
+	CountClientError(payload)
 	return nil
 }
 
+// CountClientError counts the message sent to a client in the client errors metric when it is an error message,
+// whose type and code may be split across the payload parts. It is called for every message sent, so it does not
+// allocate.
+func CountClientError(payload [][]byte) {
+	// The message type and the error code are both uint16
+	const headerSize = 2 * message_types.WebsocketMessageTypeSize
+
+	var header [headerSize]byte
+	n := 0
+	for _, part := range payload {
+		n += copy(header[n:], part)
+		if n == headerSize {
+			break
+		}
+	}
+	if n < headerSize {
+		return
+	}
+
+	msgType := message_types.WebsocketMessageType(helpers.BinaryToUint16(header[:message_types.WebsocketMessageTypeSize]))
+	if msgType != message_types.Error {
+		return
+	}
+
+	code := helpers.BinaryToUint16(header[message_types.WebsocketMessageTypeSize:])
+	metrics.ClientErrors.WithLabelValues(metrics.CodeLabel(code)).Inc()
+}
+
 func (c *defaultClientConn) SendAndLogError(payload ...[]byte) {
 	if err := c.Send(payload...); err != nil {
-		log.Printf("error Client Send And Log Error: %v", err)
+		c.logger.Warn("failed to send a message to the client", "error", err)
 	}
 }
 
@@ -75,6 +144,10 @@ func (c *defaultClientConn) Listen() ([]byte, error) {
 		_ = c.ws.SetReadDeadline(time.Time{})
 	}()
 
+	c.lastPong.Store(int64(time.Since(c.startedAt)))
+	c.listening.Store(true)
+	defer c.listening.Store(false)
+
 	_, reader, err := c.ws.NextReader()
 	if err != nil {
 		c.Close()
@@ -90,6 +163,45 @@ func (c *defaultClientConn) Listen() ([]byte, error) {
 	return data, nil
 }
 
+// ping pings the client every interval with the time elapsed since the connection has started as the payload,
+// which the client sends back in its pong
+func (c *defaultClientConn) ping(interval time.Duration, pongTimeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			sinceLastPong := time.Since(c.startedAt) - time.Duration(c.lastPong.Load())
+			if c.listening.Load() && sinceLastPong > interval+pongTimeout {
+				c.logger.Info("client has not answered pings, closing the connection")
+				c.Close()
+				return
+			}
+
+			payload := helpers.Uint64ToBinary(uint64(time.Since(c.startedAt)))
+			if err := c.ws.WriteControl(websocket.PingMessage, payload, time.Now().Add(pingWriteTimeout)); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *defaultClientConn) handlePong(appData string) error {
+	now := time.Since(c.startedAt)
+	c.lastPong.Store(int64(now))
+
+	if len(appData) == pingPayloadSize {
+		sentAt := time.Duration(helpers.BinaryToUint64([]byte(appData)))
+		c.rtt.Store(int64(now - sentAt))
+	}
+
+	return nil
+}
+
 func (c *defaultClientConn) resolveError(err error, wrapperFormat string) error {
 	if websocket.IsCloseError(err,
 		websocket.CloseNormalClosure,
@@ -1,6 +1,8 @@
 package clientconn
 
 import (
+	"context"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
 	"github.com/gorilla/websocket"
 	"time"
 )
@@ -8,15 +10,32 @@ import (
 const DefaultClientConnTimeout = time.Second * 30
 
 type ClientConnFactory interface {
-	NewClientConn(wsConn *websocket.Conn, timeout time.Duration) ClientConn
+	NewClientConn(ctx context.Context, wsConn *websocket.Conn, clientIp string, timeout time.Duration) ClientConn
 }
 
-type DefaultClientConnFactory struct{}
+// DefaultClientConnFactory creates a new connection around the provided WebSocket connection. Errors of the connection
+// are logged with the logger of the provided context.
+type DefaultClientConnFactory struct {
+	// PingInterval is how often clients are pinged, 0 disables pings and the detection of dead clients
+	PingInterval time.Duration
+	// PongTimeout is how long a client waited for with Listen may stay silent after a ping is due
+	// before its connection is closed
+	PongTimeout time.Duration
+}
 
-func (f *DefaultClientConnFactory) NewClientConn(wsConn *websocket.Conn, timeout time.Duration) ClientConn {
+func (f *DefaultClientConnFactory) NewClientConn(ctx context.Context, wsConn *websocket.Conn, clientIp string, timeout time.Duration) ClientConn {
 	conn := defaultClientConn{
-		ws:      wsConn,
-		timeout: timeout,
+		ws:        wsConn,
+		clientIp:  clientIp,
+		timeout:   timeout,
+		logger:    logging.FromContext(ctx),
+		startedAt: time.Now(),
+		closed:    make(chan struct{}),
+	}
+
+	conn.ws.SetPongHandler(conn.handlePong)
+	if f.PingInterval > 0 {
+		go conn.ping(f.PingInterval, f.PongTimeout)
 	}
 
 	return &conn
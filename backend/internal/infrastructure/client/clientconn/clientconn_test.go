@@ -1,8 +1,12 @@
 package clientconn
 
 import (
+	"context"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"log"
@@ -95,7 +99,7 @@ func createTestConnection(t *testing.T, server *testServer, timeout time.Duratio
 	conn, _, err := dialer.Dial(server.url(), nil)
 	require.NoError(t, err)
 
-	clientConn := (&DefaultClientConnFactory{}).NewClientConn(conn, timeout)
+	clientConn := (&DefaultClientConnFactory{}).NewClientConn(context.Background(), conn, "127.0.0.1", timeout)
 
 	return clientConn
 }
@@ -195,3 +199,74 @@ func TestListenTimeout(t *testing.T) {
 	assert.ErrorIs(t, err, ws_errors.TimeoutErr)
 	assert.Empty(t, msg)
 }
+
+func TestPingMeasuresRTT(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(server.url(), nil)
+	require.NoError(t, err)
+
+	factory := &DefaultClientConnFactory{PingInterval: 10 * time.Millisecond, PongTimeout: time.Second}
+	clientConn := factory.NewClientConn(context.Background(), conn, "127.0.0.1", DefaultClientConnTimeout)
+	defer clientConn.Close()
+
+	// Pongs which have arrived in the meantime are read before the echoed message
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, clientConn.Send([]byte("hello")))
+	msg, err := clientConn.Listen()
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("hello"), msg)
+	assert.Greater(t, clientConn.RTT(), time.Duration(0))
+}
+
+func TestDeadClientIsClosedWhileListening(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	release := make(chan struct{})
+	defer close(release)
+
+	// The peer never reads, so it never answers pings
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+
+	factory := &DefaultClientConnFactory{PingInterval: 20 * time.Millisecond, PongTimeout: 20 * time.Millisecond}
+	clientConn := factory.NewClientConn(context.Background(), conn, "127.0.0.1", 5*time.Second)
+	defer clientConn.Close()
+
+	start := time.Now()
+	_, err = clientConn.Listen()
+
+	assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCountClientError(t *testing.T) {
+	hostNotFound := metrics.ClientErrors.WithLabelValues(metrics.CodeLabel(uint16(ws_errors.HostNotFound)))
+	before := testutil.ToFloat64(hostNotFound)
+
+	CountClientError([][]byte{message_types.Error.Binary(), ws_errors.HostNotFound.Binary()})
+	// Host errors are relayed in a single part
+	CountClientError([][]byte{append(message_types.Error.Binary(), ws_errors.HostNotFound.Binary()...)})
+	// Other messages are not errors
+	CountClientError([][]byte{message_types.ACK.Binary(), ws_errors.HostNotFound.Binary()})
+	CountClientError([][]byte{message_types.Error.Binary()})
+
+	assert.Equal(t, before+2, testutil.ToFloat64(hostNotFound))
+
+	// Messages other than errors are checked without allocating
+	chunk := [][]byte{message_types.ChunkResponse.Binary(), make([]byte, 1024)}
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		CountClientError(chunk)
+	}))
+}
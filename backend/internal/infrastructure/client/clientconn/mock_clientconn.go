@@ -1,9 +1,17 @@
 package clientconn
 
-import "github.com/stretchr/testify/mock"
+import (
+	"github.com/stretchr/testify/mock"
+	"time"
+)
 
 type MockClientConn struct {
 	mock.Mock
+
+	// RoundTripTime is returned by RTT without recording the call
+	RoundTripTime time.Duration
+	// IP is returned by ClientIP without recording the call
+	IP string
 }
 
 func (m *MockClientConn) Send(payload ...[]byte) error {
@@ -24,6 +32,14 @@ func (m *MockClientConn) Listen() ([]byte, error) {
 	return b, args.Error(1)
 }
 
+func (m *MockClientConn) RTT() time.Duration {
+	return m.RoundTripTime
+}
+
+func (m *MockClientConn) ClientIP() string {
+	return m.IP
+}
+
 func (m *MockClientConn) Close() {
 	m.Called()
 }
@@ -0,0 +1,404 @@
+// Package clientsession provides a multiplexed client WebSocket connection on which the client can run
+// many operations at the same time.
+//
+// Every message sent by the client is prefixed with a request ID chosen by the client. The first message
+// with a not yet used request ID opens a new stream, and all following messages with the same ID are routed
+// to that stream. Every message sent back on the stream is prefixed with the same request ID, mirroring
+// what hostconn does for the host side.
+package clientsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	requestIdSizeInBytes = 4
+
+	// streamBufferSize is the number of messages which can wait for a single stream before it is considered
+	// misbehaving and closed
+	streamBufferSize = 64
+	acceptBacklog    = 16
+	// maxStreams is the number of streams a session may have open at once, messages opening more are refused
+	maxStreams = 64
+
+	pingPayloadSize  = 8
+	pingWriteTimeout = 10 * time.Second
+)
+
+type ClientSession interface {
+	// Accept blocks until the client opens a new stream by sending a message with a request ID which
+	// is not bound to any open stream.
+	//
+	// Returns the request ID, the stream bound to it and the message which opened it.
+	// After the session is closed ws_errors.ConnectionClosedErr is returned.
+	Accept() (uint32, clientconn.ClientConn, []byte, error)
+
+	// RTT returns the round trip time measured with the last ping the client has answered, 0 until it has answered one
+	// or when pings are disabled
+	RTT() time.Duration
+
+	// ClientIP returns the IP address the client has connected from
+	ClientIP() string
+
+	// Close terminates the session and all of its streams.
+	// Close is safe to call multiple times and from multiple goroutines.
+	Close()
+}
+
+type acceptedStream struct {
+	stream      *sessionStream
+	initMessage []byte
+}
+
+// defaultClientSession is the default implementation of the ClientSession interface.
+//
+// The session operates with one background goroutine (listen) which reads all client messages
+// and routes them to their streams. Writes are serialised with a mutex. When pings are enabled a second one,
+// ping, pings the client periodically, and a client which sends nothing, not even a pong, within readTimeout
+// is considered dead and the session is closed.
+type defaultClientSession struct {
+	ws       *websocket.Conn
+	clientIp string
+	timeout  time.Duration
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+
+	writeMu sync.Mutex
+
+	streams   map[uint32]*sessionStream
+	streamsMu sync.Mutex
+	acceptCh  chan acceptedStream
+
+	closeOnce sync.Once
+	closeErr  error
+	closeMu   sync.RWMutex
+
+	startedAt   time.Time
+	readTimeout time.Duration
+	rtt         atomic.Int64
+}
+
+var _ ClientSession = (*defaultClientSession)(nil)
+
+func (s *defaultClientSession) Accept() (uint32, clientconn.ClientConn, []byte, error) {
+	select {
+	case accepted := <-s.acceptCh:
+		return accepted.stream.requestId, accepted.stream, accepted.initMessage, nil
+	case <-s.ctx.Done():
+		return 0, nil, nil, s.getCloseError()
+	}
+}
+
+func (s *defaultClientSession) ClientIP() string {
+	return s.clientIp
+}
+
+func (s *defaultClientSession) RTT() time.Duration {
+	return time.Duration(s.rtt.Load())
+}
+
+func (s *defaultClientSession) Close() {
+	s.closeWithError(ws_errors.ConnectionClosedErr)
+}
+
+func (s *defaultClientSession) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.setCloseError(err)
+		s.cancelFunc()
+		_ = s.ws.Close()
+	})
+}
+
+func (s *defaultClientSession) setCloseError(err error) {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closeErr == nil {
+		s.closeErr = err
+	}
+}
+
+func (s *defaultClientSession) getCloseError() error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	return s.closeErr
+}
+
+func (s *defaultClientSession) listen() {
+	defer s.closeWithError(ws_errors.ConnectionClosedErr)
+
+	for {
+		_, message, err := s.ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err,
+				websocket.CloseNormalClosure,
+				websocket.CloseGoingAway,
+				websocket.CloseAbnormalClosure,
+				websocket.CloseNoStatusReceived,
+			) && s.ctx.Err() == nil {
+				logging.FromContext(s.ctx).Warn("client session read failed", "error", err)
+			}
+			return
+		}
+
+		s.extendReadDeadline()
+
+		if len(message) < requestIdSizeInBytes {
+			// There is no request ID to report the error on, so the message is dropped
+			continue
+		}
+
+		requestId := helpers.BinaryToUint32(message[:requestIdSizeInBytes])
+		if !s.route(requestId, message[requestIdSizeInBytes:]) {
+			return
+		}
+	}
+}
+
+// ping pings the client every interval with the time elapsed since the session has started as the payload,
+// which the client sends back in its pong
+func (s *defaultClientSession) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			payload := helpers.Uint64ToBinary(uint64(time.Since(s.startedAt)))
+			if err := s.ws.WriteControl(websocket.PingMessage, payload, time.Now().Add(pingWriteTimeout)); err != nil {
+				s.closeWithError(ws_errors.ConnectionClosedErr)
+				return
+			}
+		}
+	}
+}
+
+func (s *defaultClientSession) handlePong(appData string) error {
+	s.extendReadDeadline()
+
+	if len(appData) == pingPayloadSize {
+		sentAt := time.Duration(helpers.BinaryToUint64([]byte(appData)))
+		s.rtt.Store(int64(time.Since(s.startedAt) - sentAt))
+	}
+
+	return nil
+}
+
+// extendReadDeadline gives the client another readTimeout to send something, after it has just sent a message or a pong
+func (s *defaultClientSession) extendReadDeadline() {
+	if s.readTimeout > 0 {
+		_ = s.ws.SetReadDeadline(time.Now().Add(s.readTimeout))
+	}
+}
+
+// route delivers the message to the stream bound to the request ID or opens a new one. A new stream over
+// maxStreams is refused with TooManyStreams sent on its request ID.
+// Returns false if the session has been closed in the meantime.
+func (s *defaultClientSession) route(requestId uint32, message []byte) bool {
+	s.streamsMu.Lock()
+	stream, ok := s.streams[requestId]
+	if !ok {
+		if len(s.streams) >= maxStreams {
+			s.streamsMu.Unlock()
+			s.sendError(requestId, ws_errors.TooManyStreams)
+			return true
+		}
+
+		stream = newSessionStream(s, requestId)
+		s.streams[requestId] = stream
+	}
+	s.streamsMu.Unlock()
+
+	if !ok {
+		select {
+		case s.acceptCh <- acceptedStream{stream: stream, initMessage: message}:
+			return true
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+
+	select {
+	case stream.messages <- message:
+	default:
+		// The client does not wait for responses, so the stream is dropped instead of blocking the others
+		if stream.closeWithError(ws_errors.StreamBufferOverflowErr) {
+			s.sendError(requestId, ws_errors.StreamBufferOverflow)
+		}
+	}
+
+	return true
+}
+
+// sendError sends an Error with the code on the request ID, for streams which have been refused or dropped by
+// the session itself
+func (s *defaultClientSession) sendError(requestId uint32, code ws_errors.WebsocketErrorCode) {
+	payload := [][]byte{message_types.Error.Binary(), code.Binary()}
+	err := s.write(append([][]byte{helpers.Uint32ToBinary(requestId)}, payload...))
+	if err != nil {
+		logging.FromContext(s.ctx).Warn("failed to send an error to the client", "error", err)
+		return
+	}
+
+	clientconn.CountClientError(payload)
+}
+
+func (s *defaultClientSession) removeStream(requestId uint32) {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+
+	delete(s.streams, requestId)
+}
+
+func (s *defaultClientSession) write(payload [][]byte) error {
+	if err := s.getCloseError(); err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.ws.SetWriteDeadline(time.Now().Add(s.timeout)); err != nil {
+		s.closeWithError(ws_errors.ConnectionClosedErr)
+		return ws_errors.ConnectionClosedErr
+	}
+
+	w, err := s.ws.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return s.resolveWriteError(err, "clientsession nextwriter error: %w")
+	}
+
+	for _, part := range payload {
+		if _, err = w.Write(part); err != nil {
+			_ = w.Close()
+			return s.resolveWriteError(err, "clientsession write error: %w")
+		}
+	}
+
+	if err = w.Close(); err != nil {
+		return s.resolveWriteError(err, "clientsession close writer error: %w")
+	}
+
+	return nil
+}
+
+func (s *defaultClientSession) resolveWriteError(err error, wrapperFormat string) error {
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		s.closeWithError(ws_errors.TimeoutErr)
+		return ws_errors.TimeoutErr
+	}
+
+	if s.ctx.Err() != nil {
+		return s.getCloseError()
+	}
+
+	s.closeWithError(ws_errors.ConnectionClosedErr)
+	return fmt.Errorf(wrapperFormat, err)
+}
+
+// sessionStream is a single client operation running inside a session.
+// It implements clientconn.ClientConn, so it can be used everywhere a dedicated client connection can.
+type sessionStream struct {
+	session   *defaultClientSession
+	requestId uint32
+
+	messages chan []byte
+	done     chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var _ clientconn.ClientConn = (*sessionStream)(nil)
+
+func newSessionStream(session *defaultClientSession, requestId uint32) *sessionStream {
+	return &sessionStream{
+		session:   session,
+		requestId: requestId,
+		messages:  make(chan []byte, streamBufferSize),
+		done:      make(chan struct{}),
+	}
+}
+
+func (st *sessionStream) Send(payload ...[]byte) error {
+	select {
+	case <-st.done:
+		return st.closeErr
+	default:
+	}
+
+	withRequestId := make([][]byte, 0, len(payload)+1)
+	withRequestId = append(withRequestId, helpers.Uint32ToBinary(st.requestId))
+	withRequestId = append(withRequestId, payload...)
+
+	if err := st.session.write(withRequestId); err != nil {
+		return err
+	}
+
+	clientconn.CountClientError(payload)
+	return nil
+}
+
+func (st *sessionStream) SendAndLogError(payload ...[]byte) {
+	if err := st.Send(payload...); err != nil {
+		logging.FromContext(st.session.ctx).Warn("failed to send a message to the client", "error", err)
+	}
+}
+
+// RTT returns the round trip time of the whole session
+func (st *sessionStream) RTT() time.Duration {
+	return st.session.RTT()
+}
+
+func (st *sessionStream) ClientIP() string {
+	return st.session.ClientIP()
+}
+
+func (st *sessionStream) Listen() ([]byte, error) {
+	timer := time.NewTimer(st.session.timeout)
+	defer timer.Stop()
+
+	select {
+	case message := <-st.messages:
+		return message, nil
+	case <-st.done:
+		return nil, st.closeErr
+	case <-st.session.ctx.Done():
+		return nil, st.session.getCloseError()
+	case <-timer.C:
+		return nil, ws_errors.TimeoutErr
+	}
+}
+
+// Close unbinds the request ID of the stream. The session itself stays open.
+func (st *sessionStream) Close() {
+	st.closeWithError(ws_errors.ConnectionClosedErr)
+}
+
+// closeWithError closes the stream, it returns false if it has already been closed
+func (st *sessionStream) closeWithError(err error) bool {
+	closed := false
+	st.closeOnce.Do(func() {
+		st.closeErr = err
+		close(st.done)
+		st.session.removeStream(st.requestId)
+		closed = true
+	})
+
+	return closed
+}
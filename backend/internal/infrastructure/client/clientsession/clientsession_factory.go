@@ -0,0 +1,55 @@
+package clientsession
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type ClientSessionFactory interface {
+	NewClientSession(ctx context.Context, wsConn *websocket.Conn, clientIp string, timeout time.Duration) ClientSession
+}
+
+// DefaultClientSessionFactory creates a new session around the provided WebSocket connection and starts
+// the goroutine reading client messages.
+//
+// The provided context controls the session lifetime. The timeout is applied to every write and to
+// every Listen call of the session streams.
+type DefaultClientSessionFactory struct {
+	// PingInterval is how often clients are pinged, 0 disables pings and the detection of dead clients
+	PingInterval time.Duration
+	// PongTimeout is how long a client may stay silent after a ping is due before its session is closed
+	PongTimeout time.Duration
+}
+
+func (f *DefaultClientSessionFactory) NewClientSession(
+	ctx context.Context,
+	wsConn *websocket.Conn,
+	clientIp string,
+	timeout time.Duration,
+) ClientSession {
+	ctx, cancel := context.WithCancel(ctx)
+
+	session := defaultClientSession{
+		ws:         wsConn,
+		clientIp:   clientIp,
+		timeout:    timeout,
+		ctx:        ctx,
+		cancelFunc: cancel,
+		streams:    make(map[uint32]*sessionStream),
+		acceptCh:   make(chan acceptedStream, acceptBacklog),
+		startedAt:  time.Now(),
+	}
+
+	session.ws.SetPongHandler(session.handlePong)
+	if f.PingInterval > 0 {
+		session.readTimeout = f.PingInterval + f.PongTimeout
+		session.extendReadDeadline()
+		go session.ping(f.PingInterval)
+	}
+
+	go session.listen()
+
+	return &session
+}
@@ -0,0 +1,417 @@
+package clientsession
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testServer struct {
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+	timeout  time.Duration
+	factory  *DefaultClientSessionFactory
+
+	sessionCh chan ClientSession
+}
+
+// newTestServer starts a server which wraps every incoming connection in a ClientSession
+// and hands it over to the test
+func newTestServer(timeout time.Duration) *testServer {
+	ts := &testServer{
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+		timeout:   timeout,
+		factory:   &DefaultClientSessionFactory{},
+		sessionCh: make(chan ClientSession, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ts.handleWebSocket)
+	ts.server = httptest.NewServer(mux)
+
+	return ts
+}
+
+func (ts *testServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := ts.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ts.sessionCh <- ts.factory.NewClientSession(context.Background(), conn, "127.0.0.1", ts.timeout)
+}
+
+func (ts *testServer) url() string {
+	return "ws" + strings.TrimPrefix(ts.server.URL, "http")
+}
+
+func (ts *testServer) close() {
+	ts.server.Close()
+}
+
+func createTestSession(t *testing.T, server *testServer) (ClientSession, *websocket.Conn) {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(server.url(), nil)
+	require.NoError(t, err)
+
+	select {
+	case session := <-server.sessionCh:
+		return session, conn
+	case <-time.After(2 * time.Second):
+		t.Fatal("session has not been created")
+		return nil, nil
+	}
+}
+
+func writeWithRequestId(t *testing.T, conn *websocket.Conn, requestId uint32, payload string) {
+	t.Helper()
+	msg := append(helpers.Uint32ToBinary(requestId), []byte(payload)...)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, msg))
+}
+
+func readWithRequestId(t *testing.T, conn *websocket.Conn) (uint32, string) {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(msg), requestIdSizeInBytes)
+	return helpers.BinaryToUint32(msg[:requestIdSizeInBytes]), string(msg[requestIdSizeInBytes:])
+}
+
+func TestAcceptAndSend(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	writeWithRequestId(t, client, 7, "hello")
+
+	requestId, stream, initMessage, err := session.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), requestId)
+	assert.Equal(t, []byte("hello"), initMessage)
+
+	require.NoError(t, stream.Send([]byte("hello"), []byte(" back")))
+
+	respId, resp := readWithRequestId(t, client)
+	assert.Equal(t, uint32(7), respId)
+	assert.Equal(t, "hello back", resp)
+}
+
+func TestFollowingMessagesAreRoutedToStream(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	writeWithRequestId(t, client, 1, "open")
+	_, stream, _, err := session.Accept()
+	require.NoError(t, err)
+
+	writeWithRequestId(t, client, 1, "first")
+	writeWithRequestId(t, client, 1, "second")
+
+	msg, err := stream.Listen()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), msg)
+
+	msg, err = stream.Listen()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), msg)
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	const streamCount = 10
+	var wg sync.WaitGroup
+	go func() {
+		for {
+			_, stream, initMessage, err := session.Accept()
+			if err != nil {
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer stream.Close()
+
+				next, err := stream.Listen()
+				if err != nil {
+					return
+				}
+				_ = stream.Send(initMessage, []byte(":"), next)
+			}()
+		}
+	}()
+
+	for i := uint32(0); i < streamCount; i++ {
+		writeWithRequestId(t, client, i, fmt.Sprintf("open-%d", i))
+	}
+	for i := uint32(0); i < streamCount; i++ {
+		writeWithRequestId(t, client, i, fmt.Sprintf("next-%d", i))
+	}
+
+	responses := make(map[uint32]string)
+	for i := 0; i < streamCount; i++ {
+		requestId, resp := readWithRequestId(t, client)
+		responses[requestId] = resp
+	}
+
+	for i := uint32(0); i < streamCount; i++ {
+		assert.Equal(t, fmt.Sprintf("open-%d:next-%d", i, i), responses[i])
+	}
+	wg.Wait()
+}
+
+func TestClosedStreamReleasesRequestId(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	writeWithRequestId(t, client, 3, "first")
+	_, stream, _, err := session.Accept()
+	require.NoError(t, err)
+	stream.Close()
+
+	err = stream.Send([]byte("after close"))
+	assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+
+	writeWithRequestId(t, client, 3, "second")
+	requestId, _, initMessage, err := session.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), requestId)
+	assert.Equal(t, []byte("second"), initMessage)
+}
+
+func TestStreamListenTimeout(t *testing.T) {
+	server := newTestServer(50 * time.Millisecond)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	writeWithRequestId(t, client, 1, "open")
+	_, stream, _, err := session.Accept()
+	require.NoError(t, err)
+
+	msg, err := stream.Listen()
+	assert.ErrorIs(t, err, ws_errors.TimeoutErr)
+	assert.Nil(t, msg)
+}
+
+func TestClientCloseConnection(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+
+	writeWithRequestId(t, client, 1, "open")
+	_, stream, _, err := session.Accept()
+	require.NoError(t, err)
+
+	client.Close()
+
+	msg, err := stream.Listen()
+	assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	assert.Nil(t, msg)
+
+	_, _, _, err = session.Accept()
+	assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+}
+
+func TestMessageWithoutRequestIdIsIgnored(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	require.NoError(t, client.WriteMessage(websocket.BinaryMessage, []byte{1, 2}))
+	writeWithRequestId(t, client, 9, "valid")
+
+	requestId, _, initMessage, err := session.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(9), requestId)
+	assert.Equal(t, []byte("valid"), initMessage)
+}
+
+func TestPingMeasuresRTT(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+	server.factory = &DefaultClientSessionFactory{PingInterval: 10 * time.Millisecond, PongTimeout: time.Second}
+
+	session, conn := createTestSession(t, server)
+	defer session.Close()
+	defer conn.Close()
+
+	// The client answers pings while it reads
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	assert.Eventually(t, func() bool {
+		return session.RTT() > 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestDeadClientClosesSession(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+	server.factory = &DefaultClientSessionFactory{PingInterval: 20 * time.Millisecond, PongTimeout: 20 * time.Millisecond}
+
+	// The client never reads, so it never answers pings
+	session, conn := createTestSession(t, server)
+	defer conn.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, _, _, err := session.Accept()
+		accepted <- err
+	}()
+
+	select {
+	case err := <-accepted:
+		assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+	case <-time.After(time.Second):
+		t.Fatal("session of a dead client has not been closed")
+	}
+}
+
+func errorMessage(code ws_errors.WebsocketErrorCode) string {
+	return string(append(message_types.Error.Binary(), code.Binary()...))
+}
+
+func TestTooManyStreamsAreRefused(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	accepted := make(chan clientconn.ClientConn, maxStreams)
+	go func() {
+		for {
+			_, stream, _, err := session.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- stream
+		}
+	}()
+
+	for i := uint32(0); i < maxStreams; i++ {
+		writeWithRequestId(t, client, i, "open")
+	}
+	streams := make([]clientconn.ClientConn, 0, maxStreams)
+	for range maxStreams {
+		select {
+		case stream := <-accepted:
+			streams = append(streams, stream)
+		case <-time.After(2 * time.Second):
+			t.Fatal("stream has not been accepted")
+		}
+	}
+
+	writeWithRequestId(t, client, maxStreams, "one too many")
+	requestId, resp := readWithRequestId(t, client)
+	assert.Equal(t, uint32(maxStreams), requestId)
+	assert.Equal(t, errorMessage(ws_errors.TooManyStreams), resp)
+
+	// Closing a stream makes room for a new one
+	streams[0].Close()
+	writeWithRequestId(t, client, maxStreams, "open")
+	select {
+	case <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream has not been accepted")
+	}
+}
+
+func TestStreamBufferOverflow(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	writeWithRequestId(t, client, 5, "open")
+	_, stream, _, err := session.Accept()
+	require.NoError(t, err)
+
+	for range streamBufferSize + 1 {
+		writeWithRequestId(t, client, 5, "not listened to")
+	}
+
+	requestId, resp := readWithRequestId(t, client)
+	assert.Equal(t, uint32(5), requestId)
+	assert.Equal(t, errorMessage(ws_errors.StreamBufferOverflow), resp)
+
+	err = stream.Send([]byte("after overflow"))
+	assert.ErrorIs(t, err, ws_errors.StreamBufferOverflowErr)
+}
+
+func TestStreamErrorsAreCounted(t *testing.T) {
+	server := newTestServer(time.Second)
+	defer server.close()
+
+	session, client := createTestSession(t, server)
+	defer session.Close()
+	defer client.Close()
+
+	hostNotFound := metrics.ClientErrors.WithLabelValues(metrics.CodeLabel(uint16(ws_errors.HostNotFound)))
+	before := testutil.ToFloat64(hostNotFound)
+
+	writeWithRequestId(t, client, 9, "open")
+	_, stream, _, err := session.Accept()
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send([]byte("not an error")))
+	require.NoError(t, stream.Send(message_types.Error.Binary(), ws_errors.HostNotFound.Binary()))
+	readWithRequestId(t, client)
+	readWithRequestId(t, client)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(hostNotFound))
+}
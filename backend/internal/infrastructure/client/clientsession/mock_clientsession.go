@@ -0,0 +1,41 @@
+package clientsession
+
+import (
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/stretchr/testify/mock"
+	"time"
+)
+
+type MockClientSession struct {
+	mock.Mock
+
+	// RoundTripTime is returned by RTT without recording the call
+	RoundTripTime time.Duration
+	// IP is returned by ClientIP without recording the call
+	IP string
+}
+
+func (m *MockClientSession) Accept() (uint32, clientconn.ClientConn, []byte, error) {
+	args := m.Called()
+	var stream clientconn.ClientConn
+	if args.Get(1) != nil {
+		stream = args.Get(1).(clientconn.ClientConn)
+	}
+	var b []byte
+	if args.Get(2) != nil {
+		b = args.Get(2).([]byte)
+	}
+	return args.Get(0).(uint32), stream, b, args.Error(3)
+}
+
+func (m *MockClientSession) RTT() time.Duration {
+	return m.RoundTripTime
+}
+
+func (m *MockClientSession) ClientIP() string {
+	return m.IP
+}
+
+func (m *MockClientSession) Close() {
+	m.Called()
+}
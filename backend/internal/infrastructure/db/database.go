@@ -4,13 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 )
 
+// fts5MigrationSuffix marks migrations which need SQLite with FTS5, which go-sqlite3 has only when built with
+// the sqlite_fts5 tag. Without it they are skipped, and applied at the first startup with it.
+const fts5MigrationSuffix = ".fts5.sql"
+
 type SqlDatabaseInterface interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
@@ -34,6 +38,9 @@ func NewSqlDatabase(ctx context.Context, driverName string, dataSourceName strin
 		return nil, err
 	}
 
+	_, statErr := os.Stat(dataSourceName)
+	created := os.IsNotExist(statErr)
+
 	db, err := sql.Open(driverName, dataSourceName)
 	if err != nil {
 		return nil, err
@@ -41,11 +48,14 @@ func NewSqlDatabase(ctx context.Context, driverName string, dataSourceName strin
 
 	dbConn := SqlDatabase{db: db}
 
-	if !dbConn.databaseExists(dataSourceName) {
-		err = dbConn.runMigrations(ctx, migrationsPath)
-		if err != nil {
-			return nil, err
+	err = dbConn.runMigrations(ctx, migrationsPath)
+	if err != nil {
+		_ = db.Close()
+		// A database made only to fail its migrations is not left behind
+		if created {
+			_ = os.Remove(dataSourceName)
 		}
+		return nil, err
 	}
 
 	return &dbConn, nil
@@ -79,14 +89,32 @@ func (s *SqlDatabase) Close() error {
 	return s.db.Close()
 }
 
-func (s *SqlDatabase) databaseExists(dataSourceName string) bool {
-	// For sqlite we check if db file exists
-	_, err := os.Stat(dataSourceName)
-	return !os.IsNotExist(err)
-}
-
+// runMigrations applies the migrations from migrationsPath which have not been applied yet, in the order of their
+// file names. Applied migrations are recorded in the schema_migrations table, so every startup brings existing
+// databases up to date. Each migration is applied in its own transaction, a failing one changes nothing.
+//
+// Databases created before migrations have been recorded have none of them recorded, so all migrations have to
+// succeed on a database they have already been applied to. They create their tables with IF NOT EXISTS.
 func (s *SqlDatabase) runMigrations(ctx context.Context, migrationsPath string) error {
-	log.Println("Running migrations")
+	_, err := s.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version TEXT PRIMARY KEY,
+            applied_at TIMESTAMP NOT NULL
+        )
+    `)
+	if err != nil {
+		return err
+	}
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	hasFts5, err := s.HasFts5(ctx)
+	if err != nil {
+		return err
+	}
 
 	files, err := os.ReadDir(migrationsPath)
 	if err != nil {
@@ -98,28 +126,80 @@ func (s *SqlDatabase) runMigrations(ctx context.Context, migrationsPath string)
 	})
 
 	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".sql") {
-			err = s.runSingleMigration(ctx, file, migrationsPath)
-			if err != nil {
-				return err
-			}
+		if !strings.HasSuffix(file.Name(), ".sql") || applied[file.Name()] {
+			continue
+		}
+
+		if strings.HasSuffix(file.Name(), fts5MigrationSuffix) && !hasFts5 {
+			slog.Warn("skipped migration, SQLite has been built without FTS5", "file", file.Name())
+			continue
+		}
+
+		err = s.runSingleMigration(ctx, file, migrationsPath)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// HasFts5 tells whether SQLite has been built with the FTS5 full text search
+func (s *SqlDatabase) HasFts5(ctx context.Context) (bool, error) {
+	var used bool
+	err := s.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&used)
+	return used, err
+}
+
+func (s *SqlDatabase) appliedMigrations(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
 func (s *SqlDatabase) runSingleMigration(ctx context.Context, file os.DirEntry, migrationsPath string) error {
 	sqlBytes, err := os.ReadFile(filepath.Join(migrationsPath, file.Name()))
 	if err != nil {
 		return err
 	}
 
-	query := string(sqlBytes)
-	if _, err = s.ExecContext(ctx, query); err != nil {
+	tx, err := s.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err = tx.ExecContext(ctx, string(sqlBytes)); err != nil {
 		return fmt.Errorf("error running migration %s: %w", file.Name(), err)
 	}
 
-	log.Println("Applied migration:", file.Name())
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO schema_migrations (version, applied_at)
+        VALUES ($1, CURRENT_TIMESTAMP)
+    `, file.Name())
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	slog.Info("applied migration", "file", file.Name())
 	return nil
 }
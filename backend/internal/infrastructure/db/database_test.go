@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMigration(t *testing.T, migrationsPath string, name string, query string) {
+	require.NoError(t, os.WriteFile(filepath.Join(migrationsPath, name), []byte(query), 0644))
+}
+
+func tableExists(t *testing.T, database SqlDatabaseInterface, table string) bool {
+	var count int
+	err := database.QueryRowContext(context.Background(),
+		`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = $1`, table,
+	).Scan(&count)
+	require.NoError(t, err)
+	return count > 0
+}
+
+func appliedVersions(t *testing.T, database SqlDatabaseInterface) []string {
+	rows, err := database.QueryContext(context.Background(), `SELECT version FROM schema_migrations ORDER BY version`)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		require.NoError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	require.NoError(t, rows.Err())
+	return versions
+}
+
+func TestMigrations(t *testing.T) {
+	t.Run("success - missing migrations are applied at every startup", func(t *testing.T) {
+		dir := t.TempDir()
+		dataSource := filepath.Join(dir, "data.sqlite")
+		migrationsPath := filepath.Join(dir, "migrations")
+		require.NoError(t, os.Mkdir(migrationsPath, 0755))
+
+		// Not idempotent, so running them again would fail
+		writeMigration(t, migrationsPath, "1_A.sql", `CREATE TABLE a (id INTEGER)`)
+		writeMigration(t, migrationsPath, "2_B.sql", `CREATE TABLE b (id INTEGER)`)
+
+		database, err := NewSqlDatabase(context.Background(), "sqlite3", dataSource, migrationsPath)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1_A.sql", "2_B.sql"}, appliedVersions(t, database))
+		require.NoError(t, database.Close())
+
+		writeMigration(t, migrationsPath, "3_C.sql", `CREATE TABLE c (id INTEGER)`)
+
+		database, err = NewSqlDatabase(context.Background(), "sqlite3", dataSource, migrationsPath)
+		require.NoError(t, err)
+		defer database.Close()
+
+		assert.True(t, tableExists(t, database, "c"))
+		assert.Equal(t, []string{"1_A.sql", "2_B.sql", "3_C.sql"}, appliedVersions(t, database))
+	})
+
+	t.Run("success - database made before migrations were recorded gets the new tables", func(t *testing.T) {
+		dir := t.TempDir()
+		dataSource := filepath.Join(dir, "data.sqlite")
+
+		legacy, err := sql.Open("sqlite3", dataSource)
+		require.NoError(t, err)
+		_, err = legacy.Exec(`CREATE TABLE saved_connections (id UUID PRIMARY KEY, key_hash VARCHAR(128), created_at TIMESTAMP)`)
+		require.NoError(t, err)
+		require.NoError(t, legacy.Close())
+
+		database, err := NewSqlDatabase(context.Background(), "sqlite3", dataSource, "../../../migrations")
+		require.NoError(t, err)
+		defer database.Close()
+
+		assert.True(t, tableExists(t, database, "host_last_seen"))
+		assert.True(t, tableExists(t, database, "file_index"))
+	})
+
+	t.Run("success - FTS5 migrations wait for SQLite with FTS5", func(t *testing.T) {
+		dir := t.TempDir()
+		migrationsPath := filepath.Join(dir, "migrations")
+		require.NoError(t, os.Mkdir(migrationsPath, 0755))
+
+		writeMigration(t, migrationsPath, "1_SEARCH.fts5.sql", `CREATE VIRTUAL TABLE search USING fts5(name)`)
+
+		database, err := NewSqlDatabase(context.Background(), "sqlite3", filepath.Join(dir, "data.sqlite"), migrationsPath)
+		require.NoError(t, err)
+		defer database.Close()
+
+		hasFts5, err := database.(*SqlDatabase).HasFts5(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, hasFts5, tableExists(t, database, "search"))
+		assert.Equal(t, hasFts5, len(appliedVersions(t, database)) == 1)
+	})
+
+	t.Run("error - failing migration changes nothing", func(t *testing.T) {
+		dir := t.TempDir()
+		dataSource := filepath.Join(dir, "data.sqlite")
+		migrationsPath := filepath.Join(dir, "migrations")
+		require.NoError(t, os.Mkdir(migrationsPath, 0755))
+
+		writeMigration(t, migrationsPath, "1_A.sql", `CREATE TABLE a (id INTEGER)`)
+		database, err := NewSqlDatabase(context.Background(), "sqlite3", dataSource, migrationsPath)
+		require.NoError(t, err)
+		require.NoError(t, database.Close())
+
+		writeMigration(t, migrationsPath, "2_B.sql", `CREATE TABLE b (id INTEGER); CREATE TABLE a (id INTEGER);`)
+		_, err = NewSqlDatabase(context.Background(), "sqlite3", dataSource, migrationsPath)
+		require.Error(t, err)
+
+		require.NoError(t, os.Remove(filepath.Join(migrationsPath, "2_B.sql")))
+		database, err = NewSqlDatabase(context.Background(), "sqlite3", dataSource, migrationsPath)
+		require.NoError(t, err)
+		defer database.Close()
+
+		assert.False(t, tableExists(t, database, "b"))
+		assert.Equal(t, []string{"1_A.sql"}, appliedVersions(t, database))
+	})
+
+	t.Run("error - new database failing its migrations is removed", func(t *testing.T) {
+		dir := t.TempDir()
+		dataSource := filepath.Join(dir, "data.sqlite")
+		migrationsPath := filepath.Join(dir, "migrations")
+		require.NoError(t, os.Mkdir(migrationsPath, 0755))
+
+		writeMigration(t, migrationsPath, "1_A.sql", `CREATE TABLE a (id INTEGER)`)
+		writeMigration(t, migrationsPath, "2_B.sql", `CREATE TABLE`)
+
+		_, err := NewSqlDatabase(context.Background(), "sqlite3", dataSource, migrationsPath)
+		require.Error(t, err)
+
+		_, err = os.Stat(dataSource)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,16 @@
+package hostconn
+
+// Capabilities is a bitmask of optional protocol features a host announces in its response to the init query.
+// Hosts which announce nothing only speak the original protocol.
+type Capabilities uint32
+
+const (
+	// CapabilityLargeFiles means the host accepts CreateFileInitRequest64 with a 64-bit file size
+	CapabilityLargeFiles Capabilities = 1 << iota
+	// CapabilityPagedListing means the host answers ListDirectoryQuery with ListDirectoryResponse
+	CapabilityPagedListing
+)
+
+func (c Capabilities) Has(capability Capabilities) bool {
+	return c&capability == capability
+}
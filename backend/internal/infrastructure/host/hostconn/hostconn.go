@@ -17,37 +17,101 @@ import (
 	"fmt"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/tracing"
 	"github.com/gorilla/websocket"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	queryIdSizeInBytes  = 4
-	defaultQueryTimeout = 30 * time.Second
+	queryIdSizeInBytes = 4
+	pingPayloadSize    = 8
+
+	// DefaultQueryTimeout is the timeout of Query
+	DefaultQueryTimeout = 30 * time.Second
+
+	// pushQueryId marks messages the host sends on its own instead of in response to a query
+	pushQueryId = 0
+
+	// bulkQueryIdFlag is set in the query IDs of bulk queries, hinting the host to answer the others first.
+	// Hosts send the query ID back unchanged, so the flag is a part of the ID.
+	bulkQueryIdFlag = 1 << 31
+	queryIdMask     = bulkQueryIdFlag - 1
+
+	pingWriteTimeout = 10 * time.Second
 )
 
 type HostConn interface {
-	// Query sends a query and waits for a response using the default timeout which is set at 30 seconds.
+	// Query sends a query with PriorityControl and waits for a response using the default timeout which is set
+	// at 30 seconds.
 	// Multiple concurrent queries are supported and will be properly routed to their respective callers based on query IDs.
 	//
 	// All byte arras will be sent in one message in order they have been provided, with a unique query ID automatically prepended.
 	//
 	// Returns the response payload (without the query ID) or an error if the operation fails or times out.
-	// On any error other than timeout error the connection is closed, so there is no need to close it again
+	// If the host or the flow already has the maximum number of pending queries, ws_errors.HostSaturatedErr is returned
+	// without sending anything.
+	// On any error other than timeout and saturation errors the connection is closed, so there is no need to close it again
 	Query(query ...[]byte) ([]byte, error)
 
-	// QueryWithTimeout sends a query and waits for a response within the specified timeout.
+	// QueryWithTimeout sends a query with the given priority and waits for a response within the specified timeout.
 	// Multiple concurrent queries are supported and will be properly routed to their respective callers.
+	// Queued queries are written highest priority first, and the host is hinted to answer them the same way.
 	//
 	// The query parameter will be sent as the payload portion of the message, with a unique query ID automatically prepended.
 	// The timeout parameter specifies the maximum time to wait for a response.
 	// If the timeout is exceeded, ws_errors.TimeoutErr is returned.
 	//
 	// Returns the response payload or an error if the operation fails or times out.
-	// On any error other than timeout error the connection is closed, so there is no need to close it again
-	QueryWithTimeout(timeout time.Duration, query ...[]byte) ([]byte, error)
+	// On any error other than timeout and saturation errors the connection is closed, so there is no need to close it again
+	QueryWithTimeout(timeout time.Duration, priority Priority, query ...[]byte) ([]byte, error)
+
+	// Send sends a message without waiting for a response. It is meant for file data, so the message is queued with
+	// PriorityBulk. The message gets a unique query ID prepended the same way queries do, so messages sent with Send
+	// and bulk queries on the same flow are delivered to the host in the order they have been called in.
+	// Any response the host sends for it is dropped.
+	//
+	// Returns once the message has been written, or an error if that did not happen within the default timeout.
+	// Like queries, messages are refused with ws_errors.HostSaturatedErr when too many are pending.
+	Send(query ...[]byte) error
+
+	// Flow returns a view of the connection whose queries and messages are queued in the flow with the given ID.
+	// Flows get turns at sending, so the queries of one flow can not hold back the queries of the others. Views
+	// share everything else with the connection, including Close. Flow(DefaultFlow) returns the connection itself.
+	Flow(id FlowId) HostConn
+
+	// WithContext returns a view of the connection which logs its queries with the logger carried by ctx, e.g. one
+	// with the ID of the client request they are made for, and traces them as children of the span carried by ctx.
+	// The queries are not canceled with ctx. Views of views keep both the flow and the context.
+	WithContext(ctx context.Context) HostConn
+
+	// Capabilities returns the protocol features announced by the host, none until SetCapabilities is called
+	Capabilities() Capabilities
+
+	// SetCapabilities stores the protocol features the host has announced during the connection handshake
+	SetCapabilities(capabilities Capabilities)
+
+	// RTT returns the round trip time measured with the last ping the host has answered, 0 until it has answered one
+	// or when pings are disabled
+	RTT() time.Duration
+
+	// ConnectedAt returns when the connection has been established
+	ConnectedAt() time.Time
+
+	// RemoteAddr returns the address the host has connected from, empty until SetRemoteAddr is called
+	RemoteAddr() string
+
+	// SetRemoteAddr stores the address the host has connected from, as seen by the HTTP server
+	SetRemoteAddr(addr string)
+
+	// SetPushHandler sets the function called with every message the host sends on its own, marked with query ID 0,
+	// without the query ID. Pushed messages are handled one by one in the order they have arrived, on the goroutine
+	// reading responses, so the handler should return quickly. Messages pushed while no handler is set are dropped.
+	SetPushHandler(handler func(msg []byte))
 
 	// Close terminates the connection and cleans up all associated resources.
 	// After calling Close, all pending and future queries will fail with ErrConnectionClosed.
@@ -59,8 +123,11 @@ type HostConn interface {
 // automatic query ID generation, response routing, and connection lifecycle management.
 //
 // The connection operates with two background goroutines:
-// - send: handles outgoing queries from the queryCh channel
+// - send: writes outgoing queries taken from the scheduler, one flow at a time
 // - listen: handles incoming responses and routes them to waiting queries
+//
+// When pings are enabled a third one, ping, pings the host periodically. A host which sends nothing, not even
+// a pong, within readTimeout is considered dead and the connection is closed.
 type defaultHostConn struct {
 	ws *websocket.Conn
 
@@ -69,7 +136,7 @@ type defaultHostConn struct {
 
 	nextQueryId uint32
 
-	queryCh            chan [][]byte
+	scheduler          *scheduler
 	responseChannels   map[uint32]chan []byte
 	responseChannelsMu sync.Mutex
 
@@ -77,47 +144,217 @@ type defaultHostConn struct {
 	closeErr     error
 	closeMu      sync.RWMutex
 	closeHandler func()
+
+	capabilities atomic.Uint32
+	pushHandler  atomic.Pointer[func(msg []byte)]
+	remoteAddr   atomic.Pointer[string]
+
+	startedAt   time.Time
+	readTimeout time.Duration
+	rtt         atomic.Int64
 }
 
 var _ HostConn = (*defaultHostConn)(nil)
 
+// flowHostConn is a view of a defaultHostConn which queues its queries in its own flow and logs them with its
+// own logger
+type flowHostConn struct {
+	*defaultHostConn
+	flow FlowId
+	// ctx carries the logger and the span of the queries of the view, it is nil for views without either
+	ctx context.Context
+}
+
+func (conn *flowHostConn) Query(query ...[]byte) ([]byte, error) {
+	return conn.QueryWithTimeout(DefaultQueryTimeout, PriorityControl, query...)
+}
+
+func (conn *flowHostConn) QueryWithTimeout(timeout time.Duration, priority Priority, query ...[]byte) ([]byte, error) {
+	return conn.queryOnFlow(conn.ctx, conn.flow, timeout, priority, query)
+}
+
+func (conn *flowHostConn) Flow(id FlowId) HostConn {
+	return &flowHostConn{defaultHostConn: conn.defaultHostConn, flow: id, ctx: conn.ctx}
+}
+
+func (conn *flowHostConn) WithContext(ctx context.Context) HostConn {
+	return &flowHostConn{defaultHostConn: conn.defaultHostConn, flow: conn.flow, ctx: ctx}
+}
+
+func (conn *flowHostConn) Send(query ...[]byte) error {
+	return conn.sendOnFlow(conn.flow, query)
+}
+
 func (conn *defaultHostConn) Query(query ...[]byte) ([]byte, error) {
-	return conn.QueryWithTimeout(defaultQueryTimeout, query...)
+	return conn.QueryWithTimeout(DefaultQueryTimeout, PriorityControl, query...)
+}
+
+func (conn *defaultHostConn) QueryWithTimeout(timeout time.Duration, priority Priority, query ...[]byte) ([]byte, error) {
+	return conn.queryOnFlow(nil, DefaultFlow, timeout, priority, query)
+}
+
+func (conn *defaultHostConn) Send(query ...[]byte) error {
+	return conn.sendOnFlow(DefaultFlow, query)
+}
+
+func (conn *defaultHostConn) Flow(id FlowId) HostConn {
+	if id == DefaultFlow {
+		return conn
+	}
+
+	return &flowHostConn{defaultHostConn: conn, flow: id}
 }
 
-func (conn *defaultHostConn) QueryWithTimeout(timeout time.Duration, query ...[]byte) ([]byte, error) {
+func (conn *defaultHostConn) WithContext(ctx context.Context) HostConn {
+	return &flowHostConn{defaultHostConn: conn, flow: DefaultFlow, ctx: ctx}
+}
+
+// queryOnFlow sends the query in the flow and waits for its response. The query is logged and traced with the logger
+// and the span carried by parent, which may be nil.
+func (conn *defaultHostConn) queryOnFlow(
+	parent context.Context,
+	flow FlowId,
+	timeout time.Duration,
+	priority Priority,
+	query [][]byte,
+) (_ []byte, err error) {
+	if parent == nil {
+		parent = context.Background()
+	}
 	if err := conn.getCloseError(); err != nil {
 		return nil, err
 	}
 
-	queryId, responseCh := conn.createNewResponseChannel()
+	if err := conn.scheduler.admit(flow); err != nil {
+		return nil, err
+	}
+	defer conn.scheduler.release(flow)
+
+	messageType := messageTypeLabel(query)
+	inFlight := metrics.HostQueriesInFlight.WithLabelValues(messageType)
+	inFlight.Inc()
+	defer inFlight.Dec()
+	start := time.Now()
+
+	queryId, responseCh := conn.createNewResponseChannel(priority)
 	defer conn.cleanupResponseChannel(queryId)
 
-	queryWithId := addQueryIdToQuery(query, queryId)
+	logger := logging.FromContext(parent).With(logging.QueryIdKey, queryId, logging.MessageTypeKey, messageType)
+	_, span := tracing.Start(parent, "hostconn.Query",
+		tracing.QueryIdKey.Int64(int64(queryId)),
+		tracing.MessageTypeKey.String(messageType),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
 
 	ctx, cancel := context.WithTimeout(conn.ctx, timeout)
 	defer cancel()
 
-	// Send the query
-	select {
-	case conn.queryCh <- queryWithId:
-	case <-ctx.Done():
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return nil, ws_errors.TimeoutErr
-		}
-		return nil, conn.getCloseError()
+	if err := conn.enqueue(ctx, flow, priority, addQueryIdToQuery(query, queryId)); err != nil {
+		countTimeout(messageType, err)
+		logger.Warn("host query not sent", "error", err)
+		return nil, err
 	}
+	// Time spent before the event is spent waiting for a turn to be written, after it waiting for the host
+	span.AddEvent("query sent")
 
 	// Wait for response
 	select {
 	case response := <-responseCh:
+		duration := time.Since(start)
+		metrics.HostQueryDuration.WithLabelValues(messageType).Observe(duration.Seconds())
+		logger.Debug("host query answered", "duration", duration)
 		return response, nil
 	case <-ctx.Done():
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return nil, ws_errors.TimeoutErr
-		}
-		return nil, conn.getCloseError()
+		err = conn.contextError(ctx)
+		countTimeout(messageType, err)
+		logger.Warn("host query not answered", "error", err)
+		return nil, err
+	}
+}
+
+// messageTypeLabel returns the message type of the query as a metrics label
+func messageTypeLabel(query [][]byte) string {
+	if len(query) == 0 || len(query[0]) < 2 {
+		return "unknown"
+	}
+	return metrics.CodeLabel(helpers.BinaryToUint16(query[0]))
+}
+
+func countTimeout(messageType string, err error) {
+	if errors.Is(err, ws_errors.TimeoutErr) {
+		metrics.HostQueryTimeouts.WithLabelValues(messageType).Inc()
+	}
+}
+
+func (conn *defaultHostConn) sendOnFlow(flow FlowId, query [][]byte) error {
+	if err := conn.getCloseError(); err != nil {
+		return err
 	}
+
+	if err := conn.scheduler.admit(flow); err != nil {
+		return err
+	}
+	defer conn.scheduler.release(flow)
+
+	ctx, cancel := context.WithTimeout(conn.ctx, DefaultQueryTimeout)
+	defer cancel()
+
+	return conn.enqueue(ctx, flow, PriorityBulk, addQueryIdToQuery(query, conn.newQueryId(PriorityBulk)))
+}
+
+// enqueue queues the message in its flow and waits until the sending goroutine has written it. A message
+// still queued when ctx is done is not written at all.
+func (conn *defaultHostConn) enqueue(ctx context.Context, flow FlowId, priority Priority, parts [][]byte) error {
+	msg := newQueuedMessage(parts)
+	conn.scheduler.push(flow, priority, msg)
+
+	select {
+	case <-msg.written:
+		return nil
+	case <-ctx.Done():
+		msg.abandoned.Store(true)
+		return conn.contextError(ctx)
+	}
+}
+
+func (conn *defaultHostConn) contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ws_errors.TimeoutErr
+	}
+	return conn.getCloseError()
+}
+
+func (conn *defaultHostConn) Capabilities() Capabilities {
+	return Capabilities(conn.capabilities.Load())
+}
+
+func (conn *defaultHostConn) SetCapabilities(capabilities Capabilities) {
+	conn.capabilities.Store(uint32(capabilities))
+}
+
+func (conn *defaultHostConn) RTT() time.Duration {
+	return time.Duration(conn.rtt.Load())
+}
+
+func (conn *defaultHostConn) ConnectedAt() time.Time {
+	return conn.startedAt
+}
+
+func (conn *defaultHostConn) RemoteAddr() string {
+	if addr := conn.remoteAddr.Load(); addr != nil {
+		return *addr
+	}
+	return ""
+}
+
+func (conn *defaultHostConn) SetRemoteAddr(addr string) {
+	conn.remoteAddr.Store(&addr)
+}
+
+func (conn *defaultHostConn) SetPushHandler(handler func(msg []byte)) {
+	conn.pushHandler.Store(&handler)
 }
 
 func (conn *defaultHostConn) Close() {
@@ -159,29 +396,35 @@ func (conn *defaultHostConn) send() {
 	}()
 
 	for {
-		select {
-		case <-conn.ctx.Done():
-			return
-		case query := <-conn.queryCh:
-			w, err := conn.ws.NextWriter(websocket.BinaryMessage)
-			if err != nil {
-				conn.closeWithError(fmt.Errorf("hostconn nextwriter error: %w", err))
+		msg := conn.scheduler.pop()
+		if msg == nil {
+			select {
+			case <-conn.ctx.Done():
 				return
+			case <-conn.scheduler.wake:
 			}
+			continue
+		}
 
-			for _, part := range query {
-				if _, err = w.Write(part); err != nil {
-					_ = w.Close()
-					conn.closeWithError(fmt.Errorf("hostconn write error: %w", err))
-					return
-				}
-			}
+		w, err := conn.ws.NextWriter(websocket.BinaryMessage)
+		if err != nil {
+			conn.closeWithError(fmt.Errorf("hostconn nextwriter error: %w", err))
+			return
+		}
 
-			if err = w.Close(); err != nil {
-				conn.closeWithError(fmt.Errorf("hostconn close writer error: %w", err))
+		for _, part := range msg.parts {
+			if _, err = w.Write(part); err != nil {
+				_ = w.Close()
+				conn.closeWithError(fmt.Errorf("hostconn write error: %w", err))
 				return
 			}
 		}
+
+		if err = w.Close(); err != nil {
+			conn.closeWithError(fmt.Errorf("hostconn close writer error: %w", err))
+			return
+		}
+		close(msg.written)
 	}
 }
 
@@ -207,9 +450,16 @@ func (conn *defaultHostConn) listen() {
 					return
 				}
 
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					conn.closeWithError(fmt.Errorf("host has not answered pings: %w", ws_errors.ConnectionClosedErr))
+					return
+				}
+
 				conn.closeWithError(err)
 				return
 			}
+			conn.extendReadDeadline()
 
 			if err = conn.handleResponse(response); err != nil {
 				conn.closeWithError(err)
@@ -219,12 +469,58 @@ func (conn *defaultHostConn) listen() {
 	}
 }
 
+// ping pings the host every interval with the time elapsed since the connection has started as the payload,
+// which the host sends back in its pong
+func (conn *defaultHostConn) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.ctx.Done():
+			return
+		case <-ticker.C:
+			payload := helpers.Uint64ToBinary(uint64(time.Since(conn.startedAt)))
+			err := conn.ws.WriteControl(websocket.PingMessage, payload, time.Now().Add(pingWriteTimeout))
+			if err != nil {
+				conn.closeWithError(fmt.Errorf("hostconn ping error: %w", err))
+				return
+			}
+		}
+	}
+}
+
+func (conn *defaultHostConn) handlePong(appData string) error {
+	conn.extendReadDeadline()
+
+	if len(appData) == pingPayloadSize {
+		sentAt := time.Duration(helpers.BinaryToUint64([]byte(appData)))
+		conn.rtt.Store(int64(time.Since(conn.startedAt) - sentAt))
+	}
+
+	return nil
+}
+
+// extendReadDeadline gives the host another readTimeout to send something, after it has just sent a message or a pong
+func (conn *defaultHostConn) extendReadDeadline() {
+	if conn.readTimeout > 0 {
+		_ = conn.ws.SetReadDeadline(time.Now().Add(conn.readTimeout))
+	}
+}
+
 func (conn *defaultHostConn) handleResponse(response []byte) error {
 	queryId, result, err := getResponseFromBinary(response)
 	if err != nil {
 		return err
 	}
 
+	if queryId == pushQueryId {
+		if handler := conn.pushHandler.Load(); handler != nil {
+			(*handler)(result)
+		}
+		return nil
+	}
+
 	conn.responseChannelsMu.Lock()
 	defer conn.responseChannelsMu.Unlock()
 
@@ -242,8 +538,23 @@ func (conn *defaultHostConn) handleResponse(response []byte) error {
 	return nil
 }
 
-func (conn *defaultHostConn) createNewResponseChannel() (uint32, <-chan []byte) {
-	id := atomic.AddUint32(&conn.nextQueryId, 1)
+// newQueryId returns the next query ID with the priority hint, skipping the one reserved for pushed messages
+// when the counter wraps around
+func (conn *defaultHostConn) newQueryId(priority Priority) uint32 {
+	var hint uint32
+	if priority >= PriorityBulk {
+		hint = bulkQueryIdFlag
+	}
+
+	for {
+		if id := atomic.AddUint32(&conn.nextQueryId, 1) & queryIdMask; id != pushQueryId {
+			return id | hint
+		}
+	}
+}
+
+func (conn *defaultHostConn) createNewResponseChannel(priority Priority) (uint32, <-chan []byte) {
+	id := conn.newQueryId(priority)
 
 	conn.responseChannelsMu.Lock()
 	defer conn.responseChannelsMu.Unlock()
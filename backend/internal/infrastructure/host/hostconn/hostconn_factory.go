@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
 	"github.com/gorilla/websocket"
+	"time"
 )
 
 type HostConnFactory interface {
@@ -18,7 +19,16 @@ type HostConnFactory interface {
 // will be terminated.
 //
 // The WebSocket connection should be established and ready for communication.
-type DefaultHostConnFactory struct{}
+type DefaultHostConnFactory struct {
+	// PingInterval is how often hosts are pinged, 0 disables pings and the detection of dead hosts
+	PingInterval time.Duration
+	// PongTimeout is how long a host may stay silent after a ping is due before its connection is closed
+	PongTimeout time.Duration
+	// MaxPendingQueries is how many queries and messages may be pending on a host at once, 0 means no limit
+	MaxPendingQueries int
+	// MaxFlowPendingQueries is how many of them a single flow may have pending, 0 means no limit
+	MaxFlowPendingQueries int
+}
 
 func (f *DefaultHostConnFactory) NewHostConn(ctx context.Context, wsConn *websocket.Conn, closeHandler func()) HostConn {
 	ctx, cancel := context.WithCancel(ctx)
@@ -29,7 +39,8 @@ func (f *DefaultHostConnFactory) NewHostConn(ctx context.Context, wsConn *websoc
 		cancelFunc:       cancel,
 		closeHandler:     closeHandler,
 		responseChannels: make(map[uint32]chan []byte),
-		queryCh:          make(chan [][]byte),
+		scheduler:        newScheduler(f.MaxPendingQueries, f.MaxFlowPendingQueries),
+		startedAt:        time.Now(),
 	}
 
 	originalCloseHandler := conn.ws.CloseHandler()
@@ -39,6 +50,13 @@ func (f *DefaultHostConnFactory) NewHostConn(ctx context.Context, wsConn *websoc
 		return nil
 	})
 
+	conn.ws.SetPongHandler(conn.handlePong)
+	if f.PingInterval > 0 {
+		conn.readTimeout = f.PingInterval + f.PongTimeout
+		conn.extendReadDeadline()
+		go conn.ping(f.PingInterval)
+	}
+
 	go conn.listen()
 	go conn.send()
 
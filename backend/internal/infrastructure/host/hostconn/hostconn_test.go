@@ -1,12 +1,24 @@
 package hostconn
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"golang.org/x/sync/errgroup"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"runtime"
@@ -65,6 +77,13 @@ func (ts *testServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			response = append(response, []byte("pong")...)
 		case "timeout":
 			continue
+		case "query id":
+			response = append(response, queryID...)
+		case "push":
+			if err := conn.WriteMessage(websocket.BinaryMessage, []byte("\x00\x00\x00\x00pushed")); err != nil {
+				return
+			}
+			response = append(response, []byte("pong")...)
 		case "close":
 			conn.WriteMessage(websocket.BinaryMessage, response)
 			return
@@ -143,7 +162,7 @@ func TestQueryWithCustomTimeout(t *testing.T) {
 	conn := createTestConnection(t, server)
 	defer conn.Close()
 
-	response, err := conn.QueryWithTimeout(5*time.Second, []byte("ping"))
+	response, err := conn.QueryWithTimeout(5*time.Second, PriorityControl, []byte("ping"))
 	assert.NoError(t, err)
 	assert.Equal(t, "pong", string(response))
 }
@@ -155,7 +174,7 @@ func TestQueryZeroTimeout(t *testing.T) {
 	conn := createTestConnection(t, server)
 	defer conn.Close()
 
-	_, err := conn.QueryWithTimeout(0, []byte("timeout"))
+	_, err := conn.QueryWithTimeout(0, PriorityControl, []byte("timeout"))
 	assert.ErrorIs(t, err, ws_errors.TimeoutErr)
 }
 
@@ -166,8 +185,72 @@ func TestQueryTimeout(t *testing.T) {
 	conn := createTestConnection(t, server)
 	defer conn.Close()
 
-	_, err := conn.QueryWithTimeout(100*time.Millisecond, []byte("timeout"))
+	_, err := conn.QueryWithTimeout(100*time.Millisecond, PriorityControl, []byte("timeout"))
+	assert.ErrorIs(t, err, ws_errors.TimeoutErr)
+}
+
+func TestQueryMetrics(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn := createTestConnection(t, server)
+	defer conn.Close()
+
+	messageType := messageTypeLabel([][]byte{[]byte("timeout")})
+	timeouts := metrics.HostQueryTimeouts.WithLabelValues(messageType)
+	before := testutil.ToFloat64(timeouts)
+
+	_, err := conn.QueryWithTimeout(100*time.Millisecond, PriorityControl, []byte("timeout"))
 	assert.ErrorIs(t, err, ws_errors.TimeoutErr)
+	assert.Equal(t, before+1, testutil.ToFloat64(timeouts))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.HostQueriesInFlight.WithLabelValues(messageType)))
+}
+
+func TestQueryLogging(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn := createTestConnection(t, server)
+	defer conn.Close()
+
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil)).With("request_id", "abc")
+
+	_, err := conn.WithContext(logging.WithLogger(context.Background(), logger)).QueryWithTimeout(100*time.Millisecond, PriorityControl, []byte("timeout"))
+	assert.ErrorIs(t, err, ws_errors.TimeoutErr)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "host query not answered", record["msg"])
+	assert.Equal(t, "abc", record["request_id"])
+	assert.Contains(t, record, "query_id")
+}
+
+func TestQueryTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(provider)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	server := newTestServer()
+	defer server.close()
+
+	conn := createTestConnection(t, server)
+	defer conn.Close()
+
+	ctx, request := otel.Tracer("test").Start(context.Background(), "request")
+	_, err := conn.WithContext(ctx).QueryWithTimeout(100*time.Millisecond, PriorityControl, []byte("timeout"))
+	request.End()
+	assert.ErrorIs(t, err, ws_errors.TimeoutErr)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	query := spans[0]
+	assert.Equal(t, "hostconn.Query", query.Name())
+	assert.Equal(t, request.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Equal(t, codes.Error, query.Status().Code)
+	require.NotEmpty(t, query.Events())
+	assert.Equal(t, "query sent", query.Events()[0].Name)
 }
 
 func TestConcurrentQueries(t *testing.T) {
@@ -229,7 +312,7 @@ func TestHostCloseConnection(t *testing.T) {
 	conn := createTestConnection(t, server)
 	defer conn.Close()
 
-	returnMessage, err := conn.QueryWithTimeout(1*time.Second, []byte("close"))
+	returnMessage, err := conn.QueryWithTimeout(1*time.Second, PriorityControl, []byte("close"))
 	require.NoError(t, err)
 	assert.Empty(t, returnMessage)
 
@@ -255,7 +338,7 @@ func TestLargeMessage(t *testing.T) {
 		largePayload[i] = byte(i % 256)
 	}
 
-	response, err := conn.QueryWithTimeout(30*time.Second, largePayload)
+	response, err := conn.QueryWithTimeout(30*time.Second, PriorityControl, largePayload)
 	assert.NoError(t, err)
 
 	expected := append([]byte("echo: "), largePayload...)
@@ -292,7 +375,7 @@ func TestInvalidResponse(t *testing.T) {
 	hostConn := (&DefaultHostConnFactory{}).NewHostConn(context.Background(), conn, func() {})
 	defer hostConn.Close()
 
-	_, err = hostConn.QueryWithTimeout(2*time.Second, []byte("test"))
+	_, err = hostConn.QueryWithTimeout(2*time.Second, PriorityControl, []byte("test"))
 	require.Error(t, err)
 	assert.EqualError(t, err, "invalid message body error")
 }
@@ -326,3 +409,198 @@ func TestQueryAfterContextCancel(t *testing.T) {
 	assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
 	assert.True(t, server.closeHandlerCalled)
 }
+
+func TestSendWithoutResponse(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn := createTestConnection(t, server)
+	defer conn.Close()
+
+	// The response to the sent message is dropped, so the following query gets its own response
+	err := conn.Send([]byte("abc"))
+	require.NoError(t, err)
+
+	response, err := conn.Query([]byte("ping"))
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(response))
+}
+
+func TestSendAfterClose(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn := createTestConnection(t, server)
+	conn.Close()
+
+	err := conn.Send([]byte("abc"))
+	assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+}
+
+func TestPushedMessages(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn := createTestConnection(t, server)
+	defer conn.Close()
+
+	// Without a handler the pushed message is dropped
+	response, err := conn.Query([]byte("push"))
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(response))
+
+	// The host pushes before answering, so the handler has run by the time the response arrives
+	var pushed []string
+	conn.SetPushHandler(func(msg []byte) {
+		pushed = append(pushed, string(msg))
+	})
+
+	response, err = conn.Query([]byte("push"))
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(response))
+	assert.Equal(t, []string{"pushed"}, pushed)
+}
+
+func TestPingMeasuresRTT(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(server.url(), nil)
+	require.NoError(t, err)
+
+	// The test server answers pings while it waits for queries
+	factory := &DefaultHostConnFactory{PingInterval: 10 * time.Millisecond, PongTimeout: time.Second}
+	hostConn := factory.NewHostConn(context.Background(), conn, func() {})
+	defer hostConn.Close()
+
+	assert.Equal(t, time.Duration(0), hostConn.RTT())
+	assert.Eventually(t, func() bool {
+		return hostConn.RTT() > 0
+	}, time.Second, 5*time.Millisecond)
+
+	response, err := hostConn.Query([]byte("ping"))
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(response))
+}
+
+func TestDeadHostIsClosed(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	release := make(chan struct{})
+	defer close(release)
+
+	// The host never reads, so it never answers pings
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+
+	closed := make(chan struct{})
+	factory := &DefaultHostConnFactory{PingInterval: 20 * time.Millisecond, PongTimeout: 20 * time.Millisecond}
+	hostConn := factory.NewHostConn(context.Background(), conn, func() {
+		close(closed)
+	})
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("dead host has not been closed")
+	}
+
+	_, err = hostConn.Query([]byte("ping"))
+	assert.ErrorIs(t, err, ws_errors.ConnectionClosedErr)
+}
+
+func TestSchedulerTakesTurnsBetweenFlows(t *testing.T) {
+	s := newScheduler(0, 0)
+	bulk := NewFlowId()
+	browsing := NewFlowId()
+
+	for _, name := range []string{"chunk 1", "chunk 2", "chunk 3"} {
+		s.push(bulk, PriorityControl, newQueuedMessage([][]byte{[]byte(name)}))
+	}
+	abandoned := newQueuedMessage([][]byte{[]byte("abandoned")})
+	abandoned.abandoned.Store(true)
+	s.push(browsing, PriorityControl, abandoned)
+	s.push(browsing, PriorityControl, newQueuedMessage([][]byte{[]byte("metadata")}))
+
+	var order []string
+	for msg := s.pop(); msg != nil; msg = s.pop() {
+		order = append(order, string(msg.parts[0]))
+	}
+
+	assert.Equal(t, []string{"chunk 1", "metadata", "chunk 2", "chunk 3"}, order)
+}
+
+func TestSchedulerWritesControlAheadOfBulk(t *testing.T) {
+	s := newScheduler(0, 0)
+	download := NewFlowId()
+
+	s.push(download, PriorityBulk, newQueuedMessage([][]byte{[]byte("chunk 1")}))
+	s.push(download, PriorityBulk, newQueuedMessage([][]byte{[]byte("chunk 2")}))
+	s.push(NewFlowId(), PriorityControl, newQueuedMessage([][]byte{[]byte("metadata")}))
+	s.push(download, PriorityControl, newQueuedMessage([][]byte{[]byte("completion")}))
+
+	var order []string
+	for msg := s.pop(); msg != nil; msg = s.pop() {
+		order = append(order, string(msg.parts[0]))
+	}
+
+	assert.Equal(t, []string{"metadata", "completion", "chunk 1", "chunk 2"}, order)
+}
+
+func TestQueryIdCarriesPriorityHint(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn := createTestConnection(t, server)
+	defer conn.Close()
+
+	response, err := conn.QueryWithTimeout(time.Second, PriorityBulk, []byte("query id"))
+	require.NoError(t, err)
+	require.Len(t, response, queryIdSizeInBytes)
+	assert.NotZero(t, binary.BigEndian.Uint32(response)&bulkQueryIdFlag)
+
+	response, err = conn.Query([]byte("query id"))
+	require.NoError(t, err)
+	require.Len(t, response, queryIdSizeInBytes)
+	assert.Zero(t, binary.BigEndian.Uint32(response)&bulkQueryIdFlag)
+}
+
+func TestHostSaturation(t *testing.T) {
+	server := newTestServer()
+	defer server.close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(server.url(), nil)
+	require.NoError(t, err)
+
+	factory := &DefaultHostConnFactory{MaxPendingQueries: 2, MaxFlowPendingQueries: 1}
+	hostConn := factory.NewHostConn(context.Background(), conn, func() {})
+	defer hostConn.Close()
+
+	// The test server never answers these, so they stay pending until they time out
+	download := hostConn.Flow(NewFlowId())
+	go download.QueryWithTimeout(time.Second, PriorityControl, []byte("timeout"))
+	require.Eventually(t, func() bool {
+		_, err := download.Query([]byte("ping"))
+		return errors.Is(err, ws_errors.HostSaturatedErr)
+	}, time.Second, 5*time.Millisecond)
+
+	// Other flows are not limited by the saturated one
+	response, err := hostConn.Query([]byte("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(response))
+
+	go hostConn.QueryWithTimeout(time.Second, PriorityControl, []byte("timeout"))
+	assert.Eventually(t, func() bool {
+		err := hostConn.Flow(NewFlowId()).Send([]byte("abc"))
+		return errors.Is(err, ws_errors.HostSaturatedErr)
+	}, time.Second, 5*time.Millisecond)
+}
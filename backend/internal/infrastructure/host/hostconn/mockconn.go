@@ -1,12 +1,29 @@
 package hostconn
 
 import (
+	"context"
 	"github.com/stretchr/testify/mock"
+	"sync"
 	"time"
 )
 
 type MockConn struct {
 	mock.Mock
+
+	// HostCapabilities is read and written by Capabilities and SetCapabilities without recording calls
+	HostCapabilities Capabilities
+	// RoundTripTime is returned by RTT without recording the call
+	RoundTripTime time.Duration
+	// StartedAt is returned by ConnectedAt without recording the call
+	StartedAt time.Time
+	// Addr is read and written by RemoteAddr and SetRemoteAddr without recording calls
+	Addr string
+	// PushHandler is set by SetPushHandler without recording the call, so tests can push messages by calling it
+	PushHandler func(msg []byte)
+	// Flows collects the IDs passed to Flow, which returns the mock itself without recording the call.
+	// WithContext returns the mock itself as well.
+	Flows   []FlowId
+	flowsMu sync.Mutex
 }
 
 func (m *MockConn) Query(query ...[]byte) ([]byte, error) {
@@ -18,8 +35,8 @@ func (m *MockConn) Query(query ...[]byte) ([]byte, error) {
 	return b, args.Error(1)
 }
 
-func (m *MockConn) QueryWithTimeout(timeout time.Duration, query ...[]byte) ([]byte, error) {
-	args := m.Called(query, timeout)
+func (m *MockConn) QueryWithTimeout(timeout time.Duration, priority Priority, query ...[]byte) ([]byte, error) {
+	args := m.Called(query, timeout, priority)
 	var b []byte
 	if args.Get(0) != nil {
 		b = args.Get(0).([]byte)
@@ -27,6 +44,51 @@ func (m *MockConn) QueryWithTimeout(timeout time.Duration, query ...[]byte) ([]b
 	return b, args.Error(1)
 }
 
+func (m *MockConn) Send(query ...[]byte) error {
+	args := m.Called(query)
+	return args.Error(0)
+}
+
+func (m *MockConn) Capabilities() Capabilities {
+	return m.HostCapabilities
+}
+
+func (m *MockConn) SetCapabilities(capabilities Capabilities) {
+	m.HostCapabilities = capabilities
+}
+
+func (m *MockConn) RTT() time.Duration {
+	return m.RoundTripTime
+}
+
+func (m *MockConn) ConnectedAt() time.Time {
+	return m.StartedAt
+}
+
+func (m *MockConn) RemoteAddr() string {
+	return m.Addr
+}
+
+func (m *MockConn) SetRemoteAddr(addr string) {
+	m.Addr = addr
+}
+
+func (m *MockConn) SetPushHandler(handler func(msg []byte)) {
+	m.PushHandler = handler
+}
+
+func (m *MockConn) Flow(id FlowId) HostConn {
+	m.flowsMu.Lock()
+	defer m.flowsMu.Unlock()
+
+	m.Flows = append(m.Flows, id)
+	return m
+}
+
+func (m *MockConn) WithContext(ctx context.Context) HostConn {
+	return m
+}
+
 func (m *MockConn) Close() {
 	m.Called()
 }
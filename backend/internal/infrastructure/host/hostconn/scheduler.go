@@ -0,0 +1,178 @@
+package hostconn
+
+import (
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"sync"
+	"sync/atomic"
+)
+
+// FlowId identifies a flow, a sequence of messages sent to a host. Messages of a flow with the same priority are
+// delivered in the order they have been sent in. Messages of different flows are written to the host in turns, one message of every waiting flow at
+// a time, so a flow with many messages waiting does not hold back the others.
+type FlowId uint64
+
+// DefaultFlow is the flow of queries sent with a HostConn which is not a view of another flow
+const DefaultFlow FlowId = 0
+
+var lastFlowId atomic.Uint64
+
+// NewFlowId returns a flow ID not returned before, never DefaultFlow
+func NewFlowId() FlowId {
+	return FlowId(lastFlowId.Add(1))
+}
+
+// queuedMessage is a message waiting in its flow's queue for the sending goroutine
+type queuedMessage struct {
+	parts [][]byte
+	// written is closed once the message has been written to the host
+	written chan struct{}
+	// abandoned is set when the sender has given up waiting, so the message is not written anymore
+	abandoned atomic.Bool
+}
+
+func newQueuedMessage(parts [][]byte) *queuedMessage {
+	return &queuedMessage{
+		parts:   parts,
+		written: make(chan struct{}),
+	}
+}
+
+// Priority decides which queued messages are written to the host first. Messages of a higher priority are written
+// ahead of all queued messages of lower ones, flows take turns only within a priority.
+type Priority uint8
+
+const (
+	// PriorityControl is for small queries a client is waiting on, like metadata, listings and stream control
+	PriorityControl Priority = iota
+	// PriorityBulk is for queries carrying or requesting file data, which can wait behind control queries
+	PriorityBulk
+
+	priorityCount
+)
+
+// lane holds the queued messages of a single priority
+type lane struct {
+	queues map[FlowId][]*queuedMessage
+	// ready holds the flows with queued messages in the order they get their next turn
+	ready []FlowId
+}
+
+// scheduler queues the messages of every flow of a host connection and hands them to the sending goroutine,
+// highest priority first and round robin between flows within a priority. It also limits how many messages may be
+// pending, from being queued until their response has arrived, for the whole host and for each flow.
+type scheduler struct {
+	mu sync.Mutex
+
+	lanes [priorityCount]lane
+	// wake is signalled when a message is queued
+	wake chan struct{}
+
+	pending        int
+	pendingByFlow  map[FlowId]int
+	maxPending     int
+	maxFlowPending int
+}
+
+func newScheduler(maxPending int, maxFlowPending int) *scheduler {
+	s := &scheduler{
+		wake:           make(chan struct{}, 1),
+		pendingByFlow:  make(map[FlowId]int),
+		maxPending:     maxPending,
+		maxFlowPending: maxFlowPending,
+	}
+	for i := range s.lanes {
+		s.lanes[i].queues = make(map[FlowId][]*queuedMessage)
+	}
+
+	return s
+}
+
+// admit reserves a pending message for the flow. It fails with HostSaturatedErr if the host or the flow already
+// has the maximum number of pending messages. Every successful admit has to be followed by release.
+func (s *scheduler) admit(flow FlowId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxPending > 0 && s.pending >= s.maxPending {
+		return ws_errors.HostSaturatedErr
+	}
+	if s.maxFlowPending > 0 && s.pendingByFlow[flow] >= s.maxFlowPending {
+		return ws_errors.HostSaturatedErr
+	}
+
+	s.pending++
+	s.pendingByFlow[flow]++
+
+	return nil
+}
+
+func (s *scheduler) release(flow FlowId) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending--
+	if s.pendingByFlow[flow]--; s.pendingByFlow[flow] <= 0 {
+		delete(s.pendingByFlow, flow)
+	}
+}
+
+func (s *scheduler) push(flow FlowId, priority Priority, msg *queuedMessage) {
+	s.mu.Lock()
+	l := &s.lanes[min(priority, priorityCount-1)]
+	queue, waiting := l.queues[flow]
+	l.queues[flow] = append(queue, msg)
+	if !waiting {
+		l.ready = append(l.ready, flow)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next message to write, nil if there is none. Abandoned messages are dropped without using up
+// the turn of their flow.
+func (s *scheduler) pop() *queuedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.lanes {
+		if msg := s.lanes[i].pop(); msg != nil {
+			return msg
+		}
+	}
+
+	return nil
+}
+
+func (l *lane) pop() *queuedMessage {
+	for len(l.ready) > 0 {
+		flow := l.ready[0]
+		l.ready = l.ready[1:]
+
+		queue := l.queues[flow]
+		var msg *queuedMessage
+		for len(queue) > 0 && msg == nil {
+			if !queue[0].abandoned.Load() {
+				msg = queue[0]
+			}
+			queue[0] = nil
+			queue = queue[1:]
+		}
+
+		if len(queue) > 0 {
+			l.queues[flow] = queue
+			l.ready = append(l.ready, flow)
+		} else {
+			delete(l.queues, flow)
+		}
+
+		if msg != nil {
+			return msg
+		}
+	}
+
+	return nil
+}
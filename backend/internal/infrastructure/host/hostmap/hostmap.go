@@ -3,10 +3,13 @@ package hostmap
 import (
 	"context"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
-	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/logging"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/metrics"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -17,15 +20,24 @@ type HostMap interface {
 	Add(ws *websocket.Conn, id uuid.UUID) error
 	Remove(id uuid.UUID)
 	Get(id uuid.UUID) (hostconn.HostConn, bool)
+	// All returns a snapshot of the connected hosts
+	All() map[uuid.UUID]hostconn.HostConn
+	// OnDisconnect sets the function called with the ID of every host which has disconnected. A host reconnecting
+	// with the same ID is not added before the handler has returned.
+	OnDisconnect(handler func(id uuid.UUID))
 }
 
 // defaultHostMap provides thread-safe storage and management of host connections.
 type defaultHostMap struct {
 	hosts map[uuid.UUID]hostconn.HostConn
-	mu    sync.RWMutex
+	// disconnecting holds the hosts whose disconnect handler is running, the channel is closed once it has returned
+	disconnecting map[uuid.UUID]chan struct{}
+	mu            sync.RWMutex
 
 	ctx             context.Context
 	hostConnFactory hostconn.HostConnFactory
+
+	disconnectHandler atomic.Pointer[func(id uuid.UUID)]
 }
 
 var _ HostMap = &defaultHostMap{}
@@ -33,6 +45,7 @@ var _ HostMap = &defaultHostMap{}
 func NewDefaultHostMap(ctx context.Context, hostConnFactory hostconn.HostConnFactory) HostMap {
 	return &defaultHostMap{
 		hosts:           make(map[uuid.UUID]hostconn.HostConn),
+		disconnecting:   make(map[uuid.UUID]chan struct{}),
 		ctx:             ctx,
 		hostConnFactory: hostConnFactory,
 	}
@@ -50,12 +63,10 @@ func (h *defaultHostMap) AddNew(ws *websocket.Conn) uuid.UUID {
 		}
 	}
 
-	hostConn := h.hostConnFactory.NewHostConn(h.ctx, ws, func() {
-		h.removeWithoutClosing(id)
-	})
-	h.hosts[id] = hostConn
+	h.hosts[id] = h.newHostConn(ws, id)
+	metrics.HostsConnected.Set(float64(len(h.hosts)))
 
-	log.Printf("new host \"%v\" has connected\n", id)
+	slog.Info("new host has connected", logging.HostIdKey, id)
 	return id
 }
 
@@ -63,16 +74,25 @@ func (h *defaultHostMap) Add(ws *websocket.Conn, id uuid.UUID) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if _, ok := h.hosts[id]; ok {
-		return ws_errors.HostAlreadyConnectedErr
+	// Wait for the cleanup of the previous connection of the host, so that it does not undo the new one
+	for {
+		if _, ok := h.hosts[id]; ok {
+			return ws_errors.HostAlreadyConnectedErr
+		}
+
+		disconnected, ok := h.disconnecting[id]
+		if !ok {
+			break
+		}
+		h.mu.Unlock()
+		<-disconnected
+		h.mu.Lock()
 	}
 
-	hostConn := h.hostConnFactory.NewHostConn(h.ctx, ws, func() {
-		h.removeWithoutClosing(id)
-	})
-	h.hosts[id] = hostConn
+	h.hosts[id] = h.newHostConn(ws, id)
+	metrics.HostsConnected.Set(float64(len(h.hosts)))
 
-	log.Printf("host \"%v\" has reconnected\n", id)
+	slog.Info("host has reconnected", logging.HostIdKey, id)
 	return nil
 }
 
@@ -84,7 +104,8 @@ func (h *defaultHostMap) Remove(id uuid.UUID) {
 		host.Close()
 	}
 	delete(h.hosts, id)
-	log.Printf("host \"%v\" has disconnected\n", id)
+	metrics.HostsConnected.Set(float64(len(h.hosts)))
+	slog.Info("host has disconnected", logging.HostIdKey, id)
 }
 
 func (h *defaultHostMap) Get(id uuid.UUID) (hostconn.HostConn, bool) {
@@ -95,10 +116,54 @@ func (h *defaultHostMap) Get(id uuid.UUID) (hostconn.HostConn, bool) {
 	return host, ok
 }
 
-func (h *defaultHostMap) removeWithoutClosing(id uuid.UUID) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+func (h *defaultHostMap) All() map[uuid.UUID]hostconn.HostConn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	hosts := make(map[uuid.UUID]hostconn.HostConn, len(h.hosts))
+	for id, host := range h.hosts {
+		hosts[id] = host
+	}
+	return hosts
+}
+
+func (h *defaultHostMap) OnDisconnect(handler func(id uuid.UUID)) {
+	h.disconnectHandler.Store(&handler)
+}
 
+// newHostConn creates the connection of the host, which removes itself from the map once it is closed. h.mu has
+// to be held until the connection has been added to the map.
+func (h *defaultHostMap) newHostConn(ws *websocket.Conn, id uuid.UUID) hostconn.HostConn {
+	// hostConn is only read with h.mu held, as the connection may close before NewHostConn has returned
+	var hostConn hostconn.HostConn
+	hostConn = h.hostConnFactory.NewHostConn(h.ctx, ws, func() {
+		h.removeWithoutClosing(id, &hostConn)
+	})
+
+	return hostConn
+}
+
+// removeWithoutClosing removes the closed connection of the host and calls the disconnect handler. Nothing is done
+// if the host has already connected again, the handler would clean up after the new connection.
+func (h *defaultHostMap) removeWithoutClosing(id uuid.UUID, hostConn *hostconn.HostConn) {
+	h.mu.Lock()
+	if current, ok := h.hosts[id]; ok && current != *hostConn {
+		h.mu.Unlock()
+		return
+	}
 	delete(h.hosts, id)
-	log.Printf("host \"%v\" has disconnected\n", id)
+	metrics.HostsConnected.Set(float64(len(h.hosts)))
+	disconnected := make(chan struct{})
+	h.disconnecting[id] = disconnected
+	h.mu.Unlock()
+
+	slog.Info("host has disconnected", logging.HostIdKey, id)
+	if handler := h.disconnectHandler.Load(); handler != nil {
+		(*handler)(id)
+	}
+
+	h.mu.Lock()
+	delete(h.disconnecting, id)
+	h.mu.Unlock()
+	close(disconnected)
 }
@@ -20,7 +20,47 @@ func (m *MockConn) Query(query ...[]byte) ([]byte, error) {
 	panic("implement me")
 }
 
-func (m *MockConn) QueryWithTimeout(timeout time.Duration, query ...[]byte) ([]byte, error) {
+func (m *MockConn) QueryWithTimeout(timeout time.Duration, priority hostconn.Priority, query ...[]byte) ([]byte, error) {
+	panic("implement me")
+}
+
+func (m *MockConn) Send(query ...[]byte) error {
+	panic("implement me")
+}
+
+func (m *MockConn) Capabilities() hostconn.Capabilities {
+	panic("implement me")
+}
+
+func (m *MockConn) SetCapabilities(capabilities hostconn.Capabilities) {
+	panic("implement me")
+}
+
+func (m *MockConn) RTT() time.Duration {
+	panic("implement me")
+}
+
+func (m *MockConn) ConnectedAt() time.Time {
+	panic("implement me")
+}
+
+func (m *MockConn) RemoteAddr() string {
+	panic("implement me")
+}
+
+func (m *MockConn) SetRemoteAddr(addr string) {
+	panic("implement me")
+}
+
+func (m *MockConn) SetPushHandler(handler func(msg []byte)) {
+	panic("implement me")
+}
+
+func (m *MockConn) Flow(id hostconn.FlowId) hostconn.HostConn {
+	panic("implement me")
+}
+
+func (m *MockConn) WithContext(ctx context.Context) hostconn.HostConn {
 	panic("implement me")
 }
 
@@ -186,6 +226,33 @@ func TestDefaultHostMap_Get(t *testing.T) {
 	})
 }
 
+func TestDefaultHostMap_All(t *testing.T) {
+	t.Run("returns a snapshot of the connected hosts", func(t *testing.T) {
+		ctx := context.Background()
+		factory := &MockHostConnFactory{}
+		mockConn1 := &MockConn{}
+		mockConn2 := &MockConn{}
+		mockWebSocketConn1 := &websocket.Conn{}
+		mockWebSocketConn2 := &websocket.Conn{}
+
+		factory.On("NewHostConn", ctx, mockWebSocketConn1, mock.AnythingOfType("func()")).Return(mockConn1)
+		factory.On("NewHostConn", ctx, mockWebSocketConn2, mock.AnythingOfType("func()")).Return(mockConn2)
+
+		hostMap := NewDefaultHostMap(ctx, factory)
+
+		id1 := hostMap.AddNew(mockWebSocketConn1)
+		id2 := hostMap.AddNew(mockWebSocketConn2)
+
+		hosts := hostMap.All()
+		assert.Equal(t, map[uuid.UUID]hostconn.HostConn{id1: mockConn1, id2: mockConn2}, hosts)
+
+		// Changing the snapshot does not change the map
+		delete(hosts, id1)
+		_, exists := hostMap.Get(id1)
+		assert.True(t, exists)
+	})
+}
+
 func TestDefaultHostMap_OnCloseCallback(t *testing.T) {
 	t.Run("onClose callback removes connection", func(t *testing.T) {
 		ctx := context.Background()
@@ -217,6 +284,112 @@ func TestDefaultHostMap_OnCloseCallback(t *testing.T) {
 	})
 }
 
+func TestDefaultHostMap_OnDisconnect(t *testing.T) {
+	t.Run("disconnect handler is called with the host ID", func(t *testing.T) {
+		ctx := context.Background()
+		factory := &MockHostConnFactory{}
+		mockConn := &MockConn{}
+		mockWebSocketConn := &websocket.Conn{}
+
+		var capturedOnClose func()
+		factory.On("NewHostConn", ctx, mockWebSocketConn, mock.AnythingOfType("func()")).Return(mockConn).Run(func(args mock.Arguments) {
+			capturedOnClose = args.Get(2).(func())
+		})
+
+		hostMap := NewDefaultHostMap(ctx, factory)
+
+		var disconnected []uuid.UUID
+		hostMap.OnDisconnect(func(id uuid.UUID) {
+			// The host is already gone when the handler is called
+			_, exists := hostMap.Get(id)
+			assert.False(t, exists)
+			disconnected = append(disconnected, id)
+		})
+
+		id := hostMap.AddNew(mockWebSocketConn)
+		capturedOnClose()
+
+		assert.Equal(t, []uuid.UUID{id}, disconnected)
+	})
+
+	t.Run("reconnect waits for the disconnect handler of the previous connection", func(t *testing.T) {
+		ctx := context.Background()
+		factory := &MockHostConnFactory{}
+		oldConn := &MockConn{}
+		newConn := &MockConn{}
+		mockWebSocketConn := &websocket.Conn{}
+
+		var capturedOnClose func()
+		factory.On("NewHostConn", ctx, mockWebSocketConn, mock.AnythingOfType("func()")).Return(oldConn).Run(func(args mock.Arguments) {
+			capturedOnClose = args.Get(2).(func())
+		}).Once()
+		factory.On("NewHostConn", ctx, mockWebSocketConn, mock.AnythingOfType("func()")).Return(newConn).Once()
+
+		hostMap := NewDefaultHostMap(ctx, factory)
+
+		handlerStarted := make(chan struct{})
+		releaseHandler := make(chan struct{})
+		hostMap.OnDisconnect(func(id uuid.UUID) {
+			close(handlerStarted)
+			<-releaseHandler
+		})
+
+		id := hostMap.AddNew(mockWebSocketConn)
+		go capturedOnClose()
+		<-handlerStarted
+
+		added := make(chan error)
+		go func() {
+			added <- hostMap.Add(mockWebSocketConn, id)
+		}()
+		select {
+		case <-added:
+			t.Fatal("host was added again before the disconnect handler returned")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(releaseHandler)
+		assert.NoError(t, <-added)
+		conn, exists := hostMap.Get(id)
+		assert.True(t, exists)
+		assert.Same(t, newConn, conn)
+	})
+
+	t.Run("closing a replaced connection leaves the new one alone", func(t *testing.T) {
+		ctx := context.Background()
+		factory := &MockHostConnFactory{}
+		oldConn := &MockConn{}
+		newConn := &MockConn{}
+		mockWebSocketConn := &websocket.Conn{}
+
+		var capturedOnClose func()
+		factory.On("NewHostConn", ctx, mockWebSocketConn, mock.AnythingOfType("func()")).Return(oldConn).Run(func(args mock.Arguments) {
+			capturedOnClose = args.Get(2).(func())
+		}).Once()
+		factory.On("NewHostConn", ctx, mockWebSocketConn, mock.AnythingOfType("func()")).Return(newConn).Once()
+		oldConn.On("Close").Return().Once()
+
+		hostMap := NewDefaultHostMap(ctx, factory)
+
+		var disconnected []uuid.UUID
+		hostMap.OnDisconnect(func(id uuid.UUID) {
+			disconnected = append(disconnected, id)
+		})
+
+		id := hostMap.AddNew(mockWebSocketConn)
+		hostMap.Remove(id)
+		assert.NoError(t, hostMap.Add(mockWebSocketConn, id))
+
+		// The old connection reports its close only after the host has connected again
+		capturedOnClose()
+
+		assert.Empty(t, disconnected)
+		conn, exists := hostMap.Get(id)
+		assert.True(t, exists)
+		assert.Same(t, newConn, conn)
+	})
+}
+
 func TestDefaultHostMap_EdgeCases(t *testing.T) {
 	t.Run("multiple removes of same ID", func(t *testing.T) {
 		ctx := context.Background()
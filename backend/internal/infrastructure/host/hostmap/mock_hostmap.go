@@ -9,6 +9,9 @@ import (
 
 type MockHostMap struct {
 	mock.Mock
+
+	// DisconnectHandler is set by OnDisconnect without recording the call, so tests can disconnect hosts by calling it
+	DisconnectHandler func(id uuid.UUID)
 }
 
 func (m *MockHostMap) AddNew(ws *websocket.Conn) uuid.UUID {
@@ -36,3 +39,12 @@ func (m *MockHostMap) Get(id uuid.UUID) (hostconn.HostConn, bool) {
 	}
 	return c, args.Bool(1)
 }
+
+func (m *MockHostMap) All() map[uuid.UUID]hostconn.HostConn {
+	args := m.Called()
+	return args.Get(0).(map[uuid.UUID]hostconn.HostConn)
+}
+
+func (m *MockHostMap) OnDisconnect(handler func(id uuid.UUID)) {
+	m.DisconnectHandler = handler
+}
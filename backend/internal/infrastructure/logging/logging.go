@@ -0,0 +1,63 @@
+// Package logging sets up the structured logger of the app and carries request scoped loggers in contexts, so that
+// everything done for one client request, down to the queries sent to hosts, is logged with its request ID.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Formats of the log output
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Attribute keys shared by the packages logging with the request scoped logger
+const (
+	RequestIdKey   = "request_id"
+	HostIdKey      = "host_id"
+	QueryIdKey     = "query_id"
+	MessageTypeKey = "message_type"
+)
+
+type loggerKey struct{}
+
+// Setup makes a logger writing to w in the given format ("text" or "json") from the given level ("debug", "info",
+// "warn" or "error") on the default logger, which the log package writes to as well
+func Setup(w io.Writer, format string, level string) error {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("invalid log format %q, it has to be %q or %q", format, FormatText, FormatJSON)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// WithLogger returns a copy of ctx carrying the logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger when it carries none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+
+	t.Run("success - json output from the level", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, Setup(&out, "json", "warn"))
+
+		slog.Info("dropped")
+		slog.Warn("kept", "answer", 42)
+
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+		assert.Equal(t, "kept", record["msg"])
+		assert.Equal(t, float64(42), record["answer"])
+	})
+
+	t.Run("error - invalid format", func(t *testing.T) {
+		assert.Error(t, Setup(&bytes.Buffer{}, "xml", "info"))
+	})
+
+	t.Run("error - invalid level", func(t *testing.T) {
+		assert.Error(t, Setup(&bytes.Buffer{}, "text", "loud"))
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+
+	var out bytes.Buffer
+	require.NoError(t, Setup(&out, "json", "info"))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware)
+	router.GET("/download", func(ctx *gin.Context) {
+		FromContext(ctx.Request.Context()).Info("downloading")
+		ctx.Status(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download", nil))
+
+	requestId := rec.Header().Get(RequestIdHeader)
+	require.NotEmpty(t, requestId)
+
+	// Both the handler log and the request log carry the request ID
+	decoder := json.NewDecoder(&out)
+	for _, msg := range []string{"downloading", "request handled"} {
+		var record map[string]any
+		require.NoError(t, decoder.Decode(&record))
+		assert.Equal(t, msg, record["msg"])
+		assert.Equal(t, requestId, record[RequestIdKey])
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Same(t, slog.Default(), FromContext(context.Background()))
+}
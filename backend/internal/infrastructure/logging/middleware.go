@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIdHeader is the response header the request ID is sent back in, so clients can report it
+const RequestIdHeader = "X-Request-Id"
+
+// Middleware assigns every request an ID and puts a logger with it in the request context, then logs the request
+// once it has been handled. WebSocket requests are logged when their connection ends.
+func Middleware(ctx *gin.Context) {
+	start := time.Now()
+	requestId := uuid.NewString()
+	logger := slog.Default().With(RequestIdKey, requestId)
+
+	ctx.Request = ctx.Request.WithContext(WithLogger(ctx.Request.Context(), logger))
+	ctx.Header(RequestIdHeader, requestId)
+
+	ctx.Next()
+
+	level := slog.LevelInfo
+	if ctx.Writer.Status() >= 500 {
+		level = slog.LevelError
+	}
+	logger.Log(ctx.Request.Context(), level, "request handled",
+		"method", ctx.Request.Method,
+		"path", ctx.Request.URL.Path,
+		"status", ctx.Writer.Status(),
+		"duration", time.Since(start),
+		"client_ip", ctx.ClientIP(),
+	)
+}
@@ -0,0 +1,99 @@
+// Package metrics holds the Prometheus metrics of the relay. The packages doing the work record them, so every
+// path is covered no matter which controller it has been started by.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "eft"
+
+// Directions of relayed file data, used as the direction label
+const (
+	DirectionDownload = "download"
+	DirectionUpload   = "upload"
+)
+
+var (
+	// HostsConnected is the number of hosts connected right now
+	HostsConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hosts_connected",
+		Help:      "Number of connected hosts.",
+	})
+
+	// HostQueriesInFlight is the number of queries waiting for a host response, by message type
+	HostQueriesInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "host_queries_in_flight",
+		Help:      "Number of queries waiting for a host response, by message type.",
+	}, []string{"message_type"})
+
+	// HostQueryDuration is how long hosts have taken to answer queries, by message type
+	HostQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "host_query_duration_seconds",
+		Help:      "Time from queueing a query until the host has answered it, by message type.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"message_type"})
+
+	// HostQueryTimeouts is the number of queries hosts have not answered in time, by message type
+	HostQueryTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "host_query_timeouts_total",
+		Help:      "Number of queries hosts have not answered in time, by message type.",
+	}, []string{"message_type"})
+
+	// RelayedBytes is the number of file data bytes relayed, by direction
+	RelayedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relayed_bytes_total",
+		Help:      "Number of file data bytes relayed between clients and hosts, by direction.",
+	}, []string{"direction"})
+
+	// ActiveTransfers is the number of downloads and uploads running right now, by direction
+	ActiveTransfers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_transfers",
+		Help:      "Number of running downloads and uploads, by direction.",
+	}, []string{"direction"})
+
+	// ClientErrors is the number of errors sent to clients, over WebSockets and plain HTTP, by error code
+	ClientErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_errors_total",
+		Help:      "Number of errors sent to clients, by error code.",
+	}, []string{"code"})
+
+	// DbQueryDuration is how long SQLite queries have taken, by repository method
+	DbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of database queries, by repository method.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// NoCodeLabel is the code label of errors sent to plain HTTP clients which carry no error code
+const NoCodeLabel = "none"
+
+// CodeLabel formats a message type or error code as a label value
+func CodeLabel(code uint16) string {
+	return strconv.Itoa(int(code))
+}
+
+// ObserveDbQuery records the duration of a database query started at start, meant to be deferred
+func ObserveDbQuery(query string, start time.Time) {
+	DbQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,126 @@
+// Package ratelimit limits how often something may happen per key, such as a client IP address.
+//
+// Every key has a token bucket holding up to a minute of its rate, so a key may use a minute's worth of events
+// at once and then one more each time a token has been refilled.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets which have been refilled completely are dropped
+const sweepInterval = time.Minute
+
+// Limiter limits events per key to a number per minute. A nil Limiter allows everything.
+type Limiter struct {
+	// rate is in events per second
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter of perMinute events per key, it returns nil when perMinute is 0 or less
+func NewLimiter(perMinute int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(perMinute),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token of the key. If there is none it returns false and how long it takes for one to be refilled.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refilledBucket(key, time.Now())
+	if b.tokens < 1 {
+		return false, l.untilRefilled(b)
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// Exhausted reports whether the key has no tokens left, without taking one. If so it also returns how long it takes
+// for one to be refilled.
+func (l *Limiter) Exhausted(key string) (bool, time.Duration) {
+	if l == nil {
+		return false, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refilledBucket(key, time.Now())
+	if b.tokens < 1 {
+		return true, l.untilRefilled(b)
+	}
+
+	return false, 0
+}
+
+// Charge takes a token of the key if there is one left. It is used for events which are counted after they happened.
+func (l *Limiter) Charge(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refilledBucket(key, time.Now())
+	b.tokens = max(b.tokens-1, 0)
+}
+
+func (l *Limiter) refilledBucket(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*l.rate, l.burst)
+	}
+	b.last = now
+	return b
+}
+
+// sweep drops the buckets which would be full by now, they are the same as new ones
+func (l *Limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Limiter) untilRefilled(b *bucket) time.Duration {
+	return time.Duration(math.Ceil((1 - b.tokens) / l.rate * float64(time.Second)))
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	limiter := NewLimiter(3)
+
+	for range 3 {
+		ok, _ := limiter.Allow("10.0.0.1")
+		require.True(t, ok)
+	}
+
+	ok, retryAfter := limiter.Allow("10.0.0.1")
+	assert.False(t, ok)
+	assert.Greater(t, retryAfter, time.Duration(0))
+	assert.LessOrEqual(t, retryAfter, 20*time.Second)
+
+	// Other keys have buckets of their own
+	ok, _ = limiter.Allow("10.0.0.2")
+	assert.True(t, ok)
+}
+
+func TestLimiterRefills(t *testing.T) {
+	limiter := NewLimiter(60)
+	limiter.buckets["10.0.0.1"] = &bucket{tokens: 0, last: time.Now().Add(-time.Second)}
+
+	ok, _ := limiter.Allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("10.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestLimiterChargeAndExhausted(t *testing.T) {
+	limiter := NewLimiter(2)
+
+	exhausted, _ := limiter.Exhausted("10.0.0.1")
+	assert.False(t, exhausted)
+
+	limiter.Charge("10.0.0.1")
+	limiter.Charge("10.0.0.1")
+	limiter.Charge("10.0.0.1")
+
+	exhausted, retryAfter := limiter.Exhausted("10.0.0.1")
+	assert.True(t, exhausted)
+	assert.Greater(t, retryAfter, time.Duration(0))
+}
+
+func TestLimiterSweepsFullBuckets(t *testing.T) {
+	limiter := NewLimiter(60)
+	limiter.buckets["idle"] = &bucket{tokens: 59, last: time.Now().Add(-time.Minute)}
+	limiter.buckets["busy"] = &bucket{tokens: 0, last: time.Now()}
+	limiter.lastSweep = time.Now().Add(-sweepInterval)
+
+	_, _ = limiter.Allow("new")
+
+	assert.NotContains(t, limiter.buckets, "idle")
+	assert.Contains(t, limiter.buckets, "busy")
+	assert.Contains(t, limiter.buckets, "new")
+}
+
+func TestNilLimiterAllowsEverything(t *testing.T) {
+	limiter := NewLimiter(0)
+	require.Nil(t, limiter)
+
+	ok, _ := limiter.Allow("10.0.0.1")
+	assert.True(t, ok)
+	exhausted, _ := limiter.Exhausted("10.0.0.1")
+	assert.False(t, exhausted)
+	limiter.Charge("10.0.0.1")
+}
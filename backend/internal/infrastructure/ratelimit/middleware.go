@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/ws_errors"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware rejects requests with 429 Too Many Requests once their client IP address has gone over the limit
+func Middleware(limiter *Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ok, retryAfter := limiter.Allow(ctx.ClientIP()); !ok {
+			reject(ctx, retryAfter)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// FailureMiddleware counts only the requests which have failed, by the handler adding an error to the gin context.
+// Requests of a client IP address are rejected with 429 Too Many Requests once it has failed too often.
+func FailureMiddleware(limiter *Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if exhausted, retryAfter := limiter.Exhausted(ctx.ClientIP()); exhausted {
+			reject(ctx, retryAfter)
+			return
+		}
+
+		ctx.Next()
+
+		if len(ctx.Errors) > 0 {
+			limiter.Charge(ctx.ClientIP())
+		}
+	}
+}
+
+func reject(ctx *gin.Context, retryAfter time.Duration) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": ws_errors.RateLimitedErr.Error()})
+}
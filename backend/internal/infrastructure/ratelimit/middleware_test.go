@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRouter(middleware gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ok", middleware, func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	router.GET("/fail", middleware, func(ctx *gin.Context) {
+		_ = ctx.Error(errors.New("failed"))
+		ctx.Status(http.StatusOK)
+	})
+
+	return router
+}
+
+func request(router *gin.Engine, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	router.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	router := newTestRouter(Middleware(NewLimiter(2)))
+
+	assert.Equal(t, http.StatusOK, request(router, "/ok").Code)
+	assert.Equal(t, http.StatusOK, request(router, "/ok").Code)
+
+	resp := request(router, "/ok")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "30", resp.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "rate limited error"}`, resp.Body.String())
+}
+
+func TestFailureMiddleware(t *testing.T) {
+	router := newTestRouter(FailureMiddleware(NewLimiter(2)))
+
+	// Successful requests are not counted
+	for range 5 {
+		assert.Equal(t, http.StatusOK, request(router, "/ok").Code)
+	}
+
+	assert.Equal(t, http.StatusOK, request(router, "/fail").Code)
+	assert.Equal(t, http.StatusOK, request(router, "/fail").Code)
+
+	assert.Equal(t, http.StatusTooManyRequests, request(router, "/fail").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(router, "/ok").Code)
+}
@@ -0,0 +1,73 @@
+// Package tracing sets up OpenTelemetry tracing of the relay. Spans are carried in contexts, so everything done for
+// one client request, down to the queries sent to hosts and the database calls, ends up in one trace. Until Setup is
+// called all spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName  = "github.com/Basileus1990/EasyFileTransfer.git"
+	serviceName = "easy-file-transfer-relay"
+)
+
+// Attribute keys shared by the packages starting spans, named the same as the matching log attributes
+const (
+	HostIdKey      = attribute.Key("host_id")
+	QueryIdKey     = attribute.Key("query_id")
+	MessageTypeKey = attribute.Key("message_type")
+)
+
+// DbSystemSqlite marks spans of database calls
+var DbSystemSqlite = attribute.String("db.system", "sqlite")
+
+// Setup exports spans over OTLP/HTTP to the collector at endpointUrl, e.g. "http://localhost:4318", and makes the
+// tracer provider global. Traces of callers sending a traceparent header are continued. Spans are exported in
+// batches, the returned function exports the remaining ones and stops the exporter.
+func Setup(ctx context.Context, endpointUrl string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointUrl))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span carried by ctx and returns a copy of ctx carrying the new span
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// Fail records err on the span and marks the span as failed. It returns err, so that it can be used in return
+// statements.
+func Fail(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
+
+// End ends the span, marking it as failed first if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		_ = Fail(span, err)
+	}
+	span.End()
+}
@@ -2,14 +2,17 @@ package host
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	hostController "github.com/Basileus1990/EasyFileTransfer.git/internal/controllers/host"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/saved_connections_repository"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/app/config"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientconn"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/client/clientsession"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostconn"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/host/hostmap"
 	"github.com/gin-gonic/gin"
@@ -18,6 +21,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -31,6 +35,7 @@ type testContext struct {
 	hostMap           hostmap.HostMap
 	hostService       host.HostService
 	mockRepo          *MockSavedConnectionsRepository
+	mockIndexRepo     *file_index_repository.MockFileIndexRepository
 	clientConnFactory clientconn.ClientConnFactory
 }
 
@@ -51,6 +56,19 @@ func (m *MockSavedConnectionsRepository) AddOrRenew(ctx context.Context, sc save
 	return args.Error(0)
 }
 
+func (m *MockSavedConnectionsRepository) UpdateLastSeen(ctx context.Context, id uuid.UUID, lastSeen time.Time) error {
+	args := m.Called(ctx, id, lastSeen)
+	return args.Error(0)
+}
+
+func (m *MockSavedConnectionsRepository) GetLastSeen(ctx context.Context, id uuid.UUID) (*time.Time, error) {
+	args := m.Called(ctx, id)
+	if lastSeen, ok := args.Get(0).(*time.Time); ok {
+		return lastSeen, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func setupTestEnvironment(t *testing.T) *testContext {
 	t.Helper()
 
@@ -62,8 +80,11 @@ func setupTestEnvironment(t *testing.T) *testContext {
 	mockRepo := &MockSavedConnectionsRepository{}
 	mockRepo.On("AddOrRenew", mock.Anything, mock.Anything).Return(nil).Maybe()
 	mockRepo.On("GetById", mock.Anything, mock.Anything).Return(nil, nil).Maybe()
+	mockRepo.On("UpdateLastSeen", mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
 
-	hostService := host.NewHostService(hostMap, mockRepo)
+	mockIndexRepo := &file_index_repository.MockFileIndexRepository{}
+
+	hostService := host.NewHostService(hostMap, mockRepo, mockIndexRepo, host.BandwidthLimits{}, 1024)
 	clientConnFactory := &clientconn.DefaultClientConnFactory{}
 
 	gin.SetMode(gin.TestMode)
@@ -74,7 +95,8 @@ func setupTestEnvironment(t *testing.T) *testContext {
 		WebsocketCfg: config.WebsocketCfg{
 			BatchSize: 1024,
 		},
-		ClientConnFactory: clientConnFactory,
+		ClientConnFactory:    clientConnFactory,
+		ClientSessionFactory: &clientsession.DefaultClientSessionFactory{},
 	}
 
 	group := router.Group("/api/v1/host")
@@ -90,6 +112,7 @@ func setupTestEnvironment(t *testing.T) *testContext {
 		hostMap:           hostMap,
 		hostService:       hostService,
 		mockRepo:          mockRepo,
+		mockIndexRepo:     mockIndexRepo,
 		clientConnFactory: clientConnFactory,
 	}
 }
@@ -223,6 +246,48 @@ func TestHostReconnect(t *testing.T) {
 	tc.mockRepo.AssertExpectations(t)
 }
 
+// TestHostStatus tests the /status/:hostUuid endpoint end-to-end
+func TestHostStatus(t *testing.T) {
+	tc := setupTestEnvironment(t)
+	defer tc.server.Close()
+
+	hostID, _, hostConn := simulateHostConnection(t, tc)
+	time.Sleep(100 * time.Millisecond)
+
+	getStatus := func() (int, host.HostStatus) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/host/status/%s", tc.server.URL, hostID))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var status host.HostStatus
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		}
+		return resp.StatusCode, status
+	}
+
+	code, status := getStatus()
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Online)
+	assert.NotNil(t, status.OnlineSince)
+	assert.Nil(t, status.LastSeen)
+
+	// Once the host disconnects it is reported offline with the time it has last been seen
+	hostConn.Close()
+	time.Sleep(100 * time.Millisecond)
+	tc.mockRepo.AssertCalled(t, "UpdateLastSeen", mock.Anything, hostID, mock.Anything)
+
+	lastSeen := time.Now().UTC().Truncate(time.Second)
+	tc.mockRepo.On("GetLastSeen", mock.Anything, hostID).Return(&lastSeen, nil)
+
+	code, status = getStatus()
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, status.Online)
+	assert.Nil(t, status.OnlineSince)
+	require.NotNil(t, status.LastSeen)
+	assert.True(t, lastSeen.Equal(*status.LastSeen))
+}
+
 // TestGetResourceMetadata tests the /metadata/:hostUuid/:resourceUuid/* endpoint end-to-end
 func TestGetResourceMetadata(t *testing.T) {
 	tc := setupTestEnvironment(t)
@@ -512,3 +577,90 @@ func TestCreateFile(t *testing.T) {
 
 	time.Sleep(100 * time.Millisecond)
 }
+
+// TestClientSession tests the /session/:hostUuid endpoint end-to-end with a metadata query
+// and a download running over the same socket
+func TestClientSession(t *testing.T) {
+	tc := setupTestEnvironment(t)
+	defer tc.server.Close()
+
+	// Connect a host
+	hostID, _, hostConn := simulateHostConnection(t, tc)
+	defer hostConn.Close()
+
+	resourceID := uuid.New()
+	downloadID := uint32(77)
+	metadataPayload := []byte("file-metadata-content")
+	chunkData := []byte("chunk-data-content")
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			msg := readMessage(t, hostConn, 5*time.Second)
+			queryID := msg[:4]
+			msgType, err := message_types.GetMsgType(msg[4:])
+			require.NoError(t, err)
+
+			response := append([]byte{}, queryID...)
+			switch msgType {
+			case message_types.MetadataQuery:
+				response = append(response, message_types.MetadataResponse.Binary()...)
+				response = append(response, metadataPayload...)
+			case message_types.DownloadInitRequest:
+				response = append(response, message_types.DownloadInitResponse.Binary()...)
+				response = append(response, helpers.Uint32ToBinary(downloadID)...)
+				response = append(response, []byte("init-payload")...)
+			case message_types.ChunkRequest:
+				response = append(response, message_types.ChunkResponse.Binary()...)
+				response = append(response, chunkData...)
+			case message_types.DownloadCompletionRequest:
+				response = append(response, message_types.ACK.Binary()...)
+			}
+			writeMessage(t, hostConn, response)
+		}
+	}()
+
+	url := fmt.Sprintf("%s/api/v1/host/session/%s", tc.wsURL, hostID.String())
+	clientConn := connectWebSocket(t, url)
+	defer clientConn.Close()
+
+	downloadRequestID := helpers.Uint32ToBinary(2)
+	metadataRequestID := helpers.Uint32ToBinary(1)
+
+	// Open the download first and the metadata query while the download is still in progress
+	writeMessage(t, clientConn,
+		downloadRequestID,
+		message_types.DownloadInitRequest.Binary(),
+		helpers.UUIDToBinary(resourceID),
+		[]byte("/test/file.txt\000"),
+	)
+	msg := readMessage(t, clientConn, 5*time.Second)
+	assert.Equal(t, downloadRequestID, msg[:4])
+	msgType, err := message_types.GetMsgType(msg[4:])
+	require.NoError(t, err)
+	assert.Equal(t, message_types.DownloadInitResponse, msgType)
+	assert.Equal(t, []byte("init-payload"), msg[6:])
+
+	writeMessage(t, clientConn,
+		metadataRequestID,
+		message_types.MetadataQuery.Binary(),
+		helpers.UUIDToBinary(resourceID),
+		[]byte("/test\000"),
+	)
+	msg = readMessage(t, clientConn, 5*time.Second)
+	assert.Equal(t, metadataRequestID, msg[:4])
+	msgType, err = message_types.GetMsgType(msg[4:])
+	require.NoError(t, err)
+	assert.Equal(t, message_types.MetadataResponse, msgType)
+	assert.Equal(t, metadataPayload, msg[6:])
+
+	writeMessage(t, clientConn, downloadRequestID, message_types.ChunkRequest.Binary(), helpers.Uint32ToBinary(0))
+	msg = readMessage(t, clientConn, 5*time.Second)
+	assert.Equal(t, downloadRequestID, msg[:4])
+	msgType, err = message_types.GetMsgType(msg[4:])
+	require.NoError(t, err)
+	assert.Equal(t, message_types.ChunkResponse, msgType)
+	assert.Equal(t, chunkData, msg[6:])
+
+	writeMessage(t, clientConn, downloadRequestID, message_types.DownloadCompletionRequest.Binary())
+	time.Sleep(100 * time.Millisecond)
+}
@@ -0,0 +1,457 @@
+package host
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/common/message_types"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/domain/host/file_index_repository"
+	"github.com/Basileus1990/EasyFileTransfer.git/internal/helpers"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// serveFileAsHost answers metadata, download and chunk queries for a single file until the connection is closed.
+// Chunks are at most chunkSize bytes long and start at the requested offset.
+func serveFileAsHost(hostConn *websocket.Conn, name string, content []byte, chunkSize int) {
+	for {
+		_, msg, err := hostConn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		queryID := msg[:4]
+		msg = msg[4:]
+		msgType, err := message_types.GetMsgType(msg)
+		if err != nil {
+			return
+		}
+
+		response := append([]byte{}, queryID...)
+		switch msgType {
+		case message_types.MetadataQuery:
+			item, _ := json.Marshal(map[string]any{"path": "/" + name, "name": name, "kind": "file", "size": len(content)})
+			response = append(response, message_types.MetadataResponse.Binary()...)
+			response = append(response, 0)
+			response = append(response, item...)
+		case message_types.DownloadInitRequest:
+			response = append(response, message_types.DownloadInitResponse.Binary()...)
+			response = append(response, helpers.Uint32ToBinary(1)...)
+			response = append(response, helpers.Uint32ToBinary(uint32((len(content)+chunkSize-1)/chunkSize))...)
+			response = append(response, 0)
+		case message_types.ChunkRequest:
+			offset := int(helpers.BinaryToUint64(msg[2+4 : 2+4+8]))
+			if offset >= len(content) {
+				response = append(response, message_types.EofResponse.Binary()...)
+				break
+			}
+			end := min(offset+chunkSize, len(content))
+			response = append(response, message_types.ChunkResponse.Binary()...)
+			response = append(response, content[offset:end]...)
+		default:
+			response = append(response, message_types.ACK.Binary()...)
+		}
+
+		if err := hostConn.WriteMessage(websocket.BinaryMessage, response); err != nil {
+			return
+		}
+	}
+}
+
+// TestHttpDownloadFile tests the /file/download/:hostUuid/:resourceUuid/* endpoint end-to-end
+func TestHttpDownloadFile(t *testing.T) {
+	tc := setupTestEnvironment(t)
+	defer tc.server.Close()
+
+	hostID, _, hostConn := simulateHostConnection(t, tc)
+	defer hostConn.Close()
+
+	content := []byte("hello world, this is a shared file")
+	go serveFileAsHost(hostConn, "file.txt", content, 5)
+
+	url := fmt.Sprintf("%s/api/v1/host/file/download/%s/%s/file.txt", tc.server.URL, hostID, uuid.New())
+
+	t.Run("whole file", func(t *testing.T) {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, content, body)
+		assert.Equal(t, fmt.Sprint(len(content)), resp.Header.Get("Content-Length"))
+		assert.Equal(t, "attachment; filename=file.txt", resp.Header.Get("Content-Disposition"))
+		assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	})
+
+	t.Run("range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		req.Header.Set("Range", "bytes=6-15")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, content[6:16], body)
+		assert.Equal(t, fmt.Sprintf("bytes 6-15/%d", len(content)), resp.Header.Get("Content-Range"))
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/host/file/download/%s/%s/file.txt", tc.server.URL, uuid.New(), uuid.New()))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	// Give the relay time to end the download streams
+	time.Sleep(100 * time.Millisecond)
+}
+
+// serveDirectoryAsHost answers metadata, download and chunk queries for a single directory of files, sending each
+// file in one chunk, until the connection is closed
+func serveDirectoryAsHost(hostConn *websocket.Conn, name string, files map[string][]byte) {
+	var streams []string
+	for {
+		_, msg, err := hostConn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		queryID := msg[:4]
+		msg = msg[4:]
+		msgType, err := message_types.GetMsgType(msg)
+		if err != nil {
+			return
+		}
+
+		response := append([]byte{}, queryID...)
+		switch msgType {
+		case message_types.MetadataQuery, message_types.DownloadInitRequest:
+			path := strings.TrimSuffix(string(msg[2+16:]), "\000")
+			fileName := strings.TrimPrefix(path, "/"+name+"/")
+			if path == "/"+name {
+				contents := []map[string]any{}
+				for fileName := range files {
+					contents = append(contents, map[string]any{"name": fileName, "kind": "file"})
+				}
+				item, _ := json.Marshal(map[string]any{"name": name, "kind": "directory", "contents": contents})
+				response = append(response, message_types.MetadataResponse.Binary()...)
+				response = append(response, 0)
+				response = append(response, item...)
+			} else if msgType == message_types.MetadataQuery {
+				item, _ := json.Marshal(map[string]any{"name": fileName, "kind": "file", "size": len(files[fileName])})
+				response = append(response, message_types.MetadataResponse.Binary()...)
+				response = append(response, 0)
+				response = append(response, item...)
+			} else {
+				streams = append(streams, fileName)
+				response = append(response, message_types.DownloadInitResponse.Binary()...)
+				response = append(response, helpers.Uint32ToBinary(uint32(len(streams)-1))...)
+				response = append(response, helpers.Uint32ToBinary(1)...)
+				response = append(response, 0)
+			}
+		case message_types.ChunkRequest:
+			content := files[streams[helpers.BinaryToUint32(msg[2:2+4])]]
+			offset := int(helpers.BinaryToUint64(msg[2+4 : 2+4+8]))
+			response = append(response, message_types.ChunkResponse.Binary()...)
+			response = append(response, content[offset:]...)
+		default:
+			response = append(response, message_types.ACK.Binary()...)
+		}
+
+		if err := hostConn.WriteMessage(websocket.BinaryMessage, response); err != nil {
+			return
+		}
+	}
+}
+
+// TestHttpDownloadArchive tests the /archive/{format}/:hostUuid/:resourceUuid/* endpoints end-to-end
+func TestHttpDownloadArchive(t *testing.T) {
+	tc := setupTestEnvironment(t)
+	defer tc.server.Close()
+
+	hostID, _, hostConn := simulateHostConnection(t, tc)
+	defer hostConn.Close()
+
+	files := map[string][]byte{
+		"first.jpg":  []byte("first photo"),
+		"second.jpg": bytes.Repeat([]byte("second photo "), 100),
+	}
+	go serveDirectoryAsHost(hostConn, "album", files)
+
+	t.Run("zip", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/host/archive/zip/%s/%s/album", tc.server.URL, hostID, uuid.New()))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "attachment; filename=album.zip", resp.Header.Get("Content-Disposition"))
+
+		zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		require.NoError(t, err)
+		received := map[string][]byte{}
+		for _, file := range zipReader.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+			entryReader, err := file.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(entryReader)
+			require.NoError(t, err)
+			received[strings.TrimPrefix(file.Name, "album/")] = content
+		}
+		assert.Equal(t, files, received)
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/host/archive/tar.gz/%s/%s/album", tc.server.URL, hostID, uuid.New()))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "attachment; filename=album.tar.gz", resp.Header.Get("Content-Disposition"))
+
+		gzipReader, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		tarReader := tar.NewReader(gzipReader)
+		received := map[string][]byte{}
+		for {
+			header, err := tarReader.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			if header.Typeflag == tar.TypeDir {
+				continue
+			}
+			content, err := io.ReadAll(tarReader)
+			require.NoError(t, err)
+			received[strings.TrimPrefix(header.Name, "album/")] = content
+		}
+		assert.Equal(t, files, received)
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/host/archive/zip/%s/%s/album", tc.server.URL, uuid.New(), uuid.New()))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// receiveFileAsHost accepts a single upload, requesting it chunk by chunk, and passes the received file on
+func receiveFileAsHost(hostConn *websocket.Conn, received chan<- []byte) {
+	var fileSize int
+	var content []byte
+	for {
+		_, msg, err := hostConn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		queryID := msg[:4]
+		msg = msg[4:]
+		msgType, err := message_types.GetMsgType(msg)
+		if err != nil {
+			return
+		}
+
+		response := append([]byte{}, queryID...)
+		switch msgType {
+		case message_types.CreateFileInitRequest:
+			fileSize = int(helpers.BinaryToUint32(msg[2+16 : 2+16+4]))
+			response = append(response, message_types.CreateFileInitResponse.Binary()...)
+			response = append(response, helpers.Uint32ToBinary(1)...)
+		case message_types.CreateFileHostChunkRequest:
+			if len(content) >= fileSize {
+				response = append(response, message_types.CreateFileStreamEnd.Binary()...)
+				received <- content
+				break
+			}
+			response = append(response, message_types.CreateFileChunkRequest.Binary()...)
+			response = append(response, helpers.Uint64ToBinary(uint64(len(content)))...)
+		case message_types.CreateFileChunk:
+			content = append(content, msg[2+4:]...)
+			response = append(response, message_types.ACK.Binary()...)
+		default:
+			response = append(response, message_types.ACK.Binary()...)
+		}
+
+		if err := hostConn.WriteMessage(websocket.BinaryMessage, response); err != nil {
+			return
+		}
+	}
+}
+
+// multipartBody builds a form with a field before the file part, like browsers send
+func multipartBody(t *testing.T, content []byte) (io.Reader, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("description", "uploaded over HTTP"))
+	part, err := writer.CreateFormFile("file", "file.txt")
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	return &body, writer.FormDataContentType()
+}
+
+// TestHttpUploadFile tests the /file/upload/:hostUuid/:resourceUuid/* endpoint end-to-end
+func TestHttpUploadFile(t *testing.T) {
+	content := bytes.Repeat([]byte("uploaded content "), 200)
+
+	t.Run("PUT", func(t *testing.T) {
+		tc := setupTestEnvironment(t)
+		defer tc.server.Close()
+
+		hostID, _, hostConn := simulateHostConnection(t, tc)
+		defer hostConn.Close()
+
+		received := make(chan []byte, 1)
+		go receiveFileAsHost(hostConn, received)
+
+		url := fmt.Sprintf("%s/api/v1/host/file/upload/%s/%s/file.txt", tc.server.URL, hostID, uuid.New())
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(content))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, content, <-received)
+	})
+
+	t.Run("multipart POST", func(t *testing.T) {
+		tc := setupTestEnvironment(t)
+		defer tc.server.Close()
+
+		hostID, _, hostConn := simulateHostConnection(t, tc)
+		defer hostConn.Close()
+
+		received := make(chan []byte, 1)
+		go receiveFileAsHost(hostConn, received)
+
+		body, contentType := multipartBody(t, content)
+		url := fmt.Sprintf("%s/api/v1/host/file/upload/%s/%s/file.txt?uploadFileSize=%d", tc.server.URL, hostID, uuid.New(), len(content))
+		resp, err := http.Post(url, contentType, body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, content, <-received)
+	})
+
+	t.Run("multipart POST without file size", func(t *testing.T) {
+		tc := setupTestEnvironment(t)
+		defer tc.server.Close()
+
+		hostID, _, hostConn := simulateHostConnection(t, tc)
+		defer hostConn.Close()
+
+		body, contentType := multipartBody(t, content)
+		url := fmt.Sprintf("%s/api/v1/host/file/upload/%s/%s/file.txt", tc.server.URL, hostID, uuid.New())
+		resp, err := http.Post(url, contentType, body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("multipart POST longer than declared", func(t *testing.T) {
+		tc := setupTestEnvironment(t)
+		defer tc.server.Close()
+
+		hostID, _, hostConn := simulateHostConnection(t, tc)
+		defer hostConn.Close()
+		go receiveFileAsHost(hostConn, make(chan []byte, 1))
+
+		body, contentType := multipartBody(t, content)
+		url := fmt.Sprintf("%s/api/v1/host/file/upload/%s/%s/file.txt?uploadFileSize=%d", tc.server.URL, hostID, uuid.New(), len(content)-1)
+		resp, err := http.Post(url, contentType, body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		tc := setupTestEnvironment(t)
+		defer tc.server.Close()
+
+		url := fmt.Sprintf("%s/api/v1/host/file/upload/%s/%s/file.txt", tc.server.URL, uuid.New(), uuid.New())
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(content))
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// TestHttpSearchIndex tests the /search/:hostUuid/:resourceUuid endpoint, which answers without the host
+func TestHttpSearchIndex(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		tc := setupTestEnvironment(t)
+		defer tc.server.Close()
+
+		hostID := uuid.New()
+		resourceID := uuid.New()
+		results := []file_index_repository.IndexEntry{
+			{Path: "/photos/holiday.jpg", Name: "holiday.jpg", Kind: "file", Size: 3, Modified: 1700000000000},
+		}
+		tc.mockIndexRepo.On("Search", mock.Anything, hostID, resourceID, "holi", 10).Return(results, nil).Once()
+
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/host/search/%s/%s?q=holi&limit=10", tc.server.URL, hostID, resourceID))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body struct {
+			Results []file_index_repository.IndexEntry `json:"results"`
+		}
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, results, body.Results)
+	})
+
+	t.Run("missing query", func(t *testing.T) {
+		tc := setupTestEnvironment(t)
+		defer tc.server.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/host/search/%s/%s", tc.server.URL, uuid.New(), uuid.New()))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
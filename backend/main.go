@@ -2,30 +2,51 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"github.com/Basileus1990/EasyFileTransfer.git/internal/infrastructure/app"
 	_ "github.com/mattn/go-sqlite3"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	fmt.Println("Starting the app ...")
+	slog.Info("starting the app")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	server, err := app.NewServer(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create the server with error: %v", err)
+		slog.Error("failed to create the server", "error", err)
+		os.Exit(1)
 	}
 
-	fmt.Print("\n####################\n")
-	fmt.Print("## Server started ##\n")
-	fmt.Print("####################\n\n")
-	if err = server.ListenAndServe(); err != nil {
-		log.Fatal("Exited the app with an error: ", err)
+	// Not derived from ctx, which has to stay alive while the server shuts down
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	slog.Info("server started")
+
+	select {
+	case err = <-serveErr:
+		slog.Error("exited the app with an error", "error", err)
+		os.Exit(1)
+	case <-signalCtx.Done():
+	}
+
+	// A second signal kills the app right away
+	stop()
+	slog.Info("shutting down")
+	if err = server.Shutdown(context.Background()); err != nil {
+		slog.Error("failed to shut down gracefully", "error", err)
+		os.Exit(1)
 	}
 
-	// TODO: AddOrRenew a graceful shutdown
-	fmt.Println("\nExited successfully")
+	slog.Info("exited successfully")
 }
@@ -1,4 +1,4 @@
-CREATE TABLE saved_connections (
+CREATE TABLE IF NOT EXISTS saved_connections (
    id UUID PRIMARY KEY,
    key_hash VARCHAR(128),
    created_at TIMESTAMP
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS file_index (
+   id INTEGER PRIMARY KEY,
+   host_id UUID NOT NULL,
+   resource_id UUID NOT NULL,
+   path TEXT NOT NULL,
+   parent_path TEXT NOT NULL,
+   name TEXT NOT NULL,
+   kind VARCHAR(16) NOT NULL,
+   size INTEGER NOT NULL,
+   modified INTEGER NOT NULL,
+   UNIQUE (host_id, resource_id, path)
+);
+
+CREATE INDEX IF NOT EXISTS file_index_parent_path ON file_index (host_id, resource_id, parent_path);
@@ -0,0 +1,22 @@
+-- Applied only once go-sqlite3 is built with the sqlite_fts5 tag. Names indexed before are added by the rebuild.
+CREATE VIRTUAL TABLE IF NOT EXISTS file_index_fts USING fts5(
+   name,
+   content = 'file_index',
+   content_rowid = 'id',
+   tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS file_index_after_insert AFTER INSERT ON file_index BEGIN
+   INSERT INTO file_index_fts (rowid, name) VALUES (new.id, new.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS file_index_after_delete AFTER DELETE ON file_index BEGIN
+   INSERT INTO file_index_fts (file_index_fts, rowid, name) VALUES ('delete', old.id, old.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS file_index_after_update AFTER UPDATE ON file_index BEGIN
+   INSERT INTO file_index_fts (file_index_fts, rowid, name) VALUES ('delete', old.id, old.name);
+   INSERT INTO file_index_fts (rowid, name) VALUES (new.id, new.name);
+END;
+
+INSERT INTO file_index_fts (file_index_fts) VALUES ('rebuild');
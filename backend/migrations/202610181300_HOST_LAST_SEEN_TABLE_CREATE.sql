@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS host_last_seen (
+   host_id UUID PRIMARY KEY,
+   last_seen_at TIMESTAMP NOT NULL
+)
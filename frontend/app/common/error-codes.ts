@@ -14,4 +14,12 @@ export enum ErrorCodes {
     OperationNotAllowed = 11,
     InvalidPath = 13,
     OperationForbidden = 14,
+
+    InvalidResumeToken = 15,
+    ResourceNotDownloadable = 16,
+    FileTooLargeForHost = 17,
+    DestinationExists = 18,
+    CrossShareMoveNotAllowed = 19,
+    HostSaturated = 20,
+    RateLimited = 21,
 }
\ No newline at end of file
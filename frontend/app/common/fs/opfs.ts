@@ -126,6 +126,10 @@ export async function readHandle(handle: FileSystemHandle, path?: string): Promi
         size: await getSize(handle),
     } as Item;
 
+    if (handle.kind === "file") {
+        item.modified = (await (handle as FileSystemFileHandle).getFile()).lastModified;
+    }
+
     if (handle.kind === "directory") {
         item.contents = [];
         for await (const [name, entry] of (handle as FileSystemDirectoryHandle).entries()) {
@@ -95,6 +95,10 @@ export async function readHandleWithPermissions(handle: FileSystemHandle, path?:
         size: await getSize(handle),
     } as Item;
 
+    if (handle.kind === "file") {
+        item.modified = (await (handle as FileSystemFileHandle).getFile()).lastModified;
+    }
+
     if (handle.kind === "directory") {
         item.contents = [];
         item.perms = await getPerms();
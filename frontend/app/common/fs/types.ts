@@ -9,6 +9,7 @@ export interface Item {
     name: string;
     kind: "directory" | "file";
     size: number;
+    modified?: number;
     perms?: DirPermissions;
     contents?: SubItem[];
 }